@@ -0,0 +1,138 @@
+package iradix
+
+import (
+	"sort"
+	"sync"
+)
+
+// accessShards is the number of independently locked maps an AccessTracker
+// spreads its counts across, to reduce contention between readers
+const accessShards = 16
+
+// AccessTracker counts the reads of each key of a tree made through it, such
+// as to drive an eviction policy, without modifying the tree. The counts are
+// kept in a sidecar of sharded maps, and it's safe to use concurrently.
+type AccessTracker struct {
+	tree   *Tree
+	shards [accessShards]accessShard
+}
+
+// accessShard is one of the maps an AccessTracker keeps counts in
+type accessShard struct {
+	mu   sync.Mutex
+	hits map[string]uint64
+}
+
+// AccessCount is a key and the number of times it was read, as returned by
+// AccessTracker.Hottest and AccessTracker.Coldest
+type AccessCount struct {
+	Key  []byte
+	Hits uint64
+}
+
+// NewAccessTracker returns an AccessTracker for reads of the tree
+func (t *Tree) NewAccessTracker() *AccessTracker {
+	a := &AccessTracker{tree: t}
+	for i := range a.shards {
+		a.shards[i].hits = make(map[string]uint64)
+	}
+	return a
+}
+
+// Get looks up a key in the tree, as with Tree.Get, counting a hit for the
+// key if it's present. Hits are counted under the key as it's stored, so on
+// a tree created by NewWithFold, keys that fold the same share a count.
+func (a *AccessTracker) Get(k []byte) (interface{}, bool) {
+	key, v, ok := a.tree.GetFull(k)
+	if ok {
+		s := a.shard(key)
+		s.mu.Lock()
+		s.hits[string(key)]++
+		s.mu.Unlock()
+	}
+	return v, ok
+}
+
+// Hits returns the number of hits counted for the given key
+func (a *AccessTracker) Hits(k []byte) uint64 {
+	key, _, ok := a.tree.GetFull(k)
+	if !ok {
+		return 0
+	}
+	return a.storedHits(key)
+}
+
+// storedHits returns the number of hits counted for a key as it's stored
+func (a *AccessTracker) storedHits(key []byte) uint64 {
+	s := a.shard(key)
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.hits[string(key)]
+}
+
+// Hottest returns up to n of the keys with the most hits, from most to
+// least, with ties in key order. Keys that were never hit aren't included.
+func (a *AccessTracker) Hottest(n int) []AccessCount {
+	if n <= 0 {
+		return nil
+	}
+	var counts []AccessCount
+	for i := range a.shards {
+		s := &a.shards[i]
+		s.mu.Lock()
+		for k, hits := range s.hits {
+			counts = append(counts, AccessCount{Key: []byte(k), Hits: hits})
+		}
+		s.mu.Unlock()
+	}
+	sortAccessCounts(counts, func(a, b uint64) bool { return a > b })
+	if len(counts) > n {
+		counts = counts[:n]
+	}
+	return counts
+}
+
+// Coldest returns up to n of the keys in the tree with the fewest hits,
+// from least to most, with ties in key order. Keys that were never hit are
+// included with no hits, so they're the first candidates for eviction.
+func (a *AccessTracker) Coldest(n int) []AccessCount {
+	if n <= 0 {
+		return nil
+	}
+	counts := make([]AccessCount, 0, a.tree.Len())
+	a.tree.root.Walk(func(k []byte, _ interface{}) bool {
+		counts = append(counts, AccessCount{Key: k, Hits: a.storedHits(k)})
+		return false
+	})
+	sortAccessCounts(counts, func(a, b uint64) bool { return a < b })
+	if len(counts) > n {
+		counts = counts[:n]
+	}
+	return counts
+}
+
+// Tree returns the tree the reads are made from
+func (a *AccessTracker) Tree() *Tree {
+	return a.tree
+}
+
+// shard returns the shard holding the count for the given key, picked by
+// its 32-bit FNV-1a hash
+func (a *AccessTracker) shard(k []byte) *accessShard {
+	h := uint32(2166136261)
+	for _, b := range k {
+		h ^= uint32(b)
+		h *= 16777619
+	}
+	return &a.shards[h%accessShards]
+}
+
+// sortAccessCounts sorts counts by their hits using before, and then by key
+func sortAccessCounts(counts []AccessCount, before func(a, b uint64) bool) {
+	sort.Slice(counts, func(i, j int) bool {
+		if counts[i].Hits != counts[j].Hits {
+			return before(counts[i].Hits, counts[j].Hits)
+		}
+		return string(counts[i].Key) < string(counts[j].Key)
+	})
+}
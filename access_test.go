@@ -0,0 +1,88 @@
+package iradix
+
+import (
+	"reflect"
+	"sync"
+	"testing"
+)
+
+func TestAccessTracker(t *testing.T) {
+	r := New()
+	for _, k := range []string{"a", "b", "c", "d", "e"} {
+		r, _, _ = r.Insert([]byte(k), k)
+	}
+	a := r.NewAccessTracker()
+
+	// Read concurrently, so that b is read most, then d, then a, with c
+	// and e never read
+	reads := map[string]int{"a": 10, "b": 30, "d": 20, "nope": 5}
+	var wg sync.WaitGroup
+	for k, n := range reads {
+		for i := 0; i < n; i++ {
+			wg.Add(1)
+			go func(k string) {
+				defer wg.Done()
+				v, ok := a.Get([]byte(k))
+				if ok != (k != "nope") || ok && v != k {
+					t.Errorf("bad: %s %v %v", k, v, ok)
+				}
+			}(k)
+		}
+	}
+	wg.Wait()
+
+	if hits := a.Hits([]byte("b")); hits != 30 {
+		t.Fatalf("bad: %d", hits)
+	}
+	if hits := a.Hits([]byte("nope")); hits != 0 {
+		t.Fatalf("bad: %d", hits)
+	}
+
+	hottest := []AccessCount{{[]byte("b"), 30}, {[]byte("d"), 20}}
+	if out := a.Hottest(2); !reflect.DeepEqual(out, hottest) {
+		t.Fatalf("bad: %v", out)
+	}
+	if out := a.Hottest(10); len(out) != 3 {
+		t.Fatalf("bad: %v", out)
+	}
+	coldest := []AccessCount{{[]byte("c"), 0}, {[]byte("e"), 0}, {[]byte("a"), 10}}
+	if out := a.Coldest(3); !reflect.DeepEqual(out, coldest) {
+		t.Fatalf("bad: %v", out)
+	}
+
+	for _, n := range []int{0, -1} {
+		if a.Hottest(n) != nil || a.Coldest(n) != nil {
+			t.Fatalf("bad: %d", n)
+		}
+	}
+
+	// The tree itself is untouched
+	if a.Tree() != r {
+		t.Fatalf("expected the same tree")
+	}
+}
+
+func TestAccessTrackerFold(t *testing.T) {
+	r := NewWithFold(func(b byte) byte { return b | 0x20 })
+	r, _, _ = r.Insert([]byte("Foo"), 1)
+	r, _, _ = r.Insert([]byte("bar"), 2)
+	a := r.NewAccessTracker()
+
+	// Spellings that fold the same share the count of the stored key
+	for _, k := range []string{"foo", "FOO", "Foo"} {
+		if v, ok := a.Get([]byte(k)); !ok || v != 1 {
+			t.Fatalf("bad: %s %v %v", k, v, ok)
+		}
+	}
+	if hits := a.Hits([]byte("fOO")); hits != 3 {
+		t.Fatalf("bad: %d", hits)
+	}
+	want := []AccessCount{{[]byte("Foo"), 3}}
+	if out := a.Hottest(5); !reflect.DeepEqual(out, want) {
+		t.Fatalf("bad: %v", out)
+	}
+	want = []AccessCount{{[]byte("bar"), 0}, {[]byte("Foo"), 3}}
+	if out := a.Coldest(5); !reflect.DeepEqual(out, want) {
+		t.Fatalf("bad: %v", out)
+	}
+}
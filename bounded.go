@@ -0,0 +1,97 @@
+package iradix
+
+// BoundedTree is an immutable tree holding at most a given number of keys,
+// for use as a bounded cache. Inserting a new key into a full tree evicts
+// another one. Like a Tree, it's safe to use concurrently, and changes return
+// a new tree.
+type BoundedTree struct {
+	tree *Tree
+	max  int
+
+	// victim picks the key to evict from the given root
+	victim func(root *Node) []byte
+
+	// onEvict is called with each evicted key and value, if set
+	onEvict func(k []byte, v interface{})
+}
+
+// NewBoundedTree returns an empty BoundedTree holding at most max keys, which
+// evicts the smallest key to make room for a new one. If onEvict isn't nil,
+// it's called with each evicted key and value. Note that the key just
+// inserted is evicted straight away if it's the smallest.
+func NewBoundedTree(max int, onEvict func(k []byte, v interface{})) *BoundedTree {
+	return NewBoundedTreeWithVictim(max, minimumKey, onEvict)
+}
+
+// NewBoundedTreeWithVictim is like NewBoundedTree, but victim picks the key
+// to evict from the root of the tree, which includes the key just inserted.
+// It must return a key in the tree.
+func NewBoundedTreeWithVictim(max int, victim func(root *Node) []byte, onEvict func(k []byte, v interface{})) *BoundedTree {
+	if max < 1 {
+		panic("bounded tree must hold at least one key")
+	}
+	return &BoundedTree{
+		tree:    New(),
+		max:     max,
+		victim:  victim,
+		onEvict: onEvict,
+	}
+}
+
+// minimumKey returns the smallest key under root
+func minimumKey(root *Node) []byte {
+	k, _, _ := root.Minimum()
+	return k
+}
+
+// Insert returns a new tree with the given key set to the given value, along
+// with the previous value and whether there was one. If the key is new and
+// the tree is full, a key is evicted to make room.
+func (b *BoundedTree) Insert(k []byte, v interface{}) (*BoundedTree, interface{}, bool) {
+	txn := b.tree.Txn()
+	old, ok := txn.Insert(k, v)
+	for txn.PendingLen() > b.max {
+		victim := b.victim(txn.Root())
+		val, ok := txn.Delete(victim)
+		if !ok {
+			panic("bounded tree victim is not in the tree")
+		}
+		if b.onEvict != nil {
+			b.onEvict(victim, val)
+		}
+	}
+	tree, _ := txn.Commit()
+	return b.with(tree), old, ok
+}
+
+// Delete returns a new tree without the given key, along with its value and
+// whether it was present
+func (b *BoundedTree) Delete(k []byte) (*BoundedTree, interface{}, bool) {
+	tree, old, ok := b.tree.Delete(k)
+	if !ok {
+		return b, nil, false
+	}
+	return b.with(tree), old, true
+}
+
+// with returns a copy of b with the given tree
+func (b *BoundedTree) with(tree *Tree) *BoundedTree {
+	nb := *b
+	nb.tree = tree
+	return &nb
+}
+
+// Get returns the value of the given key, and whether it's present
+func (b *BoundedTree) Get(k []byte) (interface{}, bool) {
+	return b.tree.Get(k)
+}
+
+// Len returns the number of keys in the tree
+func (b *BoundedTree) Len() int {
+	return b.tree.Len()
+}
+
+// Tree returns the tree holding the keys
+func (b *BoundedTree) Tree() *Tree {
+	return b.tree
+}
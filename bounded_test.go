@@ -0,0 +1,80 @@
+package iradix
+
+import (
+	"reflect"
+	"testing"
+)
+
+func boundedKeys(b *BoundedTree) []string {
+	keys := []string{}
+	b.Tree().Root().Walk(func(k []byte, v interface{}) bool {
+		keys = append(keys, string(k))
+		return false
+	})
+	return keys
+}
+
+func TestBoundedTree(t *testing.T) {
+	var evicted []string
+	b := NewBoundedTree(3, func(k []byte, v interface{}) {
+		if v != string(k)+"!" {
+			t.Fatalf("bad: %q %v", k, v)
+		}
+		evicted = append(evicted, string(k))
+	})
+
+	// The new key is evicted if it's the smallest
+	for _, k := range []string{"d", "b", "c", "e", "a", "f"} {
+		b, _, _ = b.Insert([]byte(k), k+"!")
+	}
+	if want := []string{"b", "a", "c"}; !reflect.DeepEqual(evicted, want) {
+		t.Fatalf("bad: %v", evicted)
+	}
+	if want := []string{"d", "e", "f"}; !reflect.DeepEqual(boundedKeys(b), want) || b.Len() != 3 {
+		t.Fatalf("bad: %v %d", boundedKeys(b), b.Len())
+	}
+
+	// Updates and deletes don't evict
+	evicted = nil
+	b2, old, ok := b.Insert([]byte("e"), "e!")
+	if !ok || old != "e!" || b2.Len() != 3 {
+		t.Fatalf("bad: %v %v %d", old, ok, b2.Len())
+	}
+	b2, old, ok = b2.Delete([]byte("d"))
+	if !ok || old != "d!" || b2.Len() != 2 {
+		t.Fatalf("bad: %v %v %d", old, ok, b2.Len())
+	}
+	b2, _, _ = b2.Insert([]byte("a"), "a!")
+	if evicted != nil || b2.Len() != 3 {
+		t.Fatalf("bad: %v %d", evicted, b2.Len())
+	}
+
+	// The original is unchanged
+	if want := []string{"d", "e", "f"}; !reflect.DeepEqual(boundedKeys(b), want) {
+		t.Fatalf("bad: %v", boundedKeys(b))
+	}
+	if _, ok := b.Get([]byte("a")); ok {
+		t.Fatalf("unexpected key")
+	}
+}
+
+func TestBoundedTreeWithVictim(t *testing.T) {
+	// Evict the largest key instead
+	maximumKey := func(root *Node) []byte {
+		k, _, _ := root.Maximum()
+		return k
+	}
+	var evicted []string
+	b := NewBoundedTreeWithVictim(2, maximumKey, func(k []byte, v interface{}) {
+		evicted = append(evicted, string(k))
+	})
+	for _, k := range []string{"b", "c", "a", "d"} {
+		b, _, _ = b.Insert([]byte(k), nil)
+	}
+	if want := []string{"c", "d"}; !reflect.DeepEqual(evicted, want) {
+		t.Fatalf("bad: %v", evicted)
+	}
+	if want := []string{"a", "b"}; !reflect.DeepEqual(boundedKeys(b), want) {
+		t.Fatalf("bad: %v", boundedKeys(b))
+	}
+}
@@ -0,0 +1,83 @@
+package iradix
+
+import (
+	"bytes"
+	"fmt"
+)
+
+// buildFrame is a node along the path to the last key added by BuildSorted,
+// which may still gain edges
+type buildFrame struct {
+	node *Node
+
+	// end is the length of the full path to the node
+	end int
+}
+
+// BuildSorted returns a new Tree holding the keys and values returned by next
+// until it returns false. The keys must be in strictly ascending order, and
+// an error is returned if one is found out of order or repeated. Rather than
+// inserting each key from the root, nodes are appended to the path to the
+// previous key, which is much faster than repeated Insert calls, and which
+// never needs more than the keys themselves in memory. As with Insert, the
+// tree keeps hold of the keys, so next must not modify a key it has returned.
+func BuildSorted(next func() (k []byte, v interface{}, ok bool)) (*Tree, error) {
+	root := &Node{}
+	stack := []buildFrame{{node: root}}
+	var prev []byte
+	first := true
+	size := 0
+
+	for {
+		k, v, ok := next()
+		if !ok {
+			break
+		}
+		if !first {
+			switch bytes.Compare(k, prev) {
+			case 0:
+				return nil, fmt.Errorf("duplicate key %q", k)
+			case -1:
+				return nil, fmt.Errorf("key %q is out of order after %q", k, prev)
+			}
+		}
+		first = false
+
+		// Close the nodes that aren't on the path to the new key
+		common := longestPrefix(prev, k)
+		var closed *buildFrame
+		for stack[len(stack)-1].end > common {
+			closed = &stack[len(stack)-1]
+			stack = stack[:len(stack)-1]
+		}
+
+		// Split the last edge of the parent if the new key diverges from it
+		// partway along
+		parent := stack[len(stack)-1]
+		if closed != nil && parent.end < common {
+			split := &Node{prefix: k[parent.end:common], size: closed.node.size}
+			closed.node.prefix = prev[common:closed.end]
+			split.edges = edges{{label: prev[common], node: closed.node}}
+			parent.node.edges[len(parent.node.edges)-1].node = split
+			parent = buildFrame{node: split, end: common}
+			stack = append(stack, parent)
+		}
+
+		// Add the new key, which only the empty key may do at the root
+		leaf := &leafNode{key: k, val: v}
+		if len(k) == common {
+			parent.node.leaf = leaf
+		} else {
+			n := &Node{leaf: leaf, prefix: k[common:]}
+			parent.node.edges = append(parent.node.edges, edge{label: k[common], node: n})
+			stack = append(stack, buildFrame{node: n, end: len(k)})
+		}
+		// Every node on the path to the new key holds it
+		for _, f := range stack {
+			f.node.size++
+		}
+		prev = k
+		size++
+	}
+	return &Tree{root: root, size: size}, nil
+}
@@ -0,0 +1,127 @@
+package iradix
+
+import (
+	"fmt"
+	"reflect"
+	"sort"
+	"testing"
+
+	"github.com/hashicorp/go-uuid"
+)
+
+// sliceSource returns a function yielding the given keys in turn, each with
+// its index as the value
+func sliceSource(keys []string) func() ([]byte, interface{}, bool) {
+	i := 0
+	return func() ([]byte, interface{}, bool) {
+		if i == len(keys) {
+			return nil, nil, false
+		}
+		i++
+		return []byte(keys[i-1]), i - 1, true
+	}
+}
+
+func TestBuildSorted(t *testing.T) {
+	cases := [][]string{
+		{},
+		{""},
+		{"", "a", "ab", "abc"},
+		{"a", "ab", "abc", "abd", "ac", "b"},
+		{"abc", "abd", "abdx", "abe", "b", "ba", "bb"},
+		{"foo/bar/baz", "foo/baz/bar", "foo/zip/zap", "zipzap"},
+	}
+
+	// Add a large random set too
+	var random []string
+	for i := 0; i < 1000; i++ {
+		gen, err := uuid.GenerateUUID()
+		if err != nil {
+			t.Fatalf("err: %v", err)
+		}
+		random = append(random, gen[:i%8+1])
+	}
+	sort.Strings(random)
+	uniq := random[:0]
+	for i, k := range random {
+		if i == 0 || k != random[i-1] {
+			uniq = append(uniq, k)
+		}
+	}
+	cases = append(cases, uniq)
+
+	for idx, keys := range cases {
+		t.Run(fmt.Sprintf("case%03d", idx), func(t *testing.T) {
+			r, err := BuildSorted(sliceSource(keys))
+			if err != nil {
+				t.Fatalf("err: %v", err)
+			}
+			if err := r.Verify(); err != nil {
+				t.Fatalf("err: %v", err)
+			}
+
+			out := []string{}
+			r.Root().Walk(func(k []byte, v interface{}) bool {
+				if keys[v.(int)] != string(k) {
+					t.Fatalf("bad value for %q: %v", k, v)
+				}
+				out = append(out, string(k))
+				return false
+			})
+			if !reflect.DeepEqual(out, keys) {
+				t.Fatalf("bad: %v", out)
+			}
+			for i, k := range keys {
+				if v, ok := r.Get([]byte(k)); !ok || v != i {
+					t.Fatalf("bad: %q %v %v", k, v, ok)
+				}
+			}
+
+			// The tree can be modified as usual
+			r, _, _ = r.Insert([]byte("abcc"), -1)
+			r, _, _ = r.Delete([]byte("ab"))
+			if err := r.Verify(); err != nil {
+				t.Fatalf("err: %v", err)
+			}
+		})
+	}
+}
+
+func TestBuildSorted_Errors(t *testing.T) {
+	for _, keys := range [][]string{
+		{"a", "b", "b"},
+		{"", ""},
+		{"a", "c", "b"},
+		{"ab", "a"},
+	} {
+		if _, err := BuildSorted(sliceSource(keys)); err == nil {
+			t.Fatalf("expected error for %v", keys)
+		}
+	}
+}
+
+func BenchmarkBuildSorted(b *testing.B) {
+	keys := make([]string, 100000)
+	for i := range keys {
+		keys[i] = fmt.Sprintf("%010d", i)
+	}
+	b.ResetTimer()
+	for n := 0; n < b.N; n++ {
+		BuildSorted(sliceSource(keys))
+	}
+}
+
+func BenchmarkBuildSortedInsert(b *testing.B) {
+	keys := make([]string, 100000)
+	for i := range keys {
+		keys[i] = fmt.Sprintf("%010d", i)
+	}
+	b.ResetTimer()
+	for n := 0; n < b.N; n++ {
+		txn := New().Txn()
+		for i, k := range keys {
+			txn.Insert([]byte(k), i)
+		}
+		txn.Commit()
+	}
+}
@@ -0,0 +1,80 @@
+package iradix
+
+// CountingTree is an immutable multiset of keys, counting how many times
+// each has been added, in key order. Like a Tree, it's safe to use
+// concurrently, and changes return a new tree.
+type CountingTree struct {
+	tree *Tree
+}
+
+// NewCountingTree returns an empty CountingTree
+func NewCountingTree() *CountingTree {
+	return &CountingTree{tree: New()}
+}
+
+// Add returns a new tree with the count of the given key increased by one,
+// along with the new count. The count is updated in a single descent.
+func (c *CountingTree) Add(k []byte) (*CountingTree, int) {
+	var count int
+	txn := c.tree.Txn()
+	txn.update(k, func(old interface{}, exists bool) (interface{}, bool) {
+		count = 1
+		if exists {
+			count += old.(int)
+		}
+		return count, true
+	})
+	tree, _ := txn.Commit()
+	return &CountingTree{tree: tree}, count
+}
+
+// Remove returns a new tree with the count of the given key decreased by
+// one, deleting the key once it reaches zero, along with the new count. The
+// tree is returned unchanged if the key isn't present.
+func (c *CountingTree) Remove(k []byte) (*CountingTree, int) {
+	var count int
+	txn := c.tree.Txn()
+	_, exists, changed := txn.update(k, func(old interface{}, exists bool) (interface{}, bool) {
+		if !exists || old.(int) == 1 {
+			return nil, false
+		}
+		count = old.(int) - 1
+		return count, true
+	})
+	switch {
+	case !exists:
+		return c, 0
+	case !changed:
+		txn.Delete(k)
+	}
+	tree, _ := txn.Commit()
+	return &CountingTree{tree: tree}, count
+}
+
+// Count returns the number of times the given key has been added, less the
+// number of times it's been removed
+func (c *CountingTree) Count(k []byte) int {
+	if count, ok := c.tree.Get(k); ok {
+		return count.(int)
+	}
+	return 0
+}
+
+// TotalUnder returns the sum of the counts of the keys under the given
+// prefix. Only the subtree under the prefix is visited, but every key in it
+// is, since sums aren't cached on the nodes.
+func (c *CountingTree) TotalUnder(prefix []byte) int {
+	return c.tree.root.ReducePrefix(prefix, 0, func(acc interface{}, _ []byte, v interface{}) interface{} {
+		return acc.(int) + v.(int)
+	}).(int)
+}
+
+// Len returns the number of distinct keys in the tree
+func (c *CountingTree) Len() int {
+	return c.tree.Len()
+}
+
+// Tree returns the tree holding the keys, whose values are their int counts
+func (c *CountingTree) Tree() *Tree {
+	return c.tree
+}
@@ -0,0 +1,59 @@
+package iradix
+
+import "testing"
+
+func TestCountingTree(t *testing.T) {
+	c := NewCountingTree()
+	for _, k := range []string{"foo", "foo", "foo/bar", "foo/baz", "foo/baz", "foo/baz", "zip"} {
+		c, _ = c.Add([]byte(k))
+	}
+	c1, n := c.Add([]byte("zip"))
+	if n != 2 {
+		t.Fatalf("bad: %d", n)
+	}
+
+	cases := []struct {
+		key   string
+		count int
+	}{
+		{"foo", 2},
+		{"foo/bar", 1},
+		{"foo/baz", 3},
+		{"zip", 2},
+		{"nope", 0},
+	}
+	for _, tc := range cases {
+		if n := c1.Count([]byte(tc.key)); n != tc.count {
+			t.Fatalf("bad %s: %d", tc.key, n)
+		}
+	}
+	if n := c.Count([]byte("zip")); n != 1 {
+		t.Fatalf("original modified: %d", n)
+	}
+
+	totals := map[string]int{"": 8, "foo": 6, "foo/": 4, "foo/ba": 4, "z": 2, "nope": 0}
+	for prefix, want := range totals {
+		if n := c1.TotalUnder([]byte(prefix)); n != want {
+			t.Fatalf("bad total %q: %d", prefix, n)
+		}
+	}
+
+	// Removing decrements, and deletes the key at zero
+	c2, n := c1.Remove([]byte("foo/baz"))
+	if n != 2 || c2.Count([]byte("foo/baz")) != 2 || c2.Len() != 4 {
+		t.Fatalf("bad: %d", n)
+	}
+	c2, n = c2.Remove([]byte("foo/bar"))
+	if n != 0 || c2.Count([]byte("foo/bar")) != 0 || c2.Len() != 3 {
+		t.Fatalf("bad: %d", n)
+	}
+	if err := c2.Tree().Verify(); err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	if c3, n := c2.Remove([]byte("nope")); c3 != c2 || n != 0 {
+		t.Fatalf("expected the same tree")
+	}
+	if n := c2.TotalUnder([]byte("foo/")); n != 2 {
+		t.Fatalf("bad total: %d", n)
+	}
+}
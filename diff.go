@@ -0,0 +1,154 @@
+package iradix
+
+import "bytes"
+
+// DiffOp is the kind of difference Diff found for a key
+type DiffOp int
+
+const (
+	// DiffAdded is a key that is only in the newer tree
+	DiffAdded DiffOp = iota + 1
+
+	// DiffRemoved is a key that is only in the older tree
+	DiffRemoved
+
+	// DiffChanged is a key that has a different value in each tree
+	DiffChanged
+)
+
+// DiffFn is used when diffing trees. Takes the kind of difference, the
+// key, and its old and new values, which are nil for added and removed
+// keys respectively, returning if the diff should be terminated.
+type DiffFn func(op DiffOp, k []byte, oldVal, newVal interface{}) bool
+
+// Change is a change made to a key by a transaction, as returned by
+// Txn.Changes and Txn.NetChanges. OldVal is nil for an added key, and
+// NewVal for a removed one.
+type Change struct {
+	Op     DiffOp
+	Key    []byte
+	OldVal interface{}
+	NewVal interface{}
+}
+
+// diffItem is a node to be diffed, along with its full path
+type diffItem struct {
+	node *Node
+	path []byte
+}
+
+// Diff is used to find the differences between an older and a newer version
+// of a tree, calling fn for each key that was added, removed or changed, in
+// key order. A key is changed if its value differs, as compared with ==.
+// Subtrees that the versions share are skipped without being visited, so
+// when one version was derived from the other, the cost is proportional to
+// the changes between them rather than to the size of the trees.
+func Diff(a, b *Tree, fn DiffFn) {
+	diffNodes(a.root, nil, b.root, nil, fn)
+}
+
+// NetChanges returns the net changes made by the transaction since it
+// began, in key order, found by diffing its root against the original. Only
+// the net change to each key is returned, not every write, so a key
+// inserted and then deleted again isn't included, nor is one set back to
+// its original value. Nothing needs to be recorded while the transaction
+// runs, and the diff skips the subtrees it hasn't touched, but each call
+// costs a diff. Use TrackChanges and Changes for every write in order.
+func (t *Txn) NetChanges() []Change {
+	t.checkActive()
+	var changes []Change
+	diffNodes(t.orig, nil, t.root, nil, func(op DiffOp, k []byte, oldVal, newVal interface{}) bool {
+		changes = append(changes, Change{Op: op, Key: k, OldVal: oldVal, NewVal: newVal})
+		return false
+	})
+	return changes
+}
+
+// diffNodes is used to diff the subtrees at a and b, given their full paths.
+// Returns true if the diff should be aborted.
+func diffNodes(a *Node, aPath []byte, b *Node, bPath []byte, fn DiffFn) bool {
+	if a == b && len(aPath) == len(bPath) {
+		return false
+	}
+
+	// Subtrees whose paths diverge have no keys in common
+	if !bytes.HasPrefix(aPath, bPath) && !bytes.HasPrefix(bPath, aPath) {
+		if bytes.Compare(aPath, bPath) < 0 {
+			return diffAll(DiffRemoved, a, fn) || diffAll(DiffAdded, b, fn)
+		}
+		return diffAll(DiffAdded, b, fn) || diffAll(DiffRemoved, a, fn)
+	}
+
+	// Expand whichever subtree has the shorter path into its leaf and its
+	// children, leaving the other as a single child, so that the children
+	// of both can be paired up by the byte that follows the shorter path
+	depth := len(aPath)
+	if len(bPath) < depth {
+		depth = len(bPath)
+	}
+	aLeaf, aKids := diffExpand(a, aPath, depth)
+	bLeaf, bKids := diffExpand(b, bPath, depth)
+
+	// Only a subtree that has been expanded has a leaf at this depth
+	switch {
+	case aLeaf != nil && bLeaf != nil:
+		if aLeaf != bLeaf && !sameValue(aLeaf.val, bLeaf.val) &&
+			fn(DiffChanged, bLeaf.key, aLeaf.val, bLeaf.val) {
+			return true
+		}
+	case aLeaf != nil:
+		if fn(DiffRemoved, aLeaf.key, aLeaf.val, nil) {
+			return true
+		}
+	case bLeaf != nil:
+		if fn(DiffAdded, bLeaf.key, nil, bLeaf.val) {
+			return true
+		}
+	}
+
+	// Merge the children in order
+	for len(aKids) > 0 || len(bKids) > 0 {
+		switch {
+		case len(bKids) == 0 || len(aKids) > 0 && aKids[0].path[depth] < bKids[0].path[depth]:
+			if diffAll(DiffRemoved, aKids[0].node, fn) {
+				return true
+			}
+			aKids = aKids[1:]
+		case len(aKids) == 0 || bKids[0].path[depth] < aKids[0].path[depth]:
+			if diffAll(DiffAdded, bKids[0].node, fn) {
+				return true
+			}
+			bKids = bKids[1:]
+		default:
+			if diffNodes(aKids[0].node, aKids[0].path, bKids[0].node, bKids[0].path, fn) {
+				return true
+			}
+			aKids, bKids = aKids[1:], bKids[1:]
+		}
+	}
+	return false
+}
+
+// diffExpand returns the leaf and children of n if its path ends at the
+// given depth, or otherwise n as the only child
+func diffExpand(n *Node, path []byte, depth int) (*leafNode, []diffItem) {
+	if len(path) > depth {
+		return nil, []diffItem{{node: n, path: path}}
+	}
+	kids := make([]diffItem, len(n.edges))
+	for i, e := range n.edges {
+		kids[i] = diffItem{node: e.node, path: concat(path, e.node.prefix)}
+	}
+	return n.leaf, kids
+}
+
+// diffAll is used to report every key under n as added or removed. Returns
+// true if the diff should be aborted.
+func diffAll(op DiffOp, n *Node, fn DiffFn) bool {
+	return recursiveWalk(n, func(k []byte, v interface{}) bool {
+		if op == DiffAdded {
+			return fn(op, k, nil, v)
+		}
+		return fn(op, k, v, nil)
+	})
+}
@@ -0,0 +1,143 @@
+package iradix
+
+import "bytes"
+
+// DiffIterator is used to stream the differences between an older and a
+// newer version of a tree, in key order, as found by Diff. It holds only the
+// subtrees still to be diffed along the current path, rather than the
+// differences themselves, so huge diffs can be processed in bounded memory.
+type DiffIterator struct {
+	stack []diffFrame
+}
+
+// diffFrame is a pending piece of work for a DiffIterator. It's a single key
+// to report if either leaf is set, every key under a or b if op is set, or
+// otherwise a pair of subtrees to be diffed.
+type diffFrame struct {
+	a, b         diffItem
+	op           DiffOp
+	aLeaf, bLeaf *leafNode
+}
+
+// NewDiffIterator returns an iterator over the differences between an older
+// and a newer version of a tree. Like Diff, subtrees that the versions share
+// are skipped without being visited.
+func NewDiffIterator(a, b *Tree) *DiffIterator {
+	return &DiffIterator{
+		stack: []diffFrame{{a: diffItem{node: a.root}, b: diffItem{node: b.root}}},
+	}
+}
+
+// Next returns the next key that was added, removed or changed, along with
+// its old and new values, which are nil for added and removed keys
+// respectively. Returns ok=false once there are no more differences.
+func (d *DiffIterator) Next() (k []byte, op DiffOp, oldVal, newVal interface{}, ok bool) {
+	for len(d.stack) > 0 {
+		f := d.stack[len(d.stack)-1]
+		d.stack = d.stack[:len(d.stack)-1]
+
+		switch {
+		case f.aLeaf != nil && f.bLeaf != nil:
+			return f.bLeaf.key, DiffChanged, f.aLeaf.val, f.bLeaf.val, true
+		case f.aLeaf != nil:
+			return f.aLeaf.key, DiffRemoved, f.aLeaf.val, nil, true
+		case f.bLeaf != nil:
+			return f.bLeaf.key, DiffAdded, nil, f.bLeaf.val, true
+		case f.op != 0:
+			if leaf := d.expandAll(f); leaf != nil {
+				if f.op == DiffAdded {
+					return leaf.key, f.op, nil, leaf.val, true
+				}
+				return leaf.key, f.op, leaf.val, nil, true
+			}
+		default:
+			d.expandPair(f.a, f.b)
+		}
+	}
+	return nil, 0, nil, nil, false
+}
+
+// expandAll pushes the children of a subtree whose keys are all added or
+// removed, returning its leaf, which comes before them
+func (d *DiffIterator) expandAll(f diffFrame) *leafNode {
+	n := f.a.node
+	if f.op == DiffAdded {
+		n = f.b.node
+	}
+	for i := len(n.edges) - 1; i >= 0; i-- {
+		child := diffFrame{op: f.op}
+		if f.op == DiffAdded {
+			child.b.node = n.edges[i].node
+		} else {
+			child.a.node = n.edges[i].node
+		}
+		d.stack = append(d.stack, child)
+	}
+	return n.leaf
+}
+
+// expandPair pushes the work needed to diff the subtrees at a and b, in the
+// same order as diffNodes would do it
+func (d *DiffIterator) expandPair(a, b diffItem) {
+	if a.node == b.node && len(a.path) == len(b.path) {
+		return
+	}
+
+	// The frames are appended in order and then reversed, so that the
+	// first is the next to be popped
+	start := len(d.stack)
+	removed := diffFrame{op: DiffRemoved, a: a}
+	added := diffFrame{op: DiffAdded, b: b}
+
+	// Subtrees whose paths diverge have no keys in common
+	if !bytes.HasPrefix(a.path, b.path) && !bytes.HasPrefix(b.path, a.path) {
+		if bytes.Compare(a.path, b.path) < 0 {
+			d.stack = append(d.stack, removed, added)
+		} else {
+			d.stack = append(d.stack, added, removed)
+		}
+		d.reverse(start)
+		return
+	}
+
+	depth := len(a.path)
+	if len(b.path) < depth {
+		depth = len(b.path)
+	}
+	aLeaf, aKids := diffExpand(a.node, a.path, depth)
+	bLeaf, bKids := diffExpand(b.node, b.path, depth)
+
+	switch {
+	case aLeaf != nil && bLeaf != nil:
+		if aLeaf != bLeaf && !sameValue(aLeaf.val, bLeaf.val) {
+			d.stack = append(d.stack, diffFrame{aLeaf: aLeaf, bLeaf: bLeaf})
+		}
+	case aLeaf != nil:
+		d.stack = append(d.stack, diffFrame{aLeaf: aLeaf})
+	case bLeaf != nil:
+		d.stack = append(d.stack, diffFrame{bLeaf: bLeaf})
+	}
+
+	// Merge the children in order
+	for len(aKids) > 0 || len(bKids) > 0 {
+		switch {
+		case len(bKids) == 0 || len(aKids) > 0 && aKids[0].path[depth] < bKids[0].path[depth]:
+			d.stack = append(d.stack, diffFrame{op: DiffRemoved, a: aKids[0]})
+			aKids = aKids[1:]
+		case len(aKids) == 0 || bKids[0].path[depth] < aKids[0].path[depth]:
+			d.stack = append(d.stack, diffFrame{op: DiffAdded, b: bKids[0]})
+			bKids = bKids[1:]
+		default:
+			d.stack = append(d.stack, diffFrame{a: aKids[0], b: bKids[0]})
+			aKids, bKids = aKids[1:], bKids[1:]
+		}
+	}
+	d.reverse(start)
+}
+
+// reverse reverses the frames on the stack from start onwards
+func (d *DiffIterator) reverse(start int) {
+	for i, j := start, len(d.stack)-1; i < j; i, j = i+1, j-1 {
+		d.stack[i], d.stack[j] = d.stack[j], d.stack[i]
+	}
+}
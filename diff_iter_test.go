@@ -0,0 +1,116 @@
+package iradix
+
+import (
+	"fmt"
+	"math/rand"
+	"reflect"
+	"testing"
+)
+
+func collectDiffIterator(a, b *Tree) []diffEntry {
+	out := []diffEntry{}
+	it := NewDiffIterator(a, b)
+	for k, op, oldVal, newVal, ok := it.Next(); ok; k, op, oldVal, newVal, ok = it.Next() {
+		out = append(out, diffEntry{op, string(k), oldVal, newVal})
+	}
+	if _, _, _, _, ok := it.Next(); ok {
+		panic("iterator should stay exhausted")
+	}
+	return out
+}
+
+func TestDiffIterator(t *testing.T) {
+	a := New()
+	for _, k := range []string{"foo", "foo/bar", "foo/baz", "foobar", "zip"} {
+		a, _, _ = a.Insert([]byte(k), k)
+	}
+	b, _, _ := a.Delete([]byte("foo/bar"))
+	b, _, _ = b.Insert([]byte("foo/zoo"), "foo/zoo")
+	b, _, _ = b.Insert([]byte("zip"), "zap")
+	b, _, _ = b.Insert([]byte("a"), "a")
+
+	want := []diffEntry{
+		{DiffAdded, "a", nil, "a"},
+		{DiffRemoved, "foo/bar", "foo/bar", nil},
+		{DiffAdded, "foo/zoo", nil, "foo/zoo"},
+		{DiffChanged, "zip", "zip", "zap"},
+	}
+	if out := collectDiffIterator(a, b); !reflect.DeepEqual(out, want) {
+		t.Fatalf("bad: %v", out)
+	}
+	if out := collectDiffIterator(a, a); len(out) != 0 {
+		t.Fatalf("bad: %v", out)
+	}
+	if out := collectDiffIterator(New(), a); !reflect.DeepEqual(out, naiveDiff(New(), a)) {
+		t.Fatalf("bad: %v", out)
+	}
+}
+
+func TestDiffIteratorFuzz(t *testing.T) {
+	rnd := rand.New(rand.NewSource(1))
+	key := func() []byte {
+		b := make([]byte, rnd.Intn(5))
+		for i := range b {
+			b[i] = "abc"[rnd.Intn(3)]
+		}
+		return b
+	}
+
+	for round := 0; round < 200; round++ {
+		a := New()
+		for n := rnd.Intn(50); n > 0; n-- {
+			a, _, _ = a.Insert(key(), rnd.Intn(3))
+		}
+		b := a
+		for n := rnd.Intn(10); n > 0; n-- {
+			if rnd.Intn(2) == 0 {
+				b, _, _ = b.Insert(key(), rnd.Intn(3))
+			} else {
+				b, _, _ = b.Delete(key())
+			}
+		}
+		c := New()
+		for n := rnd.Intn(50); n > 0; n-- {
+			c, _, _ = c.Insert(key(), rnd.Intn(3))
+		}
+
+		for _, pair := range [][2]*Tree{{a, b}, {b, a}, {a, c}, {c, b}} {
+			out := collectDiffIterator(pair[0], pair[1])
+			if want := collectDiff(pair[0], pair[1]); !reflect.DeepEqual(out, want) {
+				t.Fatalf("round %d: mis-match\n  got=%v\n  want=%v", round, out, want)
+			}
+		}
+	}
+}
+
+func TestDiffIteratorBounded(t *testing.T) {
+	txn := New().Txn()
+	for i := 0; i < 100000; i++ {
+		txn.Insert([]byte(fmt.Sprintf("%010d", i)), i)
+	}
+	t1, _ := txn.Commit()
+
+	// Removing every key streams them all without holding them at once
+	empty := New()
+	it := NewDiffIterator(t1, empty)
+	n, max := 0, 0
+	for _, _, _, _, ok := it.Next(); ok; _, _, _, _, ok = it.Next() {
+		n++
+		if len(it.stack) > max {
+			max = len(it.stack)
+		}
+	}
+	if n != 100000 || max > 100 {
+		t.Fatalf("bad: %d %d", n, max)
+	}
+
+	// A single change is the only difference found
+	t3, _, _ := t1.Insert([]byte("0000050000"), -1)
+	it = NewDiffIterator(t1, t3)
+	if k, op, _, _, ok := it.Next(); !ok || op != DiffChanged || string(k) != "0000050000" {
+		t.Fatalf("bad: %s %v", k, op)
+	}
+	if _, _, _, _, ok := it.Next(); ok {
+		t.Fatalf("expected no more changes")
+	}
+}
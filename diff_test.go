@@ -0,0 +1,209 @@
+package iradix
+
+import (
+	"fmt"
+	"math/rand"
+	"reflect"
+	"sort"
+	"testing"
+)
+
+type diffEntry struct {
+	op       DiffOp
+	key      string
+	old, new interface{}
+}
+
+func collectDiff(a, b *Tree) []diffEntry {
+	out := []diffEntry{}
+	Diff(a, b, func(op DiffOp, k []byte, oldVal, newVal interface{}) bool {
+		out = append(out, diffEntry{op, string(k), oldVal, newVal})
+		return false
+	})
+	return out
+}
+
+// naiveDiff diffs the entries of two trees by walking all of both
+func naiveDiff(a, b *Tree) []diffEntry {
+	av := map[string]interface{}{}
+	bv := map[string]interface{}{}
+	keys := []string{}
+	a.Root().Walk(func(k []byte, v interface{}) bool {
+		av[string(k)] = v
+		keys = append(keys, string(k))
+		return false
+	})
+	b.Root().Walk(func(k []byte, v interface{}) bool {
+		bv[string(k)] = v
+		if _, ok := av[string(k)]; !ok {
+			keys = append(keys, string(k))
+		}
+		return false
+	})
+	sort.Strings(keys)
+
+	out := []diffEntry{}
+	for _, k := range keys {
+		oldVal, inA := av[k]
+		newVal, inB := bv[k]
+		switch {
+		case !inB:
+			out = append(out, diffEntry{DiffRemoved, k, oldVal, nil})
+		case !inA:
+			out = append(out, diffEntry{DiffAdded, k, nil, newVal})
+		case oldVal != newVal:
+			out = append(out, diffEntry{DiffChanged, k, oldVal, newVal})
+		}
+	}
+	return out
+}
+
+func TestDiff(t *testing.T) {
+	a := New()
+	for i, k := range []string{"", "foo", "foo/bar", "foo/baz", "zip", "zipzap"} {
+		a, _, _ = a.Insert([]byte(k), i)
+	}
+	txn := a.Txn()
+	txn.Insert([]byte("foo/bar"), 10)
+	txn.Insert([]byte("foo/bax"), 11)
+	txn.Insert([]byte("fo"), 12)
+	txn.Insert([]byte("zipzap"), 5)
+	txn.Delete([]byte("zip"))
+	txn.Delete([]byte(""))
+	b, _ := txn.Commit()
+
+	want := []diffEntry{
+		{DiffRemoved, "", 0, nil},
+		{DiffAdded, "fo", nil, 12},
+		{DiffChanged, "foo/bar", 2, 10},
+		{DiffAdded, "foo/bax", nil, 11},
+		{DiffRemoved, "zip", 4, nil},
+	}
+	if out := collectDiff(a, b); !reflect.DeepEqual(out, want) {
+		t.Fatalf("bad: %v", out)
+	}
+
+	// Diffing the other way round swaps additions and removals
+	if out := collectDiff(b, a); !reflect.DeepEqual(out, naiveDiff(b, a)) {
+		t.Fatalf("bad: %v", out)
+	}
+
+	// There are no differences from a tree to itself
+	if out := collectDiff(a, a); len(out) != 0 {
+		t.Fatalf("bad: %v", out)
+	}
+
+	// The diff can be stopped
+	n := 0
+	Diff(a, b, func(DiffOp, []byte, interface{}, interface{}) bool {
+		n++
+		return n == 2
+	})
+	if n != 2 {
+		t.Fatalf("bad: %d", n)
+	}
+}
+
+func TestDiffFuzz(t *testing.T) {
+	rnd := rand.New(rand.NewSource(1))
+	key := func() []byte {
+		b := make([]byte, rnd.Intn(5))
+		for i := range b {
+			b[i] = "abc"[rnd.Intn(3)]
+		}
+		return b
+	}
+
+	for round := 0; round < 200; round++ {
+		// Trees derived from one another share structure
+		a := New()
+		for n := rnd.Intn(50); n > 0; n-- {
+			a, _, _ = a.Insert(key(), rnd.Intn(3))
+		}
+		b := a
+		for n := rnd.Intn(10); n > 0; n-- {
+			if rnd.Intn(2) == 0 {
+				b, _, _ = b.Insert(key(), rnd.Intn(3))
+			} else {
+				b, _, _ = b.Delete(key())
+			}
+		}
+
+		// Independently built trees don't
+		c := New()
+		for n := rnd.Intn(50); n > 0; n-- {
+			c, _, _ = c.Insert(key(), rnd.Intn(3))
+		}
+
+		for _, pair := range [][2]*Tree{{a, b}, {b, a}, {a, c}, {c, b}} {
+			out := collectDiff(pair[0], pair[1])
+			if want := naiveDiff(pair[0], pair[1]); !reflect.DeepEqual(out, want) {
+				t.Fatalf("round %d: mis-match\n  got=%v\n  want=%v", round, out, want)
+			}
+		}
+	}
+}
+
+func BenchmarkDiff(b *testing.B) {
+	txn := New().Txn()
+	for i := 0; i < 100000; i++ {
+		txn.Insert([]byte(fmt.Sprintf("%010d", i)), i)
+	}
+	t1, _ := txn.Commit()
+	t2, _, _ := t1.Insert([]byte("0000050000"), -1)
+
+	b.ResetTimer()
+	for n := 0; n < b.N; n++ {
+		Diff(t1, t2, func(DiffOp, []byte, interface{}, interface{}) bool {
+			return false
+		})
+	}
+}
+
+func TestTxnNetChanges(t *testing.T) {
+	r := New()
+	for i, k := range []string{"foo", "foo/bar", "zip", "zip/zap"} {
+		r, _, _ = r.Insert([]byte(k), i)
+	}
+
+	txn := r.Txn()
+	if changes := txn.NetChanges(); len(changes) != 0 {
+		t.Fatalf("bad: %v", changes)
+	}
+	txn.Insert([]byte("foo/baz"), 4)
+	txn.Insert([]byte("zip"), 5)
+	txn.Delete([]byte("foo/bar"))
+	txn.Insert([]byte("a"), 6)
+
+	// Only the net changes are returned
+	txn.Insert([]byte("temp"), 7)
+	txn.Delete([]byte("temp"))
+	txn.Insert([]byte("zip/zap"), 8)
+	txn.Insert([]byte("zip/zap"), 3)
+
+	want := []Change{
+		{Op: DiffAdded, Key: []byte("a"), NewVal: 6},
+		{Op: DiffRemoved, Key: []byte("foo/bar"), OldVal: 1},
+		{Op: DiffAdded, Key: []byte("foo/baz"), NewVal: 4},
+		{Op: DiffChanged, Key: []byte("zip"), OldVal: 2, NewVal: 5},
+	}
+	if changes := txn.NetChanges(); !reflect.DeepEqual(changes, want) {
+		t.Fatalf("bad: %v", changes)
+	}
+
+	// Replaying the changes onto the original gives the same tree
+	replay := r.Txn()
+	for _, c := range txn.NetChanges() {
+		if c.Op == DiffRemoved {
+			replay.Delete(c.Key)
+		} else {
+			replay.Insert(c.Key, c.NewVal)
+		}
+	}
+	a, _ := txn.Commit()
+	b, _ := replay.Commit()
+	Diff(a, b, func(op DiffOp, k []byte, oldVal, newVal interface{}) bool {
+		t.Fatalf("unexpected difference: %v %q", op, k)
+		return true
+	})
+}
@@ -0,0 +1,140 @@
+package iradix
+
+import (
+	"crypto/sha256"
+	"encoding/binary"
+	"fmt"
+	"math"
+	"reflect"
+	"sync/atomic"
+	"unsafe"
+)
+
+// nodeHash is the cached hash of a node
+type nodeHash struct {
+	sum [32]byte
+
+	// hooked is set if values were hashed by a hook rather than by identity
+	hooked bool
+}
+
+// NewWithValueHash returns an empty Tree whose Hash method hashes values
+// with valueHash, so that equal values hash the same even when they're
+// separate copies. See Node.Hash.
+func NewWithValueHash(valueHash func(v interface{}) []byte) *Tree {
+	return &Tree{
+		root: &Node{},
+		opts: &options{valueHash: valueHash},
+	}
+}
+
+// Hash returns a hash of the contents of the tree, as with Node.Hash, but
+// hashing values with the hook given to NewWithValueHash, if any
+func (t *Tree) Hash() [32]byte {
+	var valueHash func(interface{}) []byte
+	if t.opts != nil {
+		valueHash = t.opts.valueHash
+	}
+	return t.root.hash(valueHash)
+}
+
+// Hash returns a SHA-256 hash of the structure, keys and values of the
+// subtree at n, which can be used to cache results computed from the
+// subtree. Values are hashed along with their type. Pointers, maps,
+// channels and slices hash by identity, as the address they refer to, so
+// equal values held separately hash differently, and changes made through
+// them aren't seen. Booleans, numbers and strings hash by their contents.
+// Any other value, such as a struct, hashes by its representation as
+// printed by fmt's %#v verb, which covers its fields but only the addresses
+// of any pointers among them, and only the code of a func. Use Tree.Hash
+// with a tree created by NewWithValueHash to hash values by their
+// contents. The hash of each node is cached, and nodes are shared between
+// versions of a tree, so after a change, only the nodes along the path to
+// the change are hashed again.
+func (n *Node) Hash() [32]byte {
+	return n.hash(nil)
+}
+
+// hash returns the hash of the subtree at n, hashing values with valueHash,
+// or by identity if it's nil. The hash is cached on the node, which is safe
+// to do concurrently since the hash of a node never changes.
+func (n *Node) hash(valueHash func(interface{}) []byte) [32]byte {
+	hooked := valueHash != nil
+	if c := (*nodeHash)(atomic.LoadPointer(&n.hashed)); c != nil && c.hooked == hooked {
+		return c.sum
+	}
+
+	h := sha256.New()
+	var buf [binary.MaxVarintLen64]byte
+	writeInt := func(i uint64) {
+		h.Write(buf[:binary.PutUvarint(buf[:], i)])
+	}
+	writeBytes := func(b []byte) {
+		writeInt(uint64(len(b)))
+		h.Write(b)
+	}
+
+	writeBytes(n.prefix)
+	if n.leaf != nil {
+		writeInt(1)
+		writeBytes(n.leaf.key)
+		if hooked {
+			writeBytes(valueHash(n.leaf.val))
+		} else {
+			writeBytes(defaultValueHash(n.leaf.val))
+		}
+	} else {
+		writeInt(0)
+	}
+	writeInt(uint64(len(n.edges)))
+	for _, e := range n.edges {
+		sum := e.node.hash(valueHash)
+		h.Write(sum[:])
+	}
+
+	c := &nodeHash{hooked: hooked}
+	h.Sum(c.sum[:0])
+	atomic.StorePointer(&n.hashed, unsafe.Pointer(c))
+	return c.sum
+}
+
+// defaultValueHash returns the bytes a value is hashed by when no hook is
+// given, as described for Node.Hash
+func defaultValueHash(v interface{}) []byte {
+	if v == nil {
+		return nil
+	}
+	rv := reflect.ValueOf(v)
+	typ := rv.Type()
+	b := binary.AppendUvarint(nil, uint64(len(typ.PkgPath())))
+	b = append(b, typ.PkgPath()...)
+	b = binary.AppendUvarint(b, uint64(len(typ.String())))
+	b = append(b, typ.String()...)
+
+	switch rv.Kind() {
+	case reflect.Ptr, reflect.Map, reflect.Chan, reflect.UnsafePointer:
+		return binary.AppendUvarint(b, uint64(rv.Pointer()))
+	case reflect.Slice:
+		b = binary.AppendUvarint(b, uint64(rv.Pointer()))
+		return binary.AppendUvarint(b, uint64(rv.Len()))
+	case reflect.Bool:
+		if rv.Bool() {
+			return append(b, 1)
+		}
+		return append(b, 0)
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		return binary.AppendVarint(b, rv.Int())
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64, reflect.Uintptr:
+		return binary.AppendUvarint(b, rv.Uint())
+	case reflect.Float32, reflect.Float64:
+		return binary.AppendUvarint(b, math.Float64bits(rv.Float()))
+	case reflect.Complex64, reflect.Complex128:
+		c := rv.Complex()
+		b = binary.AppendUvarint(b, math.Float64bits(real(c)))
+		return binary.AppendUvarint(b, math.Float64bits(imag(c)))
+	case reflect.String:
+		return append(b, rv.String()...)
+	default:
+		return fmt.Appendf(b, "%#v", v)
+	}
+}
@@ -0,0 +1,114 @@
+package iradix
+
+import (
+	"fmt"
+	"testing"
+)
+
+func TestNodeHash(t *testing.T) {
+	vals := make([]int, 5)
+	r := New()
+	for i, k := range []string{"foo", "foo/bar", "foo/baz", "zip", "zip/zap"} {
+		r, _, _ = r.Insert([]byte(k), &vals[i])
+	}
+
+	// Hashes are cached and stable
+	sum := r.Root().Hash()
+	if r.Root().hashed == nil || r.Root().Hash() != sum {
+		t.Fatalf("hash not cached")
+	}
+
+	// Changing a value changes the hash of the nodes along its path, but
+	// not of the subtrees shared with the previous version
+	r2, _, _ := r.Insert([]byte("foo/bar"), &vals[4])
+	if r2.Root().Hash() == sum {
+		t.Fatalf("hash not changed")
+	}
+	_, zip := r.Root().getEdge('z')
+	_, zip2 := r2.Root().getEdge('z')
+	if zip != zip2 || zip.hashed == nil {
+		t.Fatalf("expected shared, hashed subtree")
+	}
+
+	// Reverting to the same pointer gives the same hash, as does building
+	// the same tree separately
+	r3, _, _ := r2.Insert([]byte("foo/bar"), &vals[1])
+	if r3.Root().Hash() != sum {
+		t.Fatalf("hash mis-match")
+	}
+	r4 := New()
+	for i, k := range []string{"zip/zap", "zip", "foo/baz", "foo/bar", "foo"} {
+		r4, _, _ = r4.Insert([]byte(k), &vals[4-i])
+	}
+	if r4.Root().Hash() != sum {
+		t.Fatalf("hash mis-match")
+	}
+
+	// Keys affect the hash as well as values
+	r5, _, _ := r.Delete([]byte("zip/zap"))
+	r5, _, _ = r5.Insert([]byte("zip/zop"), &vals[4])
+	if r5.Root().Hash() == sum {
+		t.Fatalf("hash not changed")
+	}
+}
+
+func TestNodeHashValues(t *testing.T) {
+	hash := func(v interface{}) [32]byte {
+		r, _, _ := New().Insert([]byte("k"), v)
+		return r.Root().Hash()
+	}
+
+	// Plain values hash by their contents and type, however large
+	type point struct{ x, y int }
+	same := [][2]interface{}{
+		{1, 1}, {1 << 40, 1 << 40}, {"foo", "foo"}, {2.5, 2.5},
+		{point{1, 2}, point{1, 2}}, {nil, nil},
+	}
+	for _, c := range same {
+		if hash(c[0]) != hash(c[1]) {
+			t.Fatalf("expected equal hashes: %v", c[0])
+		}
+	}
+	differ := [][2]interface{}{
+		{1, 2}, {1 << 40, 1<<40 + 1}, {1, int64(1)}, {1, uint(1)}, {"foo", "bar"},
+		{point{1, 2}, point{2, 1}}, {nil, 0}, {true, false}, {"", nil},
+		{&point{1, 2}, &point{1, 2}}, {[]int{1}, []int{1}},
+	}
+	for _, c := range differ {
+		if hash(c[0]) == hash(c[1]) {
+			t.Fatalf("expected different hashes: %#v %#v", c[0], c[1])
+		}
+	}
+}
+
+func TestTreeHashWithValueHash(t *testing.T) {
+	build := func() *Tree {
+		r := NewWithValueHash(func(v interface{}) []byte {
+			return []byte(fmt.Sprint(v))
+		})
+		for i, k := range []string{"foo", "foo/bar", "zip"} {
+			r, _, _ = r.Insert([]byte(k), []int{i})
+		}
+		return r
+	}
+
+	// Separate but equal values hash the same with the hook, and not by
+	// identity
+	r1, r2 := build(), build()
+	if r1.Hash() != r2.Hash() {
+		t.Fatalf("hash mis-match")
+	}
+	if r1.Root().Hash() == r2.Root().Hash() {
+		t.Fatalf("expected identity hashes to differ")
+	}
+
+	// Switching between the two doesn't return the wrong cached hash
+	if r1.Hash() != r2.Hash() {
+		t.Fatalf("hash mis-match")
+	}
+
+	r3, _, _ := r1.Insert([]byte("zip"), []int{3})
+	if r3.Hash() == r1.Hash() {
+		t.Fatalf("hash not changed")
+	}
+}
@@ -0,0 +1,159 @@
+package iradix
+
+// IndexedTree is an immutable tree with a secondary index from a key derived
+// from each value back to the keys that hold it, such as to look up records
+// by a field. Both are updated together by an IndexedTxn, so they're always
+// consistent. Like a Tree, it's safe to use concurrently.
+type IndexedTree struct {
+	tree *Tree
+
+	// index maps each value key to a *Set of the keys whose values have it
+	index *Tree
+
+	// valueKey derives the key a value is indexed by, or nil if it isn't
+	valueKey func(v interface{}) []byte
+}
+
+// NewIndexedTree returns an empty IndexedTree that indexes each value by the
+// key valueKey returns for it. Values for which it returns nil aren't
+// indexed.
+func NewIndexedTree(valueKey func(v interface{}) []byte) *IndexedTree {
+	return &IndexedTree{tree: New(), index: New(), valueKey: valueKey}
+}
+
+// Txn starts a new transaction that updates the tree and its index together
+func (t *IndexedTree) Txn() *IndexedTxn {
+	return &IndexedTxn{
+		txn:      t.tree.Txn(),
+		index:    t.index.Txn(),
+		valueKey: t.valueKey,
+	}
+}
+
+// Insert returns a new tree with the given key set to the given value, along
+// with the previous value and whether there was one
+func (t *IndexedTree) Insert(k []byte, v interface{}) (*IndexedTree, interface{}, bool) {
+	txn := t.Txn()
+	old, ok := txn.Insert(k, v)
+	return txn.Commit(), old, ok
+}
+
+// Delete returns a new tree without the given key, along with its value and
+// whether it was present
+func (t *IndexedTree) Delete(k []byte) (*IndexedTree, interface{}, bool) {
+	txn := t.Txn()
+	old, ok := txn.Delete(k)
+	if !ok {
+		return t, nil, false
+	}
+	return txn.Commit(), old, true
+}
+
+// Get returns the value of the given key, and whether it's present
+func (t *IndexedTree) Get(k []byte) (interface{}, bool) {
+	return t.tree.Get(k)
+}
+
+// LookupByValue returns the keys whose values have the given value key, in
+// order
+func (t *IndexedTree) LookupByValue(valueKey []byte) [][]byte {
+	return lookupByValue(t.index.root, valueKey)
+}
+
+// Len returns the number of keys in the tree
+func (t *IndexedTree) Len() int {
+	return t.tree.Len()
+}
+
+// Tree returns the tree holding the keys and values
+func (t *IndexedTree) Tree() *Tree {
+	return t.tree
+}
+
+// IndexedTxn is a transaction on an IndexedTree, which keeps the index in
+// step with each change to the tree
+type IndexedTxn struct {
+	txn      *Txn
+	index    *Txn
+	valueKey func(v interface{}) []byte
+}
+
+// Insert is used to add or update the given key, moving it to the index
+// entry for the new value. Returns the old value if any, and whether it was
+// updated.
+func (t *IndexedTxn) Insert(k []byte, v interface{}) (interface{}, bool) {
+	old, ok := t.txn.Insert(k, v)
+	if ok {
+		t.unindex(k, old)
+	}
+	if vk := t.valueKey(v); vk != nil {
+		set, _ := t.index.Get(vk)
+		if set == nil {
+			set = NewSet()
+		}
+		t.index.Insert(vk, set.(*Set).Add(k))
+	}
+	return old, ok
+}
+
+// Delete is used to delete the given key, removing it from the index.
+// Returns the old value if any, and whether it was deleted.
+func (t *IndexedTxn) Delete(k []byte) (interface{}, bool) {
+	old, ok := t.txn.Delete(k)
+	if ok {
+		t.unindex(k, old)
+	}
+	return old, ok
+}
+
+// unindex removes k from the index entry for the given value
+func (t *IndexedTxn) unindex(k []byte, v interface{}) {
+	vk := t.valueKey(v)
+	if vk == nil {
+		return
+	}
+	set, ok := t.index.Get(vk)
+	if !ok {
+		return
+	}
+	if rest := set.(*Set).Remove(k); rest.Len() != 0 {
+		t.index.Insert(vk, rest)
+	} else {
+		t.index.Delete(vk)
+	}
+}
+
+// Get is used to lookup a specific key, returning the value and if it was
+// found
+func (t *IndexedTxn) Get(k []byte) (interface{}, bool) {
+	return t.txn.Get(k)
+}
+
+// LookupByValue returns the keys whose values have the given value key, in
+// order, reflecting the changes made so far
+func (t *IndexedTxn) LookupByValue(valueKey []byte) [][]byte {
+	return lookupByValue(t.index.Root(), valueKey)
+}
+
+// Commit is used to finalize the transaction, returning the new tree along
+// with its index
+func (t *IndexedTxn) Commit() *IndexedTree {
+	tree, _ := t.txn.Commit()
+	index, _ := t.index.Commit()
+	return &IndexedTree{tree: tree, index: index, valueKey: t.valueKey}
+}
+
+// lookupByValue returns the keys in the set stored for valueKey under the
+// given index root
+func lookupByValue(index *Node, valueKey []byte) [][]byte {
+	set, ok := index.Get(valueKey)
+	if !ok {
+		return nil
+	}
+	keys := make([][]byte, 0, set.(*Set).Len())
+	set.(*Set).Walk(func(k []byte) bool {
+		keys = append(keys, k)
+		return false
+	})
+	return keys
+}
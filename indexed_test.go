@@ -0,0 +1,79 @@
+package iradix
+
+import (
+	"reflect"
+	"testing"
+)
+
+type indexedRecord struct {
+	name, team string
+}
+
+func recordTeam(v interface{}) []byte {
+	if v.(indexedRecord).team == "" {
+		return nil
+	}
+	return []byte(v.(indexedRecord).team)
+}
+
+func lookupStrings(keys [][]byte) []string {
+	out := []string{}
+	for _, k := range keys {
+		out = append(out, string(k))
+	}
+	return out
+}
+
+func TestIndexedTree(t *testing.T) {
+	r := NewIndexedTree(recordTeam)
+	txn := r.Txn()
+	txn.Insert([]byte("u1"), indexedRecord{"alice", "red"})
+	txn.Insert([]byte("u2"), indexedRecord{"bob", "blue"})
+	txn.Insert([]byte("u3"), indexedRecord{"carol", "red"})
+	txn.Insert([]byte("u4"), indexedRecord{"dave", ""})
+	if out := lookupStrings(txn.LookupByValue([]byte("red"))); !reflect.DeepEqual(out, []string{"u1", "u3"}) {
+		t.Fatalf("bad: %v", out)
+	}
+	r1 := txn.Commit()
+
+	// Moving a key between values updates both entries, and deleting the
+	// last key for a value removes its entry
+	r2, old, ok := r1.Insert([]byte("u1"), indexedRecord{"alice", "blue"})
+	if !ok || old.(indexedRecord).team != "red" {
+		t.Fatalf("bad: %v %v", old, ok)
+	}
+	r2, _, _ = r2.Delete([]byte("u3"))
+	r2, _, _ = r2.Insert([]byte("u4"), indexedRecord{"dave", "green"})
+
+	cases := []struct {
+		tree *IndexedTree
+		team string
+		want []string
+	}{
+		{r1, "red", []string{"u1", "u3"}},
+		{r1, "blue", []string{"u2"}},
+		{r1, "green", []string{}},
+		{r2, "red", []string{}},
+		{r2, "blue", []string{"u1", "u2"}},
+		{r2, "green", []string{"u4"}},
+	}
+	for _, c := range cases {
+		if out := lookupStrings(c.tree.LookupByValue([]byte(c.team))); !reflect.DeepEqual(out, c.want) {
+			t.Fatalf("bad %s: %v", c.team, out)
+		}
+	}
+	if r2.index.Len() != 2 {
+		t.Fatalf("bad: %d", r2.index.Len())
+	}
+	if r1.Len() != 4 || r2.Len() != 3 {
+		t.Fatalf("bad: %d %d", r1.Len(), r2.Len())
+	}
+	if v, _ := r2.Get([]byte("u1")); v.(indexedRecord).team != "blue" {
+		t.Fatalf("bad: %v", v)
+	}
+
+	// Deleting a missing key returns the same tree
+	if r3, _, ok := r2.Delete([]byte("nope")); ok || r3 != r2 {
+		t.Fatalf("expected the same tree")
+	}
+}
@@ -0,0 +1,102 @@
+package iradix
+
+import "sync"
+
+// NewWithValueInterning returns an empty Tree that stores a single canonical
+// instance of equal values. When a value is inserted, or returned by the fn
+// given to MapValues or Migrate, it's replaced by the first value stored
+// before it that eq reports as equal, if any, using hash to find the
+// candidates. This saves memory when large values are frequently
+// duplicated. hash must return the same result for equal values.
+//
+// Interning changes the identity of stored values: the value returned by a
+// lookup may not be the one that was inserted, but an equal one, so callers
+// mustn't rely on pointer identity or mutate values in place. The canonical
+// instances are shared by every version of the tree, and are held until
+// PurgeInterned drops them, even after every key holding one is deleted, so
+// a long-lived tree whose values churn should be purged from time to time.
+func NewWithValueInterning(eq func(a, b interface{}) bool, hash func(interface{}) uint64) *Tree {
+	return &Tree{
+		root: &Node{},
+		opts: &options{interner: &interner{
+			eq:     eq,
+			hash:   hash,
+			values: make(map[uint64][]interface{}),
+		}},
+	}
+}
+
+// PurgeInterned drops the canonical instances of values that the tree no
+// longer holds, for a tree created by NewWithValueInterning, and does
+// nothing for any other tree. Values held only by other versions of the
+// tree are dropped as well, so equal values inserted later won't share
+// their instances, though they remain valid where they're stored, as may
+// values interned by writes made while the purge runs. This walks the whole
+// tree.
+func (t *Tree) PurgeInterned() {
+	if t.opts == nil || t.opts.interner == nil {
+		return
+	}
+	i := t.opts.interner
+	values := make(map[uint64][]interface{})
+	t.root.Walk(func(_ []byte, v interface{}) bool {
+		if v == nil {
+			return false
+		}
+		h := i.hash(v)
+		for _, c := range values[h] {
+			if i.eq(c, v) {
+				return false
+			}
+		}
+		values[h] = append(values[h], v)
+		return false
+	})
+
+	i.mu.Lock()
+	i.values = values
+	i.mu.Unlock()
+}
+
+// interner holds the canonical instances of the values of a tree created by
+// NewWithValueInterning. It's safe for concurrent use, as it's shared by
+// every version of the tree.
+type interner struct {
+	eq     func(a, b interface{}) bool
+	hash   func(interface{}) uint64
+	mu     sync.Mutex
+	values map[uint64][]interface{}
+}
+
+// intern returns the canonical instance of v, which becomes v itself if no
+// equal value has been seen
+func (i *interner) intern(v interface{}) interface{} {
+	if v == nil {
+		return nil
+	}
+	h := i.hash(v)
+	i.mu.Lock()
+	defer i.mu.Unlock()
+	for _, c := range i.values[h] {
+		if i.eq(c, v) {
+			return c
+		}
+	}
+	i.values[h] = append(i.values[h], v)
+	return v
+}
+
+// intern returns the value to store for v, which is its canonical instance
+// if the tree interns values, and otherwise v itself
+func (o *options) intern(v interface{}) interface{} {
+	if o == nil || o.interner == nil {
+		return v
+	}
+	return o.interner.intern(v)
+}
+
+// newLeaf returns a leaf for the key and value being inserted, interning
+// the value if the tree does so
+func (t *Txn) newLeaf(k []byte, v interface{}) *leafNode {
+	return &leafNode{key: k, val: t.opts.intern(v)}
+}
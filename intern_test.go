@@ -0,0 +1,132 @@
+package iradix
+
+import (
+	"fmt"
+	"hash/fnv"
+	"sync"
+	"testing"
+)
+
+type blob struct {
+	data string
+}
+
+func newInterningTree() *Tree {
+	eq := func(a, b interface{}) bool {
+		return a.(*blob).data == b.(*blob).data
+	}
+	hash := func(v interface{}) uint64 {
+		h := fnv.New64a()
+		h.Write([]byte(v.(*blob).data))
+		return h.Sum64()
+	}
+	return NewWithValueInterning(eq, hash)
+}
+
+func TestValueInterning(t *testing.T) {
+	first := &blob{"large"}
+	r := newInterningTree()
+	r, _, _ = r.Insert([]byte("a"), first)
+	r, _, _ = r.Insert([]byte("b"), &blob{"large"})
+	r, _, _ = r.Insert([]byte("c"), &blob{"other"})
+	r, _, _ = r.Insert([]byte("d"), nil)
+
+	// Equal values share the first instance inserted, across every path
+	// that creates a leaf
+	txn := r.Txn()
+	txn.Insert([]byte("a"), &blob{"large"})
+	txn.Insert([]byte("ab"), &blob{"large"})
+	txn.InsertIfAbsent([]byte("abc"), &blob{"large"})
+	r, _ = txn.Commit()
+	for _, k := range []string{"a", "ab", "abc", "b"} {
+		if v, _ := r.Get([]byte(k)); v != first {
+			t.Fatalf("bad: %s %p", k, v)
+		}
+	}
+	if v, _ := r.Get([]byte("c")); v == first || v.(*blob).data != "other" {
+		t.Fatalf("bad: %v", v)
+	}
+	if v, ok := r.Get([]byte("d")); !ok || v != nil {
+		t.Fatalf("bad: %v %v", v, ok)
+	}
+
+	// Other trees don't share the instances
+	other, _, _ := newInterningTree().Insert([]byte("a"), &blob{"large"})
+	if v, _ := other.Get([]byte("a")); v == first {
+		t.Fatalf("expected a separate instance")
+	}
+}
+
+func TestValueInterningConcurrent(t *testing.T) {
+	r := newInterningTree()
+	var wg sync.WaitGroup
+	trees := make([]*Tree, 8)
+	for i := range trees {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			txn := r.Txn()
+			for j := 0; j < 100; j++ {
+				txn.Insert([]byte(fmt.Sprintf("%d/%d", i, j)), &blob{fmt.Sprint(j % 10)})
+			}
+			trees[i], _ = txn.Commit()
+		}(i)
+	}
+	wg.Wait()
+
+	for j := 0; j < 100; j++ {
+		want, _ := trees[0].Get([]byte(fmt.Sprintf("0/%d", j)))
+		for i, tree := range trees {
+			if v, _ := tree.Get([]byte(fmt.Sprintf("%d/%d", i, j))); v != want {
+				t.Fatalf("bad: %d %d", i, j)
+			}
+		}
+	}
+}
+
+func TestValueInterningDerived(t *testing.T) {
+	first := &blob{"large"}
+	r := newInterningTree()
+	r, _, _ = r.Insert([]byte("a"), first)
+	r, _, _ = r.Insert([]byte("b"), &blob{"small"})
+
+	// Values returned by MapValues and Migrate are interned too
+	mapped := r.MapValues(func(k []byte, v interface{}) interface{} {
+		return &blob{"large"}
+	})
+	migrated := r.Migrate(func(k []byte, v interface{}) (interface{}, bool) {
+		return &blob{"large"}, true
+	})
+	for _, tree := range []*Tree{mapped, migrated} {
+		for _, k := range []string{"a", "b"} {
+			if v, _ := tree.Get([]byte(k)); v != first {
+				t.Fatalf("bad: %s %p", k, v)
+			}
+		}
+	}
+}
+
+func TestPurgeInterned(t *testing.T) {
+	kept, dropped := &blob{"kept"}, &blob{"dropped"}
+	r := newInterningTree()
+	r, _, _ = r.Insert([]byte("a"), kept)
+	r, _, _ = r.Insert([]byte("b"), dropped)
+	r, _, _ = r.Delete([]byte("b"))
+	r.PurgeInterned()
+	if n := len(r.opts.interner.values); n != 1 {
+		t.Fatalf("bad: %d", n)
+	}
+
+	// Values the tree still holds stay canonical, and dropped ones don't
+	r, _, _ = r.Insert([]byte("c"), &blob{"kept"})
+	r, _, _ = r.Insert([]byte("d"), &blob{"dropped"})
+	if v, _ := r.Get([]byte("c")); v != kept {
+		t.Fatalf("bad: %p", v)
+	}
+	if v, _ := r.Get([]byte("d")); v == dropped {
+		t.Fatalf("expected a new instance")
+	}
+
+	// Purging a tree that doesn't intern does nothing
+	New().PurgeInterned()
+}
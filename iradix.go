@@ -1,6 +1,10 @@
 package iradix
 
-import "bytes"
+import (
+	"bytes"
+	"fmt"
+	"sort"
+)
 
 type (
 	// Tree implements an immutable radix tree. This can be treated as a
@@ -8,8 +12,18 @@ type (
 	// hash map is prefix-based lookups and ordered iteration. The immutability
 	// means that it is safe to concurrently read from a Tree without any
 	// coordination.
+	//
+	// Only the structure of the tree is immutable. Values are stored as
+	// given, so a value that is a pointer, map or slice is shared by every
+	// version of the tree holding it, and modifying it is visible through
+	// all of them. Replace such values with new ones instead, or see
+	// NewWithValueClone and Snapshot.
 	Tree struct {
 		root *Node
+		opts *options
+
+		// size is the number of keys in the tree
+		size int
 	}
 
 	// Txn is a transaction on the tree. This transaction is applied
@@ -21,6 +35,89 @@ type (
 
 		// orig is the original root
 		orig *Node
+
+		// opts are the options of the tree the transaction started from
+		opts *options
+
+		// stats counts the work done by the transaction
+		stats CommitStats
+
+		// size is the number of keys in the transaction's tree
+		size int
+
+		// copyKeys makes inserts store a copy of their keys
+		copyKeys bool
+
+		// forgetOldRoot makes Commit release the tree the transaction
+		// started from
+		forgetOldRoot bool
+
+		// gen identifies the nodes created by this transaction that have
+		// not yet been committed, and so may be recycled. It is zero unless
+		// node pooling is enabled.
+		gen uint64
+
+		// savepoints are the states remembered by Savepoint
+		savepoints []savepoint
+
+		// mutations counts the changes made to the root, so that iterators
+		// obtained from the transaction can tell if it's been modified
+		mutations uint64
+
+		// trackChanges makes writes record the changes they make
+		trackChanges bool
+
+		// changes is the journal of changes recorded by writes
+		changes []Change
+	}
+
+	// CommitStats counts the work done by a transaction since it began,
+	// as reported by CommitStats
+	CommitStats struct {
+		// NodesCreated is the number of nodes created, including the copies
+		// of existing nodes made to modify them
+		NodesCreated int
+
+		// EdgesAdded and EdgesRemoved are the numbers of edges added to and
+		// removed from nodes
+		EdgesAdded   int
+		EdgesRemoved int
+
+		// LeavesInserted, LeavesUpdated and LeavesDeleted are the numbers of
+		// keys that were inserted, had their values replaced, and were
+		// deleted
+		LeavesInserted int
+		LeavesUpdated  int
+		LeavesDeleted  int
+	}
+
+	// options holds the optional behaviors a Tree is constructed with. They
+	// are fixed for the life of the tree, and are carried into each of its
+	// transactions and on to the trees they commit. A nil *options is valid
+	// and means all the defaults.
+	options struct {
+		// pool enables recycling of the nodes superseded within a
+		// transaction
+		pool bool
+
+		// keyMap maps each byte of a key to the byte it is indexed by, if
+		// set. It's used to fold keys, and to reorder them.
+		keyMap *[256]byte
+
+		// clone returns a copy of a value, for use by Snapshot, if set
+		clone func(interface{}) interface{}
+
+		// validate checks keys before they're inserted, if set
+		validate func([]byte) error
+
+		// valueHash hashes values for Tree.Hash, if set
+		valueHash func(interface{}) []byte
+
+		// keyLen is the length of every key, if non-zero
+		keyLen int
+
+		// interner holds canonical instances of values, if set
+		interner *interner
 	}
 )
 
@@ -31,22 +128,146 @@ func New() *Tree {
 	}
 }
 
+// NewWithNodePool returns an empty Tree whose transactions draw nodes from a
+// shared pool, recycling the copies that are superseded within the same
+// transaction. This reduces allocations and GC pressure during bulk loads,
+// but not CPU time, as tracking which nodes can be recycled costs more than
+// it saves, so a bulk load can run a quarter slower than without the pool.
+// Nodes are only recycled until the transaction that created them commits,
+// so a node reachable from a committed Tree is never reused. As a result, a
+// Root obtained from such a transaction must not be used at all once further
+// inserts or deletes have been made within it.
+func NewWithNodePool() *Tree {
+	return &Tree{
+		root: &Node{},
+		opts: &options{pool: true},
+	}
+}
+
+// NewWithFold returns an empty Tree that indexes keys by the result of
+// applying foldFn to each of their bytes, such as to ignore case. The fold is
+// applied to the keys given to the Tree and Txn methods, and to those given
+// to the seeks of iterators obtained from the Tree, so that keys which fold
+// the same are treated as equal. Leaves retain the key as it was last
+// inserted, which is what is returned by lookups and iteration, while keys
+// are ordered by their folded form. Methods of Node have no access to the
+// fold, and instead expect keys that have already been folded.
+func NewWithFold(foldFn func(byte) byte) *Tree {
+	keyMap := new([256]byte)
+	for b := range keyMap {
+		keyMap[b] = foldFn(byte(b))
+	}
+	return &Tree{
+		root: &Node{},
+		opts: &options{keyMap: keyMap},
+	}
+}
+
+// NewWithComparator returns an empty Tree that orders keys using cmp to
+// compare their bytes, rather than bytewise. cmp must return a negative
+// number, zero or a positive number when a is less than, equal to or greater
+// than b, and must be a consistent total order. Bytes it considers equal are
+// treated as the same byte, as with NewWithFold. The same order is used for
+// every position in a key, so keys that need a different order at certain
+// positions, such as for the sign bit of an integer, still need to be
+// transformed. Keys are indexed by the rank of each byte in the order, which
+// is what Methods of Node expect to be given, while leaves retain the keys as
+// inserted.
+func NewWithComparator(cmp func(a, b byte) int) *Tree {
+	order := make([]byte, 256)
+	for b := range order {
+		order[b] = byte(b)
+	}
+	sort.SliceStable(order, func(i, j int) bool {
+		return cmp(order[i], order[j]) < 0
+	})
+
+	keyMap := new([256]byte)
+	rank := byte(0)
+	for i, b := range order {
+		if i > 0 && cmp(order[i-1], b) != 0 {
+			rank++
+		}
+		keyMap[b] = rank
+	}
+	return &Tree{
+		root: &Node{},
+		opts: &options{keyMap: keyMap},
+	}
+}
+
+// NewWithValueClone returns an empty Tree with a hook for copying values,
+// which Snapshot uses to produce a version of the tree that shares no values
+// with the others. Values are otherwise stored and returned as given, and
+// are shared between versions of the tree as usual.
+func NewWithValueClone(clone func(interface{}) interface{}) *Tree {
+	return &Tree{
+		root: &Node{},
+		opts: &options{clone: clone},
+	}
+}
+
+// NewWithKeyValidator returns an empty Tree that checks each key with
+// validate before inserting it, so that invalid keys are never stored.
+// InsertChecked returns the error for an invalid key. Insert and the other
+// methods that insert keys can't, so they panic with the error instead.
+func NewWithKeyValidator(validate func([]byte) error) *Tree {
+	return &Tree{
+		root: &Node{},
+		opts: &options{validate: validate},
+	}
+}
+
+// NewFixedLen returns an empty Tree whose keys all have the given length,
+// such as UUIDs. Keys of any other length are rejected as if by a key
+// validator, as described for NewWithKeyValidator. Since no key can be a
+// prefix of another, lookups skip checking the prefix of each node along
+// the way, and compare the key with the leaf they reach just once. This
+// makes little measurable difference to the speed of lookups, which is
+// dominated by following the nodes, so the validation is the main benefit.
+func NewFixedLen(keyLen int) *Tree {
+	if keyLen < 1 {
+		panic("fixed key length must be at least one")
+	}
+	return &Tree{
+		root: &Node{},
+		opts: &options{validate: fixedLenValidator(keyLen), keyLen: keyLen},
+	}
+}
+
+// fixedLenValidator returns a key validator that rejects keys of any length
+// other than keyLen
+func fixedLenValidator(keyLen int) func([]byte) error {
+	return func(k []byte) error {
+		if len(k) != keyLen {
+			return fmt.Errorf("key %q has length %d, not %d", k, len(k), keyLen)
+		}
+		return nil
+	}
+}
+
 // Txn starts a new transaction that can be used to mutate the tree
 func (t *Tree) Txn() *Txn {
 	root := t.root
-	return &Txn{
+	txn := &Txn{
 		root: root,
 		orig: root,
+		opts: t.opts,
+		size: t.size,
+	}
+	if t.opts != nil && t.opts.pool {
+		txn.gen = nextGen()
 	}
+	return txn
 }
 
 // writeNode returns a node to be modified, if the current node has already been
 // modified during the course of the transaction, it is used in-place.
 func (t *Txn) writeNode(n *Node) *Node {
 	// Copy the existing node.
-	nc := &Node{
-		leaf: n.leaf,
-	}
+	nc := t.newNode()
+	nc.leaf = n.leaf
+	nc.size = n.size
 	if n.prefix != nil {
 		nc.prefix = make([]byte, len(n.prefix))
 		copy(nc.prefix, n.prefix)
@@ -56,9 +277,18 @@ func (t *Txn) writeNode(n *Node) *Node {
 		copy(nc.edges, n.edges)
 	}
 
+	// The copy supersedes the original
+	t.recycle(n)
 	return nc
 }
 
+// setRoot replaces the root of the transaction, invalidating any iterators
+// obtained from it
+func (t *Txn) setRoot(n *Node) {
+	t.root = n
+	t.mutations++
+}
+
 // mergeChild is called to collapse the given node with its child. This is only
 // called when the given node is not a leaf and has a single edge.
 func (t *Txn) mergeChild(n *Node) {
@@ -67,16 +297,24 @@ func (t *Txn) mergeChild(n *Node) {
 	// Merge the nodes.
 	n.prefix = concat(n.prefix, child.prefix)
 	n.leaf = child.leaf
+	n.size = child.size
 	if len(child.edges) != 0 {
 		n.edges = make([]edge, len(child.edges))
 		copy(n.edges, child.edges)
 	} else {
 		n.edges = nil
 	}
+	t.stats.EdgesRemoved++
+	t.recycle(child)
 }
 
-// insert does a recursive insertion
-func (t *Txn) insert(n *Node, k, search []byte, v interface{}) (*Node, interface{}, bool) {
+// updateFn is used to decide the value stored by an insert, given the
+// existing value, if any. It returns false to leave the tree unchanged.
+type updateFn func(old interface{}, exists bool) (interface{}, bool)
+
+// insert does a recursive insertion. If update is given, it decides the value
+// to store once the existing value is known.
+func (t *Txn) insert(n *Node, k, search []byte, v interface{}, update updateFn) (*Node, interface{}, bool) {
 	// Handle key exhaustion
 	if len(search) == 0 {
 		var oldVal interface{}
@@ -85,11 +323,17 @@ func (t *Txn) insert(n *Node, k, search []byte, v interface{}) (*Node, interface
 			oldVal = n.leaf.val
 			didUpdate = true
 		}
+		if update != nil {
+			var ok bool
+			if v, ok = update(oldVal, didUpdate); !ok {
+				return nil, oldVal, didUpdate
+			}
+		}
 
 		nc := t.writeNode(n)
-		nc.leaf = &leafNode{
-			key: k,
-			val: v,
+		nc.leaf = t.newLeaf(k, v)
+		if !didUpdate {
+			nc.size++
 		}
 		return nc, oldVal, didUpdate
 	}
@@ -99,18 +343,24 @@ func (t *Txn) insert(n *Node, k, search []byte, v interface{}) (*Node, interface
 
 	// No edge, create one
 	if child == nil {
+		if update != nil {
+			var ok bool
+			if v, ok = update(nil, false); !ok {
+				return nil, nil, false
+			}
+		}
+		newLeaf := t.newNode()
+		newLeaf.leaf = t.newLeaf(k, v)
+		newLeaf.prefix = search
+		newLeaf.size = 1
 		e := edge{
 			label: search[0],
-			node: &Node{
-				leaf: &leafNode{
-					key: k,
-					val: v,
-				},
-				prefix: search,
-			},
+			node:  newLeaf,
 		}
 		nc := t.writeNode(n)
 		nc.addEdge(e)
+		nc.size++
+		t.stats.EdgesAdded++
 		return nc, nil, false
 	}
 
@@ -118,20 +368,30 @@ func (t *Txn) insert(n *Node, k, search []byte, v interface{}) (*Node, interface
 	commonPrefix := longestPrefix(search, child.prefix)
 	if commonPrefix == len(child.prefix) {
 		search = search[commonPrefix:]
-		newChild, oldVal, didUpdate := t.insert(child, k, search, v)
+		newChild, oldVal, didUpdate := t.insert(child, k, search, v, update)
 		if newChild != nil {
 			nc := t.writeNode(n)
 			nc.edges[idx].node = newChild
+			if !didUpdate {
+				nc.size++
+			}
 			return nc, oldVal, didUpdate
 		}
 		return nil, oldVal, didUpdate
 	}
 
 	// Split the node
-	nc := t.writeNode(n)
-	splitNode := &Node{
-		prefix: search[:commonPrefix],
+	if update != nil {
+		var ok bool
+		if v, ok = update(nil, false); !ok {
+			return nil, nil, false
+		}
 	}
+	nc := t.writeNode(n)
+	nc.size++
+	splitNode := t.newNode()
+	splitNode.prefix = search[:commonPrefix]
+	splitNode.size = child.size + 1
 	nc.replaceEdge(edge{
 		label: search[0],
 		node:  splitNode,
@@ -143,13 +403,11 @@ func (t *Txn) insert(n *Node, k, search []byte, v interface{}) (*Node, interface
 		label: modChild.prefix[commonPrefix],
 		node:  modChild,
 	})
+	t.stats.EdgesAdded++
 	modChild.prefix = modChild.prefix[commonPrefix:]
 
 	// Create a new leaf node
-	leaf := &leafNode{
-		key: k,
-		val: v,
-	}
+	leaf := t.newLeaf(k, v)
 
 	// If the new key is a subset, add to to this node
 	search = search[commonPrefix:]
@@ -159,22 +417,26 @@ func (t *Txn) insert(n *Node, k, search []byte, v interface{}) (*Node, interface
 	}
 
 	// Create a new edge for the node
+	newLeaf := t.newNode()
+	newLeaf.leaf = leaf
+	newLeaf.prefix = search
+	newLeaf.size = 1
 	splitNode.addEdge(edge{
 		label: search[0],
-		node: &Node{
-			leaf:   leaf,
-			prefix: search,
-		},
+		node:  newLeaf,
 	})
+	t.stats.EdgesAdded++
 	return nc, nil, false
 }
 
-// delete does a recursive deletion
-func (t *Txn) delete(n *Node, search []byte) (*Node, *leafNode) {
+// delete does a recursive deletion. Along with the new node and the deleted
+// leaf, it returns the deepest new node, the one that replaced the node the
+// leaf was deleted from, or its parent if that node was removed.
+func (t *Txn) delete(n *Node, search []byte) (*Node, *leafNode, *Node) {
 	// Check for key exhaustion
 	if len(search) == 0 {
 		if !n.isLeaf() {
-			return nil, nil
+			return nil, nil, nil
 		}
 		// Copy the pointer in case we are in a transaction that already
 		// modified this node since the node will be reused. Any changes
@@ -185,87 +447,632 @@ func (t *Txn) delete(n *Node, search []byte) (*Node, *leafNode) {
 		// Remove the leaf node
 		nc := t.writeNode(n)
 		nc.leaf = nil
+		nc.size--
 
 		// Check if this node should be merged
 		if n != t.root && len(nc.edges) == 1 {
 			t.mergeChild(nc)
 		}
-		return nc, oldLeaf
+		return nc, oldLeaf, nc
 	}
 
 	// Look for an edge
 	label := search[0]
 	idx, child := n.getEdge(label)
 	if child == nil || !bytes.HasPrefix(search, child.prefix) {
-		return nil, nil
+		return nil, nil, nil
 	}
 
 	// Consume the search prefix
 	search = search[len(child.prefix):]
-	newChild, leaf := t.delete(child, search)
+	newChild, leaf, deepest := t.delete(child, search)
 	if newChild == nil {
-		return nil, nil
+		return nil, nil, nil
 	}
 
 	// Copy this node.
 	nc := t.writeNode(n)
+	nc.size--
 
 	// Delete the edge if the node has no edges
 	if newChild.leaf == nil && len(newChild.edges) == 0 {
 		nc.delEdge(label)
+		t.stats.EdgesRemoved++
+		t.recycle(newChild)
 		if n != t.root && len(nc.edges) == 1 && !nc.isLeaf() {
 			t.mergeChild(nc)
 		}
+		deepest = nc
 	} else {
 		nc.edges[idx].node = newChild
 	}
-	return nc, leaf
+	return nc, leaf, deepest
+}
+
+// CopyKeys sets whether inserts copy the keys they're given. By default
+// they don't, and the tree holds on to the given key slices, which must then
+// not be modified, such as by reusing a buffer, since that would corrupt the
+// tree. Copying keys avoids that, at the cost of an allocation per insert.
+func (t *Txn) CopyKeys(copyKeys bool) {
+	t.checkActive()
+	t.copyKeys = copyKeys
+}
+
+// ForgetOldRoot sets whether Commit releases the transaction's references
+// to older versions of the tree. Deleted or replaced values stay reachable
+// from any older version that still holds them, which is what makes older
+// versions safe to keep reading, so they can't be freed until every such
+// version is dropped. By default a transaction holds on to the tree it
+// started from, and to the states remembered by Savepoint, for as long as
+// it's in use. When they're released, savepoints taken before the commit
+// can no longer be rolled back to, and the tree committed last becomes the
+// one the transaction is compared against, so a later Commit reports
+// whether anything changed since then, and NetChanges returns the changes
+// made since then. This is for owners who discard old versions as they go,
+// and who keep a transaction open across commits, so that dropping the old
+// trees actually frees their values.
+func (t *Txn) ForgetOldRoot(forget bool) {
+	t.checkActive()
+	t.forgetOldRoot = forget
+}
+
+// storedKey returns the key to be stored for an insert
+func (t *Txn) storedKey(k []byte) []byte {
+	if !t.copyKeys {
+		return k
+	}
+	return concat(nil, k)
+}
+
+// validateKey checks a key with the tree's key validator, if it has one
+func (t *Txn) validateKey(k []byte) error {
+	if t.opts == nil || t.opts.validate == nil {
+		return nil
+	}
+	return t.opts.validate(k)
+}
+
+// mustValidateKey is like validateKey, but panics with the error, for the
+// methods that can't return it
+func (t *Txn) mustValidateKey(k []byte) {
+	if err := t.validateKey(k); err != nil {
+		panic(err)
+	}
+}
+
+// InsertChecked is like Insert, but returns the error from the tree's key
+// validator if it rejects the key, in which case nothing is inserted
+func (t *Txn) InsertChecked(k []byte, v interface{}) (interface{}, bool, error) {
+	t.checkActive()
+	if err := t.validateKey(k); err != nil {
+		return nil, false, err
+	}
+	old, ok := t.insertKey(k, v)
+	return old, ok, nil
 }
 
 // Insert is used to add or update a given key. The return provides
-// the previous value and a bool indicating if any was set.
+// the previous value and a bool indicating if any was set. The key is
+// held by the tree unless CopyKeys is set.
 func (t *Txn) Insert(k []byte, v interface{}) (interface{}, bool) {
-	newRoot, oldVal, didUpdate := t.insert(t.root, k, k, v)
-	if newRoot != nil {
-		t.root = newRoot
-	}
+	t.checkActive()
+	t.mustValidateKey(k)
+	return t.insertKey(k, v)
+}
+
+// InsertNode is like Insert, but also returns the root node of the
+// transaction after the insert, for composing operations on nodes directly.
+//
+// This is an advanced API with sharp edges. The returned node is sealed, so
+// it won't be changed or reused by later writes to the transaction, but it
+// must never be modified by the caller either, as it may share any of its
+// subtrees with committed trees. It doesn't carry the tree's options, such
+// as a key mapping or validation, nor its size, so it should be read with
+// the Node methods only. On a tree with a node pool, sealing stops the nodes
+// written so far from being recycled within the transaction, so calling
+// this in a loop gives up most of the benefit of the pool.
+func (t *Txn) InsertNode(k []byte, v interface{}) (*Node, interface{}, bool) {
+	old, ok := t.Insert(k, v)
+	t.seal()
+	return t.root, old, ok
+}
+
+// insertKey does the work of Insert, once the key has been validated
+func (t *Txn) insertKey(k []byte, v interface{}) (interface{}, bool) {
+	oldVal, didUpdate, _ := t.update(k, func(interface{}, bool) (interface{}, bool) {
+		return v, true
+	})
 	return oldVal, didUpdate
 }
 
+// InsertIfAbsent is used to add a key only if it isn't already set, in a
+// single descent of the tree. If the key is set, the tree is left unchanged
+// and its existing value is returned, along with false.
+func (t *Txn) InsertIfAbsent(k []byte, v interface{}) (interface{}, bool) {
+	t.checkActive()
+	t.mustValidateKey(k)
+	oldVal, _, changed := t.update(k, func(_ interface{}, exists bool) (interface{}, bool) {
+		return v, !exists
+	})
+	return oldVal, changed
+}
+
+// InsertFunc is like InsertIfAbsent, but only calls fn to make the value
+// once the key is known to be new, so that expensive defaults aren't built
+// just to be discarded. If the key is set, the tree is left unchanged and
+// its existing value is returned, along with false, without calling fn.
+func (t *Txn) InsertFunc(k []byte, fn func() interface{}) (interface{}, bool) {
+	t.checkActive()
+	t.mustValidateKey(k)
+	oldVal, _, changed := t.update(k, func(_ interface{}, exists bool) (interface{}, bool) {
+		if exists {
+			return nil, false
+		}
+		return fn(), true
+	})
+	return oldVal, changed
+}
+
+// InsertChanged is like Insert, but leaves the tree untouched if the key is
+// already set to a value equal to v, as decided by eq, or by == if eq is
+// nil. It returns the previous value, if any, and whether the tree changed,
+// so that idempotent writes don't create new versions of the tree, and the
+// transaction reports no change on Commit if they were all it did.
+func (t *Txn) InsertChanged(k []byte, v interface{}, eq func(a, b interface{}) bool) (interface{}, bool) {
+	t.checkActive()
+	t.mustValidateKey(k)
+	if eq == nil {
+		eq = sameValue
+	}
+	oldVal, _, changed := t.update(k, func(cur interface{}, exists bool) (interface{}, bool) {
+		return v, !exists || !eq(cur, v)
+	})
+	return oldVal, changed
+}
+
+// update is used to set the value of a key to the one fn decides on, given
+// the existing value, in a single descent, leaving the tree unchanged if fn
+// returns false. Returns the existing value, whether there was one, and
+// whether the tree changed. It keeps the size and stats of the transaction
+// for every conditional write, which must validate the key beforehand if
+// they can insert it.
+func (t *Txn) update(k []byte, fn updateFn) (interface{}, bool, bool) {
+	k = t.storedKey(k)
+	newRoot, oldVal, didUpdate := t.insert(t.root, k, t.opts.path(k), nil, fn)
+	if newRoot == nil {
+		return oldVal, didUpdate, false
+	}
+	t.setRoot(newRoot)
+	if didUpdate {
+		t.stats.LeavesUpdated++
+	} else {
+		t.stats.LeavesInserted++
+		t.size++
+	}
+	if t.trackChanges {
+		t.recordWrite(k, oldVal, didUpdate)
+	}
+	return oldVal, didUpdate, true
+}
+
+// CompareAndSwap is used to replace the value of a key with newVal, but only
+// if its current value equals oldVal, as decided by eq, or by == if eq is
+// nil. It returns whether the value was swapped, which it never is for a key
+// that isn't set. This is done in a single descent of the tree.
+func (t *Txn) CompareAndSwap(k []byte, oldVal, newVal interface{}, eq func(a, b interface{}) bool) bool {
+	t.checkActive()
+	if eq == nil {
+		eq = sameValue
+	}
+	_, _, changed := t.update(k, func(cur interface{}, exists bool) (interface{}, bool) {
+		return newVal, exists && eq(cur, oldVal)
+	})
+	return changed
+}
+
+// Swap is used to exchange the values of two keys. It's all or nothing: if
+// either key isn't set, neither is changed and false is returned. This is
+// done in a single descent, which follows the keys together down to the
+// node where their paths diverge, and then follows each of them from there,
+// so every node along the two paths is visited just once.
+func (t *Txn) Swap(k1, k2 []byte) bool {
+	t.checkActive()
+	p1, p2 := t.opts.path(k1), t.opts.path(k2)
+	if bytes.Equal(p1, p2) {
+		_, ok := t.Get(k1)
+		return ok
+	}
+	newRoot := t.swap(t.root, p1, p2)
+	if newRoot == nil {
+		return false
+	}
+	t.setRoot(newRoot)
+	t.stats.LeavesUpdated += 2
+	if t.trackChanges {
+		t.recordSwap(k1, k2)
+	}
+	return true
+}
+
+// swap does a recursive descent along two paths, exchanging the values of
+// the leaves at their ends. It returns the new node, or nil if either leaf
+// is missing, in which case nothing has been written.
+func (t *Txn) swap(n *Node, search1, search2 []byte) *Node {
+	// Descend together while the paths share an edge
+	if len(search1) != 0 && len(search2) != 0 && search1[0] == search2[0] {
+		idx, child := n.getEdge(search1[0])
+		if child == nil || !bytes.HasPrefix(search1, child.prefix) ||
+			!bytes.HasPrefix(search2, child.prefix) {
+			return nil
+		}
+		newChild := t.swap(child, search1[len(child.prefix):], search2[len(child.prefix):])
+		if newChild == nil {
+			return nil
+		}
+		nc := t.writeNode(n)
+		nc.edges[idx].node = newChild
+		return nc
+	}
+
+	// The paths diverge here, so find both leaves before writing either
+	nodes1, idxs1 := leafPath(n, search1)
+	nodes2, idxs2 := leafPath(n, search2)
+	if nodes1 == nil || nodes2 == nil {
+		return nil
+	}
+	leaf1 := nodes1[len(nodes1)-1].leaf
+	leaf2 := nodes2[len(nodes2)-1].leaf
+
+	// Rewrite the first path including n, and then graft the rewritten
+	// second path below it, since n is shared by both
+	nc := t.writePath(nodes1, idxs1, &leafNode{key: leaf1.key, val: leaf2.val})
+	leaf := &leafNode{key: leaf2.key, val: leaf1.val}
+	if len(nodes2) == 1 {
+		nc.leaf = leaf
+	} else {
+		nc.edges[idxs2[0]].node = t.writePath(nodes2[1:], idxs2[1:], leaf)
+	}
+	return nc
+}
+
+// leafPath returns the nodes from n down to the leaf at the end of search,
+// along with the index of the edge taken from each, or nil if there's no
+// leaf there
+func leafPath(n *Node, search []byte) ([]*Node, []int) {
+	nodes := []*Node{n}
+	var idxs []int
+	curr := n
+	for len(search) != 0 {
+		idx, child := curr.getEdge(search[0])
+		if child == nil || !bytes.HasPrefix(search, child.prefix) {
+			return nil, nil
+		}
+		idxs = append(idxs, idx)
+		nodes = append(nodes, child)
+		search = search[len(child.prefix):]
+		curr = child
+	}
+	if curr.leaf == nil {
+		return nil, nil
+	}
+	return nodes, idxs
+}
+
+// writePath replaces the nodes of a path found by leafPath from the bottom
+// up, giving the last one the new leaf, and returns the new top node
+func (t *Txn) writePath(nodes []*Node, idxs []int, leaf *leafNode) *Node {
+	nc := t.writeNode(nodes[len(nodes)-1])
+	nc.leaf = leaf
+	for i := len(nodes) - 2; i >= 0; i-- {
+		parent := t.writeNode(nodes[i])
+		parent.edges[idxs[i]].node = nc
+		nc = parent
+	}
+	return nc
+}
+
 // Delete is used to delete a given key. Returns the old value if any,
 // and a bool indicating if the key was set.
 func (t *Txn) Delete(k []byte) (interface{}, bool) {
-	newRoot, leaf := t.delete(t.root, k)
+	t.checkActive()
+	oldVal, ok, _ := t.deleteKey(k)
+	return oldVal, ok
+}
+
+// DeleteWithNode is like Delete, but also returns the deepest node changed by
+// the delete, which replaced the node that held the key, or its parent if
+// that node was removed. The nodes above it up to the root were replaced as
+// well, but every other subtree is unchanged, which is useful for
+// invalidating results cached by subtree, such as by Node.Hash. The node
+// is nil if the key wasn't present. As with InsertNode, the node is sealed,
+// so later writes to a transaction with a node pool won't recycle it.
+func (t *Txn) DeleteWithNode(k []byte) (oldVal interface{}, ok bool, changedSubtree *Node) {
+	t.checkActive()
+	oldVal, ok, changedSubtree = t.deleteKey(k)
+	if ok {
+		t.seal()
+	}
+	return oldVal, ok, changedSubtree
+}
+
+// deleteKey does the work of Delete and DeleteWithNode
+func (t *Txn) deleteKey(k []byte) (interface{}, bool, *Node) {
+	newRoot, leaf, deepest := t.delete(t.root, t.opts.path(k))
 	if newRoot != nil {
-		t.root = newRoot
+		t.setRoot(newRoot)
 	}
 	if leaf != nil {
-		return leaf.val, true
+		t.stats.LeavesDeleted++
+		t.size--
+		if t.trackChanges {
+			t.recordDelete(leaf.key, leaf.val)
+		}
+		return leaf.val, true, deepest
+	}
+	return nil, false, nil
+}
+
+// DeleteWhereUnder is used to delete the keys under a prefix for which pred
+// returns true, returning the number deleted. Only the subtree under the
+// prefix is visited.
+func (t *Txn) DeleteWhereUnder(prefix []byte, pred func(k []byte, v interface{}) bool) int {
+	t.checkActive()
+	if t.trackChanges {
+		inner := pred
+		pred = func(k []byte, v interface{}) bool {
+			if !inner(k, v) {
+				return false
+			}
+			t.recordDelete(k, v)
+			return true
+		}
+	}
+	newRoot, count := t.deleteWhereUnder(t.root, t.opts.path(prefix), pred)
+	if count != 0 {
+		t.setRoot(newRoot)
+		t.stats.LeavesDeleted += count
+		t.size -= count
+	}
+	return count
+}
+
+// RenamePrefix is used to move every key under oldPrefix to start with
+// newPrefix instead, keeping its value, and returns the number of keys
+// moved. The keys are removed in a single pruning descent, as with
+// DeleteWhereUnder, and then inserted under their new names. A moved key
+// overwrites any existing key it collides with, including one left under
+// newPrefix beforehand, while other keys under newPrefix are kept. The
+// prefixes may overlap, since every key is removed before any is inserted.
+// It's all or nothing: if the tree's key validator rejects any of the new
+// keys, it panics with the error before anything has changed.
+func (t *Txn) RenamePrefix(oldPrefix, newPrefix []byte) int {
+	count, err := t.RenamePrefixChecked(oldPrefix, newPrefix)
+	if err != nil {
+		panic(err)
+	}
+	return count
+}
+
+// RenamePrefixChecked is like RenamePrefix, but returns the error from the
+// tree's key validator if it rejects any of the new keys, in which case
+// nothing is moved
+func (t *Txn) RenamePrefixChecked(oldPrefix, newPrefix []byte) (int, error) {
+	t.checkActive()
+	var moved []Entry
+	var err error
+	t.WalkPrefix(oldPrefix, func(k []byte, v interface{}) bool {
+		newKey := concat(newPrefix, k[len(oldPrefix):])
+		if err = t.validateKey(newKey); err != nil {
+			return true
+		}
+		moved = append(moved, Entry{Key: newKey, Val: v})
+		return false
+	})
+	if err != nil {
+		return 0, err
+	}
+	t.DeleteWhereUnder(oldPrefix, func([]byte, interface{}) bool {
+		return true
+	})
+	for _, e := range moved {
+		t.insertKey(e.Key, e.Val)
+	}
+	return len(moved), nil
+}
+
+// deleteWhereUnder does a recursive descent to the subtree under a prefix,
+// deleting the keys in it that match the predicate. It returns the new node,
+// or nil if no keys remain, along with the number of keys deleted. The node
+// is unchanged if none were.
+func (t *Txn) deleteWhereUnder(n *Node, search []byte, pred func(k []byte, v interface{}) bool) (*Node, int) {
+	// Only the root is reached with an empty search, other nodes under the
+	// prefix are filtered by their parent
+	if len(search) == 0 {
+		return filterLeaves(n, n == t.root, pred)
+	}
+
+	// Look for an edge
+	label := search[0]
+	idx, child := n.getEdge(label)
+	if child == nil {
+		return n, 0
+	}
+
+	var newChild *Node
+	var count int
+	if bytes.HasPrefix(child.prefix, search) {
+		// The whole child is under the prefix
+		newChild, count = filterLeaves(child, false, pred)
+	} else if bytes.HasPrefix(search, child.prefix) {
+		newChild, count = t.deleteWhereUnder(child, search[len(child.prefix):], pred)
+	}
+	if count == 0 {
+		return n, 0
+	}
+
+	// Copy this node, deleting the edge if nothing remains under it
+	nc := t.writeNode(n)
+	nc.size -= count
+	if newChild == nil {
+		nc.delEdge(label)
+		t.stats.EdgesRemoved++
+		if n != t.root && len(nc.edges) == 1 && !nc.isLeaf() {
+			t.mergeChild(nc)
+		}
+	} else {
+		nc.edges[idx].node = newChild
 	}
-	return nil, false
+	return nc, count
 }
 
 // Root returns the current root of the radix tree within this
 // transaction. The root is not safe across insert and delete operations,
 // but can be used to read the current state during a transaction.
 func (t *Txn) Root() *Node {
+	t.checkActive()
 	return t.root
 }
 
 // Get is used to lookup a specific key, returning
 // the value and if it was found
 func (t *Txn) Get(k []byte) (interface{}, bool) {
-	return t.root.Get(k)
+	t.checkActive()
+	if t.opts != nil && t.opts.keyLen > 0 {
+		return t.root.getFixed(k, t.opts.keyLen)
+	}
+	return t.root.Get(t.opts.path(k))
+}
+
+// GetWithChildren is like Get, but also returns whether any longer keys
+// extend k, which is useful for deciding how to handle conflicts before an
+// insert
+func (t *Txn) GetWithChildren(k []byte) (interface{}, bool, bool) {
+	t.checkActive()
+	return t.root.GetWithChildren(t.opts.path(k))
+}
+
+// Iterator returns an Iterator over the keys of the transaction, reflecting
+// the changes made so far. Unlike an iterator obtained from Root, it panics
+// if the transaction is modified while it's in use, since the nodes it
+// holds may then be stale, or even recycled if the tree uses a node pool.
+func (t *Txn) Iterator() *Iterator {
+	t.checkActive()
+	it := t.root.Iterator()
+	it.opts = t.opts
+	it.txn, it.mutations = t, t.mutations
+	return it
+}
+
+// ReverseIterator is like Iterator, but returns a ReverseIterator
+func (t *Txn) ReverseIterator() *ReverseIterator {
+	t.checkActive()
+	it := t.root.ReverseIterator()
+	it.i.opts = t.opts
+	it.i.txn, it.i.mutations = t, t.mutations
+	return it
+}
+
+// First returns the first key in the transaction's tree and its value,
+// reflecting the changes made so far
+func (t *Txn) First() ([]byte, interface{}, bool) {
+	t.checkActive()
+	return t.root.Minimum()
+}
+
+// Last returns the last key in the transaction's tree and its value,
+// reflecting the changes made so far
+func (t *Txn) Last() ([]byte, interface{}, bool) {
+	t.checkActive()
+	return t.root.Maximum()
+}
+
+// Walk is used to walk the keys of the transaction in order, reflecting all
+// of the changes made so far. The transaction must not be modified during
+// the walk.
+func (t *Txn) Walk(fn WalkFn) {
+	t.checkActive()
+	t.root.Walk(fn)
+}
+
+// WalkPrefix is used to walk the keys of the transaction under a prefix, in
+// order, reflecting all of the changes made so far. The transaction must not
+// be modified during the walk.
+func (t *Txn) WalkPrefix(prefix []byte, fn WalkFn) {
+	t.checkActive()
+	t.root.WalkPrefix(t.opts.path(prefix), fn)
+}
+
+// PendingLen returns the number of keys in the transaction's tree, including
+// the changes made so far, which is the length the tree would have if the
+// transaction were committed now
+func (t *Txn) PendingLen() int {
+	t.checkActive()
+	return t.size
+}
+
+// WouldInsert reports whether inserting the given key would add a new key,
+// rather than update an existing one, without changing anything
+func (t *Txn) WouldInsert(k []byte) bool {
+	t.checkActive()
+	_, ok := t.root.Get(t.opts.path(k))
+	return !ok
 }
 
 // Commit is used to finalize the transaction and return a new tree.
 // Indicates if the Tree has been mutated
 func (t *Txn) Commit() (*Tree, bool) {
-	return &Tree{t.root}, t.root != t.orig
+	t.checkActive()
+
+	// Seal the nodes created so far, now that the new tree shares them
+	t.seal()
+	changed := t.root != t.orig
+	if t.forgetOldRoot {
+		t.orig = t.root
+		t.savepoints = nil
+	}
+	return &Tree{root: t.root, opts: t.opts, size: t.size}, changed
+}
+
+// CommitStats is like Commit, but also returns counts of the work done by
+// the transaction since it began, which is useful for observability
+func (t *Txn) CommitStats() (*Tree, CommitStats) {
+	tree, _ := t.Commit()
+	return tree, t.stats
+}
+
+// Abort is used to explicitly discard the transaction, releasing the state
+// it holds. Any further use of the transaction will panic, which guards
+// against accidentally reusing an abandoned transaction. Trees previously
+// committed from the transaction are unaffected. Aborting an already
+// aborted transaction does nothing.
+func (t *Txn) Abort() {
+	t.root = nil
+	t.orig = nil
+	t.savepoints = nil
+	t.stats = CommitStats{}
+	t.size = 0
+	t.changes = nil
+}
+
+// seal stops the nodes created by the transaction so far from being
+// recycled, once they may be shared outside of it
+func (t *Txn) seal() {
+	if t.gen != 0 {
+		t.gen = nextGen()
+	}
+}
+
+// checkActive panics if the transaction has been aborted
+func (t *Txn) checkActive() {
+	if t.root == nil {
+		panic("use of aborted transaction")
+	}
 }
 
 // Insert is used to add or update a given key. The return provides
 // the new tree, previous value and a bool indicating if any was set.
+// The key is held by the tree, and so must not be modified afterwards.
+// Use a Txn with CopyKeys set to have keys copied instead.
 func (t *Tree) Insert(k []byte, v interface{}) (*Tree, interface{}, bool) {
 	txn := t.Txn()
 	old, ok := txn.Insert(k, v)
@@ -273,6 +1080,36 @@ func (t *Tree) Insert(k []byte, v interface{}) (*Tree, interface{}, bool) {
 	return res, old, ok
 }
 
+// InsertAll returns a new tree with all of the entries of m inserted, in a
+// single transaction. The keys are inserted in sorted order, so that each
+// insert follows a path much like the last one's.
+func (t *Tree) InsertAll(m map[string]interface{}) *Tree {
+	keys := make([]string, 0, len(m))
+	for k := range m {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+
+	txn := t.Txn()
+	for _, k := range keys {
+		txn.Insert([]byte(k), m[k])
+	}
+	tree, _ := txn.Commit()
+	return tree
+}
+
+// InsertChecked is like Insert, but returns the error from the tree's key
+// validator if it rejects the key, in which case the tree is returned as is
+func (t *Tree) InsertChecked(k []byte, v interface{}) (*Tree, interface{}, bool, error) {
+	txn := t.Txn()
+	old, ok, err := txn.InsertChecked(k, v)
+	if err != nil {
+		return t, nil, false, err
+	}
+	res, _ := txn.Commit()
+	return res, old, ok, nil
+}
+
 // Delete is used to delete a given key. Returns the new tree,
 // old value if any, and a bool indicating if the key was set.
 func (t *Tree) Delete(k []byte) (*Tree, interface{}, bool) {
@@ -282,6 +1119,17 @@ func (t *Tree) Delete(k []byte) (*Tree, interface{}, bool) {
 	return res, old, ok
 }
 
+// Len returns the number of keys in the tree
+func (t *Tree) Len() int {
+	return t.size
+}
+
+// CountPrefix returns the number of keys in the tree under the given prefix,
+// as with Node.CountPrefix
+func (t *Tree) CountPrefix(prefix []byte) int {
+	return t.root.CountPrefix(t.opts.path(prefix))
+}
+
 // Root returns the root node of the tree which can be used for richer
 // query operations.
 func (t *Tree) Root() *Node {
@@ -291,7 +1139,254 @@ func (t *Tree) Root() *Node {
 // Get is used to lookup a specific key, returning
 // the value and if it was found
 func (t *Tree) Get(k []byte) (interface{}, bool) {
-	return t.root.Get(k)
+	if t.opts != nil && t.opts.keyLen > 0 {
+		return t.root.getFixed(k, t.opts.keyLen)
+	}
+	return t.root.Get(t.opts.path(k))
+}
+
+// GetFull is like Get, but also returns the key as it was stored, which can
+// differ from k if the tree was created with NewWithFold
+func (t *Tree) GetFull(k []byte) ([]byte, interface{}, bool) {
+	return t.root.GetFull(t.opts.path(k))
+}
+
+// First returns the first key in the tree and its value, as with
+// Node.Minimum
+func (t *Tree) First() ([]byte, interface{}, bool) {
+	return t.root.Minimum()
+}
+
+// Last returns the last key in the tree and its value, as with Node.Maximum
+func (t *Tree) Last() ([]byte, interface{}, bool) {
+	return t.root.Maximum()
+}
+
+// Intern returns the key stored in the tree that is equal to k, or k itself
+// if there isn't one. Holding on to the stored key rather than an equal copy
+// saves memory when many callers hold equal keys, since they can all share
+// the one slice. The returned key must not be modified. In a tree created
+// with NewWithFold, the stored key is the one folding to the same path.
+func (t *Tree) Intern(k []byte) []byte {
+	if stored, _, ok := t.GetFull(k); ok {
+		return stored
+	}
+	return k
+}
+
+// filterLeaves rebuilds the subtree at n without the keys for which pred
+// returns true, returning the new node along with the number removed
+func filterLeaves(n *Node, isRoot bool, pred func(k []byte, v interface{}) bool) (*Node, int) {
+	count := 0
+	nn := rebuild(n, isRoot, func(l *leafNode) *leafNode {
+		if pred(l.key, l.val) {
+			count++
+			return nil
+		}
+		return l
+	})
+	return nn, count
+}
+
+// Filter returns a new tree holding only the entries for which keep returns
+// true. Subtrees in which every entry is kept are shared with this tree
+// rather than copied.
+func (t *Tree) Filter(keep func(k []byte, v interface{}) bool) *Tree {
+	root, removed := filterLeaves(t.root, true, func(k []byte, v interface{}) bool {
+		return !keep(k, v)
+	})
+	return &Tree{root: root, opts: t.opts, size: t.size - removed}
+}
+
+// DeletePrefixExtract returns a new tree without the keys under the given
+// prefix, along with the entries that were removed, in key order. The
+// entries are collected as the subtree under the prefix is pruned, as with
+// DeleteWhereUnder, so only that subtree is visited.
+func (t *Tree) DeletePrefixExtract(prefix []byte) (*Tree, []Entry) {
+	var removed []Entry
+	txn := t.Txn()
+	txn.DeleteWhereUnder(prefix, func(k []byte, v interface{}) bool {
+		removed = append(removed, Entry{Key: k, Val: v})
+		return true
+	})
+	nt, _ := txn.Commit()
+	return nt, removed
+}
+
+// MapValues returns a new tree with the same keys, holding the values that fn
+// returns for each entry. Subtrees in which fn returns every value unchanged,
+// as compared with ==, are shared with this tree rather than copied.
+func (t *Tree) MapValues(fn func(k []byte, v interface{}) interface{}) *Tree {
+	root := rebuild(t.root, true, func(l *leafNode) *leafNode {
+		v := fn(l.key, l.val)
+		if sameValue(v, l.val) {
+			return l
+		}
+		return &leafNode{key: l.key, val: t.opts.intern(v)}
+	})
+	return &Tree{root: root, opts: t.opts, size: t.size}
+}
+
+// Migrate returns a new tree holding the values that fn returns for each
+// entry, dropping those for which it returns false, such as when changing
+// the type of the values stored. This is MapValues and Filter in a single
+// pass, and likewise, subtrees in which every entry is kept with its value
+// unchanged, as compared with ==, are shared with this tree.
+func (t *Tree) Migrate(fn func(k []byte, v interface{}) (interface{}, bool)) *Tree {
+	removed := 0
+	root := rebuild(t.root, true, func(l *leafNode) *leafNode {
+		v, keep := fn(l.key, l.val)
+		switch {
+		case !keep:
+			removed++
+			return nil
+		case sameValue(v, l.val):
+			return l
+		}
+		return &leafNode{key: l.key, val: t.opts.intern(v)}
+	})
+	return &Tree{root: root, opts: t.opts, size: t.size - removed}
+}
+
+// KeysOnly returns a new tree with the same keys, each with a nil value, such
+// as for a view of just the keys that doesn't keep the values alive. Leaves
+// are stored in the nodes rather than beneath them, so a node can only be
+// shared with this tree if every value in its subtree is already nil, and
+// otherwise it's copied along with the path to it. In practice that's the
+// whole tree, except for the keys and prefixes, which are shared.
+func (t *Tree) KeysOnly() *Tree {
+	return t.MapValues(func([]byte, interface{}) interface{} {
+		return nil
+	})
+}
+
+// Snapshot returns a copy of the tree in which every value has been replaced
+// by a clone, for when a caller needs values isolated from other versions of
+// the tree. It uses the hook given to NewWithValueClone, and so returns the
+// tree itself for trees created without one. Subtrees where the hook returns
+// the values unchanged, such as for immutable values, are shared.
+func (t *Tree) Snapshot() *Tree {
+	if t.opts == nil || t.opts.clone == nil {
+		return t
+	}
+	return t.MapValues(func(_ []byte, v interface{}) interface{} {
+		return t.opts.clone(v)
+	})
+}
+
+// Compact returns a copy of the tree in which every key and node is stored
+// in memory of its own, sized to fit. Keys share the memory of the slices
+// they were inserted with, unless CopyKeys was set, so a tree holding a few
+// keys cut from large buffers, or left after deleting most of its keys,
+// can keep much more memory alive than it needs. Compacting releases it,
+// at the cost of copying the whole tree, sharing nothing with this one.
+func (t *Tree) Compact() *Tree {
+	return &Tree{root: t.compactNode(t.root), opts: t.opts, size: t.size}
+}
+
+// compactNode returns a tight copy of the subtree at n
+func (t *Tree) compactNode(n *Node) *Node {
+	nc := &Node{size: n.size}
+	if n.leaf != nil {
+		key := concat(nil, n.leaf.key)
+		nc.leaf = &leafNode{key: key, val: n.leaf.val}
+
+		// A leaf's path ends with the node's prefix, and unless keys are
+		// mapped, the path is the key, so they can share memory
+		if t.opts == nil || t.opts.keyMap == nil {
+			nc.prefix = key[len(key)-len(n.prefix):]
+		}
+	}
+	if nc.prefix == nil && len(n.prefix) != 0 {
+		nc.prefix = concat(nil, n.prefix)
+	}
+	if len(n.edges) != 0 {
+		nc.edges = make(edges, len(n.edges))
+		for i, e := range n.edges {
+			nc.edges[i] = edge{label: e.label, node: t.compactNode(e.node)}
+		}
+	}
+	return nc
+}
+
+// Subtree returns a new tree holding just the entries under the given
+// prefix. If strip is set, the prefix is removed from their keys, which
+// requires the leaves to be copied, but otherwise the nodes under the prefix
+// are shared with this tree. The entries are counted, to give the new tree
+// its length. Stripping a tree created by NewFixedLen shortens the key
+// length of the new tree to match.
+func (t *Tree) Subtree(prefix []byte, strip bool) *Tree {
+	opts := t.opts
+	if strip {
+		opts = t.opts.stripped(len(prefix))
+	}
+	search := t.opts.path(prefix)
+	sub, path := t.root.seekPrefix(search)
+	if sub == nil {
+		return &Tree{root: &Node{}, opts: opts}
+	}
+
+	leaf, es := sub.leaf, sub.edges
+	if strip {
+		top := rebuild(sub, true, func(l *leafNode) *leafNode {
+			return &leafNode{key: l.key[len(prefix):], val: l.val}
+		})
+		leaf, es = top.leaf, top.edges
+		path = path[len(search):]
+	}
+
+	// The subtree hangs from a new root by whatever remains of its path
+	size := sub.size
+	if len(path) == 0 {
+		return &Tree{root: &Node{leaf: leaf, edges: es, size: size}, opts: opts, size: size}
+	}
+	n := &Node{leaf: leaf, prefix: concat(nil, path), edges: es, size: size}
+	return &Tree{
+		root: &Node{edges: edges{{label: path[0], node: n}}, size: size},
+		opts: opts,
+		size: size,
+	}
+}
+
+// Iterator returns an Iterator over the tree, which applies the options the
+// tree was constructed with to the keys it is seeked with
+func (t *Tree) Iterator() *Iterator {
+	it := t.root.Iterator()
+	it.opts = t.opts
+	return it
+}
+
+// ReverseIterator returns a ReverseIterator over the tree, which applies the
+// options the tree was constructed with to the keys it is seeked with
+func (t *Tree) ReverseIterator() *ReverseIterator {
+	it := t.root.ReverseIterator()
+	it.i.opts = t.opts
+	return it
+}
+
+// stripped returns the options for a tree whose keys have had a prefix of
+// the given length removed, which only differ for fixed length keys
+func (o *options) stripped(n int) *options {
+	if o == nil || o.keyLen == 0 || n == 0 || n > o.keyLen {
+		return o
+	}
+	so := *o
+	so.keyLen = o.keyLen - n
+	so.validate = fixedLenValidator(so.keyLen)
+	return &so
+}
+
+// path returns the path a key is indexed by in the tree. It's the key itself
+// unless the tree has a key map, in which case a new mapped copy is returned.
+func (o *options) path(k []byte) []byte {
+	if o == nil || o.keyMap == nil {
+		return k
+	}
+	p := make([]byte, len(k))
+	for i, b := range k {
+		p[i] = o.keyMap[b]
+	}
+	return p
 }
 
 // longestPrefix finds the length of the shared prefix
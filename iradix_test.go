@@ -1,12 +1,15 @@
 package iradix
 
 import (
+	"bytes"
 	"fmt"
 	"math/rand"
 	"reflect"
+	"runtime"
 	"sort"
 	"testing"
 	"testing/quick"
+	"time"
 
 	"github.com/hashicorp/go-uuid"
 )
@@ -14,12 +17,13 @@ import (
 func CopyTree(t *Tree) *Tree {
 	nt := &Tree{
 		root: CopyNode(t.root),
+		size: t.size,
 	}
 	return nt
 }
 
 func CopyNode(n *Node) *Node {
-	nn := &Node{}
+	nn := &Node{size: n.size}
 	if n.prefix != nil {
 		nn.prefix = make([]byte, len(n.prefix))
 		copy(nn.prefix, n.prefix)
@@ -317,6 +321,50 @@ func TestWalkPath(t *testing.T) {
 	}
 }
 
+func TestWalkPathRemainder(t *testing.T) {
+	r := New()
+	for _, k := range []string{"foo", "foo/bar", "foo/baz/bar", "zipzap"} {
+		r, _, _ = r.Insert([]byte(k), nil)
+	}
+
+	cases := []struct {
+		inp  string
+		out  []string
+		rest string
+	}{
+		{"", []string{}, ""},
+		{"f", []string{}, "f"},
+		{"foo", []string{"foo"}, ""},
+		{"foo/", []string{"foo"}, "/"},
+		{"foo/ba", []string{"foo"}, ""},
+		{"foo/bo", []string{"foo"}, "/bo"},
+		{"foo/bar/baz", []string{"foo", "foo/bar"}, "/baz"},
+		{"foo/baz/b", []string{"foo"}, "z/b"},
+		{"zip", []string{}, "zip"},
+		{"x", []string{}, "x"},
+	}
+
+	root := r.Root()
+	for _, test := range cases {
+		out := []string{}
+		rest := root.WalkPathRemainder([]byte(test.inp), func(k []byte, v interface{}) bool {
+			out = append(out, string(k))
+			return false
+		})
+		if !reflect.DeepEqual(out, test.out) || string(rest) != test.rest {
+			t.Fatalf("bad: %q %v %q", test.inp, out, rest)
+		}
+	}
+
+	// Stopping the walk leaves the rest of the path below the last leaf
+	rest := root.WalkPathRemainder([]byte("foo/bar/baz"), func(k []byte, v interface{}) bool {
+		return string(k) == "foo"
+	})
+	if string(rest) != "/bar/baz" {
+		t.Fatalf("bad: %q", rest)
+	}
+}
+
 func TestIteratePrefix(t *testing.T) {
 	r := New()
 
@@ -660,6 +708,30 @@ func TestIterateLowerBound(t *testing.T) {
 			"cbacb",
 			[]string{"cbbaa", "cbbab", "cbbbc", "cbcbb", "cbcbc", "cbcca", "ccaaa", "ccabc", "ccaca", "ccacc", "ccbac", "cccaa", "cccac", "cccca"},
 		},
+
+		// Keys that are a prefix of other keys hold their leaf in an internal
+		// node, which must not hide the keys below it.
+		{
+			[]string{"ab", "abc", "abd", "abe"},
+			"abc",
+			[]string{"abc", "abd", "abe"},
+		}, {
+			[]string{"ab", "abc", "abd", "abe"},
+			"ab",
+			[]string{"ab", "abc", "abd", "abe"},
+		}, {
+			[]string{"ab", "abc", "abd", "abe"},
+			"abca",
+			[]string{"abd", "abe"},
+		}, {
+			[]string{"ab", "abc", "abd", "abe"},
+			"abf",
+			[]string{},
+		}, {
+			[]string{"abc", "abd"},
+			"ab",
+			[]string{"abc", "abd"},
+		},
 	}
 
 	for idx, test := range cases {
@@ -702,7 +774,8 @@ type readableString string
 
 func (s readableString) Generate(rand *rand.Rand, size int) reflect.Value {
 	// Pick a random string from a limited alphabet that makes it easy to read the
-	// failure cases. Also never includes a null byte as we don't support that.
+	// failure cases. Null bytes are left out for readability, and are covered
+	// by TestNullBytes instead.
 	const letters = "abcdefghijklmnopqrstuvwxyz/-_0123456789"
 
 	b := make([]byte, size)
@@ -717,17 +790,14 @@ func TestIterateLowerBoundFuzz(t *testing.T) {
 	set := []string{}
 
 	// This specifies a property where each call adds a new random key to the radix
-	// tree (with a null byte appended since our tree doesn't support one key
-	// being a prefix of another and treats null bytes specially).
+	// tree. Keys may be prefixes of one another.
 	//
 	// It also maintains a plain sorted list of the same set of keys and asserts
 	// that iterating from some random key to the end using LowerBound produces
 	// the same list as filtering all sorted keys that are lower.
 
 	radixAddAndScan := func(newKey, searchKey readableString) []string {
-		// Append a null byte
-		key := []byte(newKey + "\x00")
-		r, _, _ = r.Insert(key, nil)
+		r, _, _ = r.Insert([]byte(newKey), nil)
 
 		// Now iterate the tree from searchKey to the end
 		it := r.Root().Iterator()
@@ -738,8 +808,7 @@ func TestIterateLowerBoundFuzz(t *testing.T) {
 			if !ok {
 				break
 			}
-			// Strip the null byte and append to result set
-			result = append(result, string(key[0:len(key)-1]))
+			result = append(result, string(key))
 		}
 		return result
 	}
@@ -766,3 +835,2239 @@ func TestIterateLowerBoundFuzz(t *testing.T) {
 		t.Error(err)
 	}
 }
+
+func TestIteratePrev(t *testing.T) {
+	r := New()
+	keys := []string{"a", "ab", "abc", "abd", "abe", "b", "ba", "c"}
+	for _, k := range keys {
+		r, _, _ = r.Insert([]byte(k), k)
+	}
+
+	type step struct {
+		next bool
+		want string
+	}
+	cases := []struct {
+		prefix string
+		search string
+		steps  []step
+	}{
+		{
+			"", "",
+			[]step{{false, ""}, {true, "a"}, {true, "ab"}, {false, "ab"}, {false, "a"},
+				{false, ""}, {true, "a"}, {true, "ab"}, {true, "abc"}},
+		},
+		{
+			"", "abd",
+			[]step{{false, "abc"}, {false, "ab"}, {true, "ab"}, {true, "abc"}, {true, "abd"},
+				{true, "abe"}, {false, "abe"}, {false, "abd"}},
+		},
+		{
+			"", "abca",
+			[]step{{true, "abd"}, {false, "abd"}, {false, "abc"}, {true, "abc"}},
+		},
+		{
+			"", "z",
+			[]step{{true, ""}, {false, "c"}, {false, "ba"}, {true, "ba"}, {true, "c"}, {true, ""},
+				{false, "c"}},
+		},
+		{
+			"ab", "",
+			[]step{{false, ""}, {true, "ab"}, {true, "abc"}, {true, "abd"}, {true, "abe"},
+				{true, ""}, {false, "abe"}, {false, "abd"}, {false, "abc"}, {false, "ab"},
+				{false, ""}, {true, "ab"}},
+		},
+		{
+			"b", "",
+			[]step{{true, "b"}, {true, "ba"}, {true, ""}, {true, ""}, {false, "ba"}, {false, "b"},
+				{false, ""}, {false, ""}, {true, "b"}},
+		},
+	}
+
+	for idx, test := range cases {
+		t.Run(fmt.Sprintf("case%03d", idx), func(t *testing.T) {
+			iter := r.Root().Iterator()
+			iter.SeekPrefix([]byte(test.prefix))
+			if test.search != "" {
+				iter.SeekLowerBound([]byte(test.search))
+			}
+			for n, s := range test.steps {
+				var k []byte
+				var v interface{}
+				var ok bool
+				if s.next {
+					k, v, ok = iter.Next()
+				} else {
+					k, v, ok = iter.Prev()
+				}
+				if s.want == "" {
+					if ok {
+						t.Fatalf("step %d: unexpected key %q", n, k)
+					}
+					continue
+				}
+				if !ok || string(k) != s.want || v != s.want {
+					t.Fatalf("step %d: got %q %v %v, want %q", n, k, v, ok, s.want)
+				}
+			}
+		})
+	}
+}
+
+func TestIteratePrevFuzz(t *testing.T) {
+	rnd := rand.New(rand.NewSource(1))
+	const letters = "abc"
+
+	for round := 0; round < 200; round++ {
+		// Build a tree of short keys from a small alphabet, so that plenty of
+		// keys are prefixes of others
+		r := New()
+		set := map[string]bool{}
+		for n := rnd.Intn(20); n > 0; n-- {
+			b := make([]byte, 1+rnd.Intn(4))
+			for j := range b {
+				b[j] = letters[rnd.Intn(len(letters))]
+			}
+			r, _, _ = r.Insert(b, nil)
+			set[string(b)] = true
+		}
+		sorted := []string{}
+		for k := range set {
+			sorted = append(sorted, k)
+		}
+		sort.Strings(sorted)
+
+		// Model the iterator as a position between the sorted keys
+		iter := r.Root().Iterator()
+		pos := 0
+		if rnd.Intn(2) == 0 {
+			search := string(letters[rnd.Intn(len(letters))])
+			if rnd.Intn(2) == 0 {
+				search += string(letters[rnd.Intn(len(letters))])
+			}
+			iter.SeekLowerBound([]byte(search))
+			pos = sort.SearchStrings(sorted, search)
+		}
+
+		for step := 0; step < 30; step++ {
+			want, wantOK := "", false
+			var k []byte
+			var ok bool
+			if rnd.Intn(2) == 0 {
+				k, _, ok = iter.Next()
+				if pos < len(sorted) {
+					want, wantOK = sorted[pos], true
+					pos++
+				}
+			} else {
+				k, _, ok = iter.Prev()
+				if pos > 0 {
+					pos--
+					want, wantOK = sorted[pos], true
+				}
+			}
+			if ok != wantOK || string(k) != want {
+				t.Fatalf("round %d step %d: got %q %v, want %q %v\n  keys=%v",
+					round, step, k, ok, want, wantOK, sorted)
+			}
+		}
+	}
+}
+
+func TestTxnAbort(t *testing.T) {
+	r := New()
+	r, _, _ = r.Insert([]byte("foo"), 1)
+
+	txn := r.Txn()
+	txn.Insert([]byte("bar"), 2)
+	sp := txn.Savepoint()
+	txn.Abort()
+	txn.Abort()
+
+	// The state held by the transaction is released
+	if txn.savepoints != nil || txn.stats != (CommitStats{}) || txn.size != 0 {
+		t.Fatalf("state not released")
+	}
+
+	// The original tree is untouched
+	if _, ok := r.Get([]byte("bar")); ok {
+		t.Fatalf("aborted insert leaked into tree")
+	}
+	if val, ok := r.Get([]byte("foo")); !ok || val != 1 {
+		t.Fatalf("bad: %v", val)
+	}
+
+	uses := map[string]func(){
+		"Insert":     func() { txn.Insert([]byte("baz"), 3) },
+		"Delete":     func() { txn.Delete([]byte("foo")) },
+		"Get":        func() { txn.Get([]byte("foo")) },
+		"Root":       func() { txn.Root() },
+		"Commit":     func() { txn.Commit() },
+		"RollbackTo": func() { txn.RollbackTo(sp) },
+	}
+	for name, use := range uses {
+		t.Run(name, func(t *testing.T) {
+			defer func() {
+				if r := recover(); r != "use of aborted transaction" {
+					t.Fatalf("bad: %v", r)
+				}
+			}()
+			use()
+		})
+	}
+}
+
+func TestNewWithFold(t *testing.T) {
+	lower := func(b byte) byte {
+		if b >= 'A' && b <= 'Z' {
+			return b + 'a' - 'A'
+		}
+		return b
+	}
+	r := NewWithFold(lower)
+	for i, k := range []string{"Foo", "fooBar", "BAZ", "zip"} {
+		r, _, _ = r.Insert([]byte(k), i)
+	}
+
+	// Lookups ignore case
+	for k, want := range map[string]interface{}{"foo": 0, "FOOBAR": 1, "baz": 2, "Zip": 3} {
+		if v, ok := r.Get([]byte(k)); !ok || v != want {
+			t.Fatalf("bad: %s %v %v", k, v, ok)
+		}
+	}
+
+	// An insert that folds to an existing key updates it, and retains the
+	// key as given
+	r, old, ok := r.Insert([]byte("FOO"), 4)
+	if !ok || old != 0 {
+		t.Fatalf("bad: %v %v", old, ok)
+	}
+
+	// Iteration is ordered by the folded keys
+	out := []string{}
+	iter := r.Iterator()
+	iter.SeekPrefix([]byte("FOO"))
+	for k, _, ok := iter.Next(); ok; k, _, ok = iter.Next() {
+		out = append(out, string(k))
+	}
+	if want := []string{"FOO", "fooBar"}; !reflect.DeepEqual(out, want) {
+		t.Fatalf("bad: %v", out)
+	}
+	if k, _, ok := iter.Prev(); !ok || string(k) != "fooBar" {
+		t.Fatalf("bad: %s %v", k, ok)
+	}
+
+	iter = r.Iterator()
+	iter.SeekLowerBound([]byte("Foob"))
+	if k, _, ok := iter.Next(); !ok || string(k) != "fooBar" {
+		t.Fatalf("bad: %s %v", k, ok)
+	}
+
+	rev := r.ReverseIterator()
+	rev.SeekReverseLowerBound([]byte("FOOZ"))
+	if k, _, ok := rev.Previous(); !ok || string(k) != "fooBar" {
+		t.Fatalf("bad: %s %v", k, ok)
+	}
+
+	// Deletes ignore case, and commits carry the fold
+	r, old, ok = r.Delete([]byte("baz"))
+	if !ok || old != 2 {
+		t.Fatalf("bad: %v %v", old, ok)
+	}
+	txn := r.Txn()
+	txn.Insert([]byte("ZAP"), 5)
+	r, _ = txn.Commit()
+	if v, ok := r.Get([]byte("zap")); !ok || v != 5 {
+		t.Fatalf("bad: %v %v", v, ok)
+	}
+}
+
+func TestIterateLimit(t *testing.T) {
+	r := New()
+	keys := []string{"001", "002", "005", "010", "100"}
+	for _, k := range keys {
+		r, _, _ = r.Insert([]byte(k), nil)
+	}
+
+	collect := func(iter *Iterator) []string {
+		out := []string{}
+		for k, _, ok := iter.Next(); ok; k, _, ok = iter.Next() {
+			out = append(out, string(k))
+		}
+		return out
+	}
+
+	// Limit before and after seeking
+	iter := r.Root().Iterator()
+	iter.Limit(2)
+	iter.SeekLowerBound([]byte("002"))
+	if out := collect(iter); !reflect.DeepEqual(out, []string{"002", "005"}) {
+		t.Fatalf("bad: %v", out)
+	}
+	iter = r.Root().Iterator()
+	iter.SeekLowerBound([]byte("002"))
+	iter.Limit(2)
+	if out := collect(iter); !reflect.DeepEqual(out, []string{"002", "005"}) {
+		t.Fatalf("bad: %v", out)
+	}
+
+	// A limit beyond the end, a zero limit and a removed limit
+	iter = r.Root().Iterator()
+	iter.Limit(10)
+	if out := collect(iter); !reflect.DeepEqual(out, keys) {
+		t.Fatalf("bad: %v", out)
+	}
+	iter = r.Root().Iterator()
+	iter.Limit(0)
+	if out := collect(iter); len(out) != 0 {
+		t.Fatalf("bad: %v", out)
+	}
+	iter.Limit(-1)
+	if out := collect(iter); !reflect.DeepEqual(out, keys) {
+		t.Fatalf("bad: %v", out)
+	}
+
+	// Prev counts towards the limit too
+	iter = r.Root().Iterator()
+	iter.Limit(3)
+	iter.Next()
+	iter.Next()
+	if k, _, ok := iter.Prev(); !ok || string(k) != "002" {
+		t.Fatalf("bad: %s %v", k, ok)
+	}
+	if _, _, ok := iter.Next(); ok {
+		t.Fatalf("should be limited")
+	}
+}
+
+func TestFilter(t *testing.T) {
+	r := New()
+	keys := []string{"bar/a", "bar/b", "foo", "foo/a", "foo/b", "zip"}
+	for i, k := range keys {
+		r, _, _ = r.Insert([]byte(k), i)
+	}
+
+	// Dropping one of a pair of leaves merges away the node they split at
+	f := r.Filter(func(k []byte, _ interface{}) bool {
+		return string(k) != "bar/a"
+	})
+	out := []string{}
+	f.Root().Walk(func(k []byte, _ interface{}) bool {
+		out = append(out, string(k))
+		return false
+	})
+	if want := []string{"bar/b", "foo", "foo/a", "foo/b", "zip"}; !reflect.DeepEqual(out, want) {
+		t.Fatalf("bad: %v", out)
+	}
+	if err := f.Verify(); err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	if _, n := f.Root().getEdge('b'); string(n.prefix) != "bar/b" || n.leaf == nil {
+		t.Fatalf("not merged: %q", n.prefix)
+	}
+
+	// Untouched subtrees are shared rather than copied
+	_, orig := r.Root().getEdge('f')
+	_, filtered := f.Root().getEdge('f')
+	if orig != filtered {
+		t.Fatalf("subtree was copied")
+	}
+
+	// The original is unchanged
+	if _, ok := r.Get([]byte("bar/a")); !ok {
+		t.Fatalf("original modified")
+	}
+
+	// Keeping everything returns the same root, and keeping nothing an
+	// empty tree
+	if r.Filter(func([]byte, interface{}) bool { return true }).Root() != r.Root() {
+		t.Fatalf("tree was copied")
+	}
+	empty := r.Filter(func([]byte, interface{}) bool { return false })
+	if _, _, ok := empty.Root().Minimum(); ok || len(empty.Root().edges) != 0 {
+		t.Fatalf("expected empty tree")
+	}
+	empty, _, _ = empty.Insert([]byte("foo"), 1)
+	if v, ok := empty.Get([]byte("foo")); !ok || v != 1 {
+		t.Fatalf("bad: %v %v", v, ok)
+	}
+}
+
+func TestMapValues(t *testing.T) {
+	r := New()
+	keys := []string{"bar/a", "bar/b", "foo", "foo/a", "foo/b"}
+	for i, k := range keys {
+		r, _, _ = r.Insert([]byte(k), i)
+	}
+
+	m := r.MapValues(func(k []byte, v interface{}) interface{} {
+		if bytes.HasPrefix(k, []byte("bar/")) {
+			return v.(int) * 10
+		}
+		return v
+	})
+	for i, k := range keys {
+		want := i
+		if i < 2 {
+			want *= 10
+		}
+		if v, ok := m.Get([]byte(k)); !ok || v != want {
+			t.Fatalf("bad: %s %v %v", k, v, ok)
+		}
+		if v, _ := r.Get([]byte(k)); v != i {
+			t.Fatalf("original modified: %s %v", k, v)
+		}
+	}
+
+	// Untouched subtrees are shared, and changed ones keep their shape
+	_, orig := r.Root().getEdge('f')
+	_, mapped := m.Root().getEdge('f')
+	if orig != mapped {
+		t.Fatalf("subtree was copied")
+	}
+	_, orig = r.Root().getEdge('b')
+	_, mapped = m.Root().getEdge('b')
+	if orig == mapped || !bytes.Equal(orig.prefix, mapped.prefix) || len(orig.edges) != len(mapped.edges) {
+		t.Fatalf("bad subtree: %q %d", mapped.prefix, len(mapped.edges))
+	}
+
+	// Values that can't be compared are treated as changed
+	s := m.MapValues(func(k []byte, v interface{}) interface{} {
+		return []int{v.(int)}
+	})
+	s = s.MapValues(func(k []byte, v interface{}) interface{} {
+		return v
+	})
+	if v, _ := s.Get([]byte("foo")); !reflect.DeepEqual(v, []int{2}) {
+		t.Fatalf("bad: %v", v)
+	}
+}
+
+func TestCommitStats(t *testing.T) {
+	r := New()
+	txn := r.Txn()
+	txn.Insert([]byte("foo"), 1)
+	txn.Insert([]byte("foobar"), 2)
+	txn.Insert([]byte("foo"), 3)
+	txn.Delete([]byte("foobar"))
+	txn.Delete([]byte("nope"))
+	txn.Insert([]byte("fox"), 4)
+	r, stats := txn.CommitStats()
+
+	want := CommitStats{
+		NodesCreated:   14,
+		EdgesAdded:     4,
+		EdgesRemoved:   1,
+		LeavesInserted: 3,
+		LeavesUpdated:  1,
+		LeavesDeleted:  1,
+	}
+	if stats != want {
+		t.Fatalf("bad: %#v", stats)
+	}
+	if v, ok := r.Get([]byte("foo")); !ok || v != 3 {
+		t.Fatalf("bad: %v %v", v, ok)
+	}
+
+	// Deleting a key that merges its parent with the remaining child counts
+	// both removed edges
+	txn = r.Txn()
+	txn.Delete([]byte("fox"))
+	if _, stats = txn.CommitStats(); stats.EdgesRemoved != 2 || stats.LeavesDeleted != 1 {
+		t.Fatalf("bad: %#v", stats)
+	}
+}
+
+func TestNewWithComparator(t *testing.T) {
+	// Order bytes with the top bit flipped, as for the first byte of a
+	// signed big-endian integer
+	signed := func(a, b byte) int {
+		return int(int8(a)) - int(int8(b))
+	}
+	r := NewWithComparator(signed)
+	keys := [][]byte{{0x80}, {0xff, 0x01}, {0xff}, {0x00}, {0x01, 0x00}, {0x7f}}
+	for i, k := range keys {
+		r, _, _ = r.Insert(k, i)
+	}
+
+	out := [][]byte{}
+	iter := r.Iterator()
+	for k, _, ok := iter.Next(); ok; k, _, ok = iter.Next() {
+		out = append(out, k)
+	}
+	want := [][]byte{{0x80}, {0xff}, {0xff, 0x01}, {0x00}, {0x01, 0x00}, {0x7f}}
+	if !reflect.DeepEqual(out, want) {
+		t.Fatalf("bad: %x", out)
+	}
+
+	for i, k := range keys {
+		if v, ok := r.Get(k); !ok || v != i {
+			t.Fatalf("bad: %x %v %v", k, v, ok)
+		}
+	}
+
+	// Lower bounds follow the comparator
+	iter = r.Iterator()
+	iter.SeekLowerBound([]byte{0xfe})
+	if k, _, ok := iter.Next(); !ok || !bytes.Equal(k, []byte{0xff}) {
+		t.Fatalf("bad: %x %v", k, ok)
+	}
+	rev := r.ReverseIterator()
+	rev.SeekReverseLowerBound([]byte{0x00, 0x00})
+	if k, _, ok := rev.Previous(); !ok || !bytes.Equal(k, []byte{0x00}) {
+		t.Fatalf("bad: %x %v", k, ok)
+	}
+
+	// Bytes that compare equal are treated as the same byte
+	r = NewWithComparator(func(a, b byte) int {
+		return int(a&^0x20) - int(b&^0x20)
+	})
+	r, _, _ = r.Insert([]byte("Foo"), 1)
+	if v, ok := r.Get([]byte("fOO")); !ok || v != 1 {
+		t.Fatalf("bad: %v %v", v, ok)
+	}
+}
+
+func TestTxnInsertIfAbsent(t *testing.T) {
+	r := New()
+	r, _, _ = r.Insert([]byte("foo"), 1)
+
+	txn := r.Txn()
+	for _, k := range []string{"foo", "foobar", "fo", "zip", ""} {
+		if _, ok := txn.InsertIfAbsent([]byte(k), k); !ok && k != "foo" {
+			t.Fatalf("not inserted: %q", k)
+		}
+	}
+	for _, k := range []string{"foo", "foobar", "fo", "zip", ""} {
+		root := txn.Root()
+		existing, ok := txn.InsertIfAbsent([]byte(k), 2)
+		if ok || txn.Root() != root {
+			t.Fatalf("inserted: %q", k)
+		}
+		want := interface{}(k)
+		if k == "foo" {
+			want = 1
+		}
+		if existing != want {
+			t.Fatalf("bad: %q %v", k, existing)
+		}
+	}
+	r, stats := txn.CommitStats()
+	if stats.LeavesInserted != 4 || stats.LeavesUpdated != 0 {
+		t.Fatalf("bad: %#v", stats)
+	}
+	if v, _ := r.Get([]byte("foo")); v != 1 {
+		t.Fatalf("bad: %v", v)
+	}
+}
+
+func TestNullBytes(t *testing.T) {
+	// Null bytes are ordinary bytes, which may appear anywhere in a key,
+	// including as the label of an edge
+	keys := []string{
+		"",
+		"\x00",
+		"\x00\x00",
+		"a",
+		"a\x00",
+		"a\x00\x00",
+		"a\x00b",
+		"ab",
+		"ab\x00c",
+		"b",
+	}
+	r := New()
+	for _, i := range rand.Perm(len(keys)) {
+		r, _, _ = r.Insert([]byte(keys[i]), i)
+	}
+	for i, k := range keys {
+		if v, ok := r.Get([]byte(k)); !ok || v != i {
+			t.Fatalf("bad: %q %v %v", k, v, ok)
+		}
+	}
+	for _, k := range []string{"\x00\x00\x00", "a\x00a", "b\x00"} {
+		if _, ok := r.Get([]byte(k)); ok {
+			t.Fatalf("unexpected key: %q", k)
+		}
+	}
+
+	// All the ways of walking the tree agree on the order
+	var forward, backward, iterated, reversed []string
+	r.Root().Walk(func(k []byte, _ interface{}) bool {
+		forward = append(forward, string(k))
+		return false
+	})
+	r.Root().WalkBackwards(func(k []byte, _ interface{}) bool {
+		backward = append([]string{string(k)}, backward...)
+		return false
+	})
+	iter := r.Root().Iterator()
+	for k, _, ok := iter.Next(); ok; k, _, ok = iter.Next() {
+		iterated = append(iterated, string(k))
+	}
+	rev := r.Root().ReverseIterator()
+	for k, _, ok := rev.Previous(); ok; k, _, ok = rev.Previous() {
+		reversed = append([]string{string(k)}, reversed...)
+	}
+	for _, out := range [][]string{forward, backward, iterated, reversed} {
+		if !reflect.DeepEqual(out, keys) {
+			t.Fatalf("bad: %q", out)
+		}
+	}
+
+	// Seeks treat null bytes as the smallest byte
+	iter = r.Root().Iterator()
+	iter.SeekLowerBound([]byte("a\x00\x00\x00"))
+	if k, _, _ := iter.Next(); string(k) != "a\x00b" {
+		t.Fatalf("bad: %q", k)
+	}
+	rev = r.Root().ReverseIterator()
+	rev.SeekReverseLowerBound([]byte("a\x00a"))
+	if k, _, _ := rev.Previous(); string(k) != "a\x00\x00" {
+		t.Fatalf("bad: %q", k)
+	}
+
+	// Keys differing only by a trailing null byte are deleted independently
+	r, _, _ = r.Delete([]byte("a\x00"))
+	if _, ok := r.Get([]byte("a")); !ok {
+		t.Fatalf("missing key")
+	}
+	if _, ok := r.Get([]byte("a\x00\x00")); !ok {
+		t.Fatalf("missing key")
+	}
+	r, _, _ = r.Delete([]byte("a"))
+	if _, ok := r.Get([]byte("a\x00")); ok {
+		t.Fatalf("unexpected key")
+	}
+	if v, ok := r.Get([]byte("a\x00b")); !ok || v != 6 {
+		t.Fatalf("bad: %v %v", v, ok)
+	}
+}
+
+func TestSnapshot(t *testing.T) {
+	r := NewWithValueClone(func(v interface{}) interface{} {
+		if m, ok := v.(map[string]int); ok {
+			c := make(map[string]int, len(m))
+			for k, v := range m {
+				c[k] = v
+			}
+			return c
+		}
+		return v
+	})
+	r, _, _ = r.Insert([]byte("foo"), map[string]int{"a": 1})
+	r, _, _ = r.Insert([]byte("zip/a"), 1)
+	r, _, _ = r.Insert([]byte("zip/b"), 2)
+
+	// Values are shared between versions until they're cloned
+	r2, _, _ := r.Insert([]byte("bar"), 3)
+	v, _ := r2.Get([]byte("foo"))
+	v.(map[string]int)["a"] = 2
+	if v, _ := r.Get([]byte("foo")); v.(map[string]int)["a"] != 2 {
+		t.Fatalf("expected value to be shared")
+	}
+
+	s := r.Snapshot()
+	v, _ = s.Get([]byte("foo"))
+	v.(map[string]int)["a"] = 3
+	if v, _ := r.Get([]byte("foo")); v.(map[string]int)["a"] != 2 {
+		t.Fatalf("expected value to be cloned")
+	}
+
+	// Subtrees of unchanged values are shared, and the hook is carried on
+	_, orig := r.Root().getEdge('z')
+	_, snap := s.Root().getEdge('z')
+	if orig != snap {
+		t.Fatalf("subtree was copied")
+	}
+	s, _, _ = s.Insert([]byte("zap"), map[string]int{})
+	if s.Snapshot() == s {
+		t.Fatalf("hook was lost")
+	}
+
+	// Without a hook, the tree is returned as is
+	if r := New(); r.Snapshot() != r {
+		t.Fatalf("expected the same tree")
+	}
+}
+
+func TestIterateSkipPrefix(t *testing.T) {
+	r := New()
+	keys := []string{"foo", "foo/a", "foo/b", "foo/c/d", "foz", "g", "zip"}
+	for _, k := range keys {
+		r, _, _ = r.Insert([]byte(k), nil)
+	}
+	r, _, _ = r.Insert([]byte("\xff\x01"), nil)
+
+	next := func(iter *Iterator) string {
+		k, _, ok := iter.Next()
+		if !ok {
+			return "<none>"
+		}
+		return string(k)
+	}
+	prev := func(iter *Iterator) string {
+		k, _, ok := iter.Prev()
+		if !ok {
+			return "<none>"
+		}
+		return string(k)
+	}
+
+	iter := r.Root().Iterator()
+	next(iter)
+	next(iter)
+	iter.SkipPrefix([]byte("foo/"))
+	if k := next(iter); k != "foz" {
+		t.Fatalf("bad: %s", k)
+	}
+	if k := prev(iter); k != "foz" {
+		t.Fatalf("bad: %s", k)
+	}
+	if k := prev(iter); k != "foo/c/d" {
+		t.Fatalf("bad: %s", k)
+	}
+
+	// Skipping from the start, and skipping keys already passed
+	iter = r.Root().Iterator()
+	iter.SkipPrefix([]byte("f"))
+	if k := next(iter); k != "g" {
+		t.Fatalf("bad: %s", k)
+	}
+	iter.SkipPrefix([]byte("foo"))
+	if k := next(iter); k != "zip" {
+		t.Fatalf("bad: %s", k)
+	}
+
+	// Skipping the keys after a prefix seek ends the iteration
+	iter = r.Root().Iterator()
+	iter.SeekPrefix([]byte("foo"))
+	iter.SkipPrefix([]byte("foo/"))
+	if k := next(iter); k != "<none>" {
+		t.Fatalf("bad: %s", k)
+	}
+	if k := prev(iter); k != "foo/c/d" {
+		t.Fatalf("bad: %s", k)
+	}
+
+	// Prefixes with no keys after them skip to the end
+	for _, prefix := range []string{"", "\xff"} {
+		iter = r.Root().Iterator()
+		iter.SkipPrefix([]byte(prefix))
+		if k := next(iter); k != "<none>" {
+			t.Fatalf("bad: %s", k)
+		}
+		if k := prev(iter); k != "\xff\x01" {
+			t.Fatalf("bad: %q", k)
+		}
+		if k := prev(iter); k != "zip" {
+			t.Fatalf("bad: %q", k)
+		}
+		if k := next(iter); k != "zip" {
+			t.Fatalf("bad: %q", k)
+		}
+	}
+}
+
+func TestTxnDeleteWhereUnder(t *testing.T) {
+	r := New()
+	keys := []string{"", "cache/a", "cache/b", "cache/c/d", "cachet", "other"}
+	for i, k := range keys {
+		r, _, _ = r.Insert([]byte(k), i)
+	}
+	odd := func(_ []byte, v interface{}) bool {
+		return v.(int)%2 == 1
+	}
+	all := func([]byte, interface{}) bool {
+		return true
+	}
+
+	cases := []struct {
+		prefix string
+		pred   func([]byte, interface{}) bool
+		count  int
+		want   []string
+	}{
+		{"cache/", odd, 2, []string{"", "cache/b", "cachet", "other"}},
+		{"cache", odd, 2, []string{"", "cache/b", "cachet", "other"}},
+		{"cache/", all, 3, []string{"", "cachet", "other"}},
+		{"cach", all, 4, []string{"", "other"}},
+		{"cache/c/", all, 1, []string{"", "cache/a", "cache/b", "cachet", "other"}},
+		{"", odd, 3, []string{"", "cache/b", "cachet"}},
+		{"", all, 6, []string{}},
+		{"nope", all, 0, keys},
+		{"cache/x", all, 0, keys},
+	}
+	for _, c := range cases {
+		txn := r.Txn()
+		if count := txn.DeleteWhereUnder([]byte(c.prefix), c.pred); count != c.count {
+			t.Fatalf("bad count: %q %d", c.prefix, count)
+		}
+		if c.count == 0 && txn.Root() != r.Root() {
+			t.Fatalf("tree was copied: %q", c.prefix)
+		}
+		nr, _ := txn.Commit()
+		if err := nr.Verify(); err != nil {
+			t.Fatalf("err: %q %v", c.prefix, err)
+		}
+		out := []string{}
+		nr.Root().Walk(func(k []byte, _ interface{}) bool {
+			out = append(out, string(k))
+			return false
+		})
+		if !reflect.DeepEqual(out, c.want) {
+			t.Fatalf("bad: %q %v", c.prefix, out)
+		}
+	}
+
+	// Subtrees outside the prefix are shared
+	txn := r.Txn()
+	txn.DeleteWhereUnder([]byte("cache/"), all)
+	_, orig := r.Root().getEdge('o')
+	_, after := txn.Root().getEdge('o')
+	if orig != after {
+		t.Fatalf("subtree was copied")
+	}
+}
+
+func TestTxnCopyKeys(t *testing.T) {
+	for _, copyKeys := range []bool{false, true} {
+		txn := New().Txn()
+		txn.CopyKeys(copyKeys)
+
+		// Insert keys from a reused buffer
+		buf := []byte("foo1")
+		txn.Insert(buf, 1)
+		copy(buf, "foo2")
+		txn.Insert(buf, 2)
+		copy(buf, "zip3")
+		r, _ := txn.Commit()
+
+		out := []string{}
+		r.Root().Walk(func(k []byte, _ interface{}) bool {
+			out = append(out, string(k))
+			return false
+		})
+		if copyKeys {
+			// The keys are isolated from the buffer
+			if want := []string{"foo1", "foo2"}; !reflect.DeepEqual(out, want) {
+				t.Fatalf("bad: %v", out)
+			}
+			if v, ok := r.Get([]byte("foo2")); !ok || v != 2 {
+				t.Fatalf("bad: %v %v", v, ok)
+			}
+		} else {
+			// The key aliases the buffer, so that the second insert
+			// found it as an existing key, and it has since been corrupted
+			if want := []string{"zip3"}; !reflect.DeepEqual(out, want) {
+				t.Fatalf("bad: %v", out)
+			}
+		}
+	}
+}
+
+func TestIteratorReset(t *testing.T) {
+	r := New()
+	for _, k := range []string{"001", "002", "005", "010", "100"} {
+		r, _, _ = r.Insert([]byte(k), nil)
+	}
+	r2, _, _ := r.Insert([]byte("003"), nil)
+
+	iter := r.Root().Iterator()
+	iter.Limit(1)
+	iter.SeekLowerBound([]byte("005"))
+	iter.Next()
+
+	// Resetting clears the seek and the limit
+	for _, tree := range []*Tree{r2, r} {
+		iter.Reset(tree.Root())
+		out := []string{}
+		for k, _, ok := iter.Next(); ok; k, _, ok = iter.Next() {
+			out = append(out, string(k))
+		}
+		want := []string{}
+		tree.Root().Walk(func(k []byte, _ interface{}) bool {
+			want = append(want, string(k))
+			return false
+		})
+		if !reflect.DeepEqual(out, want) {
+			t.Fatalf("bad: %v", out)
+		}
+	}
+
+	iter.Reset(r2.Root())
+	iter.SeekPrefix([]byte("00"))
+	iter.SkipPrefix([]byte("001"))
+	if k, _, ok := iter.Next(); !ok || string(k) != "002" {
+		t.Fatalf("bad: %s", k)
+	}
+	if k, _, ok := iter.Prev(); !ok || string(k) != "002" {
+		t.Fatalf("bad: %s", k)
+	}
+}
+
+func benchmarkIteratorQueries(b *testing.B, reset bool) {
+	txn := New().Txn()
+	for i := 0; i < 10000; i++ {
+		txn.Insert([]byte(fmt.Sprintf("%08d", i)), i)
+	}
+	r, _ := txn.Commit()
+	prefixes := make([][]byte, 100)
+	for i := range prefixes {
+		prefixes[i] = []byte(fmt.Sprintf("%06d", i*97))
+	}
+
+	iter := r.Root().Iterator()
+	b.ResetTimer()
+	b.ReportAllocs()
+	for n := 0; n < b.N; n++ {
+		for _, prefix := range prefixes {
+			if reset {
+				iter.Reset(r.Root())
+			} else {
+				iter = r.Root().Iterator()
+			}
+			iter.SeekPrefix(prefix)
+			for _, _, ok := iter.Next(); ok; _, _, ok = iter.Next() {
+			}
+		}
+	}
+}
+
+func BenchmarkIteratorNew(b *testing.B) {
+	benchmarkIteratorQueries(b, false)
+}
+
+func BenchmarkIteratorReset(b *testing.B) {
+	benchmarkIteratorQueries(b, true)
+}
+
+func TestNewWithKeyValidator(t *testing.T) {
+	noSlash := func(k []byte) error {
+		if bytes.IndexByte(k, '/') >= 0 {
+			return fmt.Errorf("key %q contains a slash", k)
+		}
+		return nil
+	}
+	r := NewWithKeyValidator(noSlash)
+
+	r, _, _, err := r.InsertChecked([]byte("foo"), 1)
+	if err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	r2, _, _, err := r.InsertChecked([]byte("foo/bar"), 2)
+	if err == nil || r2 != r {
+		t.Fatalf("expected rejection: %v", err)
+	}
+
+	txn := r.Txn()
+	if _, _, err := txn.InsertChecked([]byte("zip/zap"), 3); err == nil {
+		t.Fatalf("expected rejection")
+	}
+	if _, ok, err := txn.InsertChecked([]byte("foo"), 3); err != nil || !ok {
+		t.Fatalf("bad: %v %v", ok, err)
+	}
+	r, _ = txn.Commit()
+	if _, ok := r.Get([]byte("zip/zap")); ok {
+		t.Fatalf("invalid key was stored")
+	}
+
+	// Insert can't return the error, so panics with it instead
+	for _, insert := range []func(){
+		func() { r.Insert([]byte("a/b"), nil) },
+		func() { r.Txn().InsertIfAbsent([]byte("a/b"), nil) },
+	} {
+		func() {
+			defer func() {
+				if err, ok := recover().(error); !ok || err.Error() != `key "a/b" contains a slash` {
+					t.Fatalf("bad: %v", err)
+				}
+			}()
+			insert()
+		}()
+	}
+	if r, _, _ = r.Insert([]byte("bar"), 4); r.opts.validate == nil {
+		t.Fatalf("validator was lost")
+	}
+}
+
+func TestSubtree(t *testing.T) {
+	r := New()
+	keys := []string{"", "foo", "foo/bar", "foo/bar/baz", "foo/zip", "foobar", "zip"}
+	for i, k := range keys {
+		r, _, _ = r.Insert([]byte(k), i)
+	}
+
+	cases := []struct {
+		prefix string
+		strip  bool
+		want   []string
+	}{
+		{"foo/", false, []string{"foo/bar", "foo/bar/baz", "foo/zip"}},
+		{"foo/", true, []string{"bar", "bar/baz", "zip"}},
+		{"foo/b", false, []string{"foo/bar", "foo/bar/baz"}},
+		{"foo/b", true, []string{"ar", "ar/baz"}},
+		{"foo", true, []string{"", "/bar", "/bar/baz", "/zip", "bar"}},
+		{"foo/bar/baz", true, []string{""}},
+		{"", false, keys},
+		{"", true, keys},
+		{"nope", false, []string{}},
+		{"foo/x", true, []string{}},
+	}
+	for _, c := range cases {
+		sub := r.Subtree([]byte(c.prefix), c.strip)
+		if err := sub.Verify(); err != nil {
+			t.Fatalf("err: %q %v %v", c.prefix, c.strip, err)
+		}
+		out := []string{}
+		sub.Root().Walk(func(k []byte, v interface{}) bool {
+			if orig := keys[v.(int)]; orig != c.prefix+string(k) && orig != string(k) {
+				t.Fatalf("bad value for %q: %v", k, v)
+			}
+			out = append(out, string(k))
+			return false
+		})
+		if !reflect.DeepEqual(out, c.want) {
+			t.Fatalf("bad: %q %v %v", c.prefix, c.strip, out)
+		}
+	}
+
+	// Without stripping, the nodes under the prefix are shared
+	orig, _ := r.Root().seekPrefix([]byte("foo/"))
+	sub := r.Subtree([]byte("foo/"), false)
+	n, _ := sub.Root().seekPrefix([]byte("foo/"))
+	if len(n.edges) == 0 || len(n.edges) != len(orig.edges) {
+		t.Fatalf("bad: %d", len(n.edges))
+	}
+	for i := range n.edges {
+		if n.edges[i].node != orig.edges[i].node {
+			t.Fatalf("subtree was copied")
+		}
+	}
+
+	// The subtree is a tree like any other
+	sub, _, _ = sub.Insert([]byte("foo/bax"), -1)
+	if err := sub.Verify(); err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	if _, ok := r.Get([]byte("foo/bax")); ok {
+		t.Fatalf("original modified")
+	}
+}
+
+func TestEmptyTree(t *testing.T) {
+	r := New()
+	root := r.Root()
+	fail := func(k []byte, v interface{}) bool {
+		t.Fatalf("unexpected key %q", k)
+		return true
+	}
+
+	for _, k := range [][]byte{nil, []byte("foo")} {
+		if _, ok := r.Get(k); ok {
+			t.Fatalf("unexpected key")
+		}
+		if _, _, ok := root.LongestPrefix(k); ok {
+			t.Fatalf("unexpected key")
+		}
+		if _, _, ok := root.ShortestPrefix(k); ok {
+			t.Fatalf("unexpected key")
+		}
+		if _, _, _, ok := root.GetClosest(k); ok {
+			t.Fatalf("unexpected key")
+		}
+		if _, _, ok := root.MinimumPrefix(k); ok {
+			t.Fatalf("unexpected key")
+		}
+		if _, _, ok := root.MaximumPrefix(k); ok {
+			t.Fatalf("unexpected key")
+		}
+		if root.HasPrefix(k) {
+			t.Fatalf("unexpected key")
+		}
+		if out := root.GetMulti([][]byte{k}); out[0].Ok {
+			t.Fatalf("unexpected key")
+		}
+		root.WalkPrefix(k, fail)
+		root.WalkPath(k, fail)
+		root.WalkFrom(k, fail)
+		root.WalkChildren(k, '/', fail)
+
+		it := root.Iterator()
+		it.SeekLowerBound(k)
+		for i := 0; i < 3; i++ {
+			if _, _, ok := it.Next(); ok {
+				t.Fatalf("unexpected key")
+			}
+			if _, _, ok := it.Prev(); ok {
+				t.Fatalf("unexpected key")
+			}
+		}
+		it.SeekPrefix(k)
+		it.SkipPrefix(k)
+		if _, _, ok := it.Next(); ok {
+			t.Fatalf("unexpected key")
+		}
+
+		rit := root.ReverseIterator()
+		rit.SeekReverseLowerBound(k)
+		for i := 0; i < 3; i++ {
+			if _, _, ok := rit.Previous(); ok {
+				t.Fatalf("unexpected key")
+			}
+		}
+		rit.SeekPrefix(k)
+		if _, _, ok := rit.Previous(); ok {
+			t.Fatalf("unexpected key")
+		}
+
+		sit := root.SubtreeIterator(k, 1)
+		for i := 0; i < 3; i++ {
+			if _, _, ok := sit.Next(); ok {
+				t.Fatalf("unexpected subtree")
+			}
+		}
+
+		if _, _, ok := r.Delete(k); ok {
+			t.Fatalf("unexpected delete")
+		}
+		r.Subtree(k, true).Root().Walk(fail)
+	}
+
+	if _, _, ok := root.Minimum(); ok {
+		t.Fatalf("unexpected key")
+	}
+	if _, _, ok := root.Maximum(); ok {
+		t.Fatalf("unexpected key")
+	}
+	root.Walk(fail)
+	root.WalkBackwards(fail)
+	root.WalkNodes(func(prefix []byte, depth int, isLeaf bool, val interface{}) bool {
+		if isLeaf {
+			t.Fatalf("unexpected leaf")
+		}
+		return false
+	})
+
+	mit := NewMergeIterator(root, New().Root())
+	for i := 0; i < 3; i++ {
+		if _, _, _, _, _, ok := mit.Next(); ok {
+			t.Fatalf("unexpected key")
+		}
+	}
+	Diff(r, New(), func(op DiffOp, k []byte, oldVal, newVal interface{}) bool {
+		t.Fatalf("unexpected diff")
+		return true
+	})
+	if err := r.Verify(); err != nil {
+		t.Fatalf("err: %v", err)
+	}
+}
+
+func TestIteratorReseek(t *testing.T) {
+	r := New()
+	for _, k := range []string{"a", "foo", "foo/bar", "foo/baz", "zip"} {
+		r, _, _ = r.Insert([]byte(k), nil)
+	}
+	keys := func(next func() ([]byte, interface{}, bool)) []string {
+		out := []string{}
+		for k, _, ok := next(); ok; k, _, ok = next() {
+			out = append(out, string(k))
+		}
+		return out
+	}
+
+	// Each seek starts afresh from the node the iterator was created at,
+	// whether or not the iterator was exhausted
+	it := r.Root().Iterator()
+	it.SeekPrefix([]byte("foo/"))
+	if out := keys(it.Next); !reflect.DeepEqual(out, []string{"foo/bar", "foo/baz"}) {
+		t.Fatalf("bad: %v", out)
+	}
+	it.SeekPrefix([]byte("zip"))
+	if out := keys(it.Next); !reflect.DeepEqual(out, []string{"zip"}) {
+		t.Fatalf("bad: %v", out)
+	}
+	it.SeekLowerBound([]byte("foo/baz"))
+	if out := keys(it.Next); !reflect.DeepEqual(out, []string{"foo/baz", "zip"}) {
+		t.Fatalf("bad: %v", out)
+	}
+	it.SeekLowerBound([]byte("b"))
+	if out := keys(it.Prev); !reflect.DeepEqual(out, []string{"a"}) {
+		t.Fatalf("bad: %v", out)
+	}
+
+	rit := r.Root().ReverseIterator()
+	rit.SeekReverseLowerBound([]byte("foo/bar"))
+	if out := keys(rit.Previous); !reflect.DeepEqual(out, []string{"foo/bar", "foo", "a"}) {
+		t.Fatalf("bad: %v", out)
+	}
+	rit.SeekReverseLowerBound([]byte("zz"))
+	if out := keys(rit.Previous); len(out) != 5 {
+		t.Fatalf("bad: %v", out)
+	}
+}
+
+func TestTxnWalk(t *testing.T) {
+	r := New()
+	for _, k := range []string{"foo", "foo/bar", "zip"} {
+		r, _, _ = r.Insert([]byte(k), nil)
+	}
+
+	collect := func(walk func(WalkFn)) []string {
+		out := []string{}
+		walk(func(k []byte, v interface{}) bool {
+			out = append(out, string(k))
+			return false
+		})
+		return out
+	}
+
+	// The walks see the changes made so far
+	txn := r.Txn()
+	txn.Insert([]byte("foo/baz"), nil)
+	txn.Delete([]byte("zip"))
+	if out := collect(txn.Walk); !reflect.DeepEqual(out, []string{"foo", "foo/bar", "foo/baz"}) {
+		t.Fatalf("bad: %v", out)
+	}
+	txn.Delete([]byte("foo/bar"))
+	walkPrefix := func(fn WalkFn) { txn.WalkPrefix([]byte("foo/"), fn) }
+	if out := collect(walkPrefix); !reflect.DeepEqual(out, []string{"foo/baz"}) {
+		t.Fatalf("bad: %v", out)
+	}
+
+	txn.Abort()
+	defer func() {
+		if r := recover(); r != "use of aborted transaction" {
+			t.Fatalf("bad: %v", r)
+		}
+	}()
+	collect(txn.Walk)
+}
+
+func TestTreeIntern(t *testing.T) {
+	r := New()
+	stored := []byte("foo/bar")
+	r, _, _ = r.Insert(stored, nil)
+
+	k := r.Intern([]byte("foo/bar"))
+	if &k[0] != &stored[0] {
+		t.Fatalf("expected the stored key")
+	}
+	other := []byte("foo/baz")
+	if k := r.Intern(other); &k[0] != &other[0] {
+		t.Fatalf("expected the given key")
+	}
+}
+
+func BenchmarkTreeIntern(b *testing.B) {
+	const distinct, copies = 100, 1000
+	r := New()
+	for i := 0; i < distinct; i++ {
+		r, _, _ = r.Insert([]byte(fmt.Sprintf("some/fairly/long/key/%08d", i)), nil)
+	}
+
+	for _, intern := range []bool{false, true} {
+		b.Run(fmt.Sprintf("intern=%v", intern), func(b *testing.B) {
+			var retained int64
+			for n := 0; n < b.N; n++ {
+				var before, after runtime.MemStats
+				runtime.GC()
+				runtime.ReadMemStats(&before)
+
+				held := make([][]byte, 0, distinct*copies)
+				for c := 0; c < copies; c++ {
+					for i := 0; i < distinct; i++ {
+						k := []byte(fmt.Sprintf("some/fairly/long/key/%08d", i))
+						if intern {
+							k = r.Intern(k)
+						}
+						held = append(held, k)
+					}
+				}
+
+				runtime.GC()
+				runtime.ReadMemStats(&after)
+				retained += int64(after.HeapAlloc) - int64(before.HeapAlloc)
+				runtime.KeepAlive(held)
+			}
+			b.ReportMetric(float64(retained)/float64(b.N), "retained-B/op")
+		})
+	}
+}
+
+func TestTxnDeleteWithNode(t *testing.T) {
+	r := New()
+	for _, k := range []string{"foo", "foo/bar", "foo/baz", "foo/zip", "zap"} {
+		r, _, _ = r.Insert([]byte(k), k)
+	}
+
+	// contains reports whether the subtree at n holds the node x
+	var contains func(n, x *Node) bool
+	contains = func(n, x *Node) bool {
+		if n == x {
+			return true
+		}
+		for _, e := range n.edges {
+			if contains(e.node, x) {
+				return true
+			}
+		}
+		return false
+	}
+
+	cases := []struct {
+		key  string
+		keys []string
+	}{
+		// The node holding the key is replaced
+		{"foo", []string{"foo/bar", "foo/baz", "foo/zip"}},
+
+		// The node holding the key is removed, so its parent is replaced,
+		// and merged with its remaining child
+		{"foo/bar", []string{"foo/baz"}},
+		{"foo/zip", []string{"foo/bar", "foo/baz"}},
+
+		// The root is never merged
+		{"zap", []string{"foo", "foo/bar", "foo/baz", "foo/zip"}},
+	}
+	for _, c := range cases {
+		txn := r.Txn()
+		old, ok, n := txn.DeleteWithNode([]byte(c.key))
+		if !ok || old != c.key {
+			t.Fatalf("bad: %q %v %v", c.key, old, ok)
+		}
+		if contains(r.Root(), n) || !contains(txn.Root(), n) {
+			t.Fatalf("expected a new node in the new tree: %q", c.key)
+		}
+		var keys []string
+		n.Walk(func(k []byte, v interface{}) bool {
+			keys = append(keys, string(k))
+			return false
+		})
+		if !reflect.DeepEqual(keys, c.keys) {
+			t.Fatalf("bad: %q %v", c.key, keys)
+		}
+	}
+
+	txn := r.Txn()
+	if _, ok, n := txn.DeleteWithNode([]byte("foo/ba")); ok || n != nil {
+		t.Fatalf("bad: %v %v", ok, n)
+	}
+}
+
+func TestTreeLen(t *testing.T) {
+	r := New()
+	if r.Len() != 0 {
+		t.Fatalf("bad: %d", r.Len())
+	}
+
+	txn := r.Txn()
+	for _, k := range []string{"", "foo", "foo/bar", "foo/baz", "zip"} {
+		if !txn.WouldInsert([]byte(k)) {
+			t.Fatalf("expected %q to be new", k)
+		}
+		txn.Insert([]byte(k), k)
+	}
+	if txn.WouldInsert([]byte("foo")) || txn.PendingLen() != 5 {
+		t.Fatalf("bad: %d", txn.PendingLen())
+	}
+
+	// Updates and absent keys don't change the length
+	txn.Insert([]byte("foo"), 1)
+	txn.InsertIfAbsent([]byte("zip"), 2)
+	txn.Delete([]byte("nope"))
+	if txn.PendingLen() != 5 {
+		t.Fatalf("bad: %d", txn.PendingLen())
+	}
+	sp := txn.Savepoint()
+	txn.InsertIfAbsent([]byte("zap"), 3)
+	txn.DeleteWhereUnder([]byte("foo/"), func(k []byte, v interface{}) bool { return true })
+	if txn.PendingLen() != 4 {
+		t.Fatalf("bad: %d", txn.PendingLen())
+	}
+	txn.RollbackTo(sp)
+	txn.Delete([]byte(""))
+	r, _ = txn.Commit()
+	if r.Len() != 4 || r.ReadSnapshot().Len() != 4 {
+		t.Fatalf("bad: %d", r.Len())
+	}
+
+	// Trees derived from others have the right lengths
+	checks := map[string]struct {
+		tree *Tree
+		len  int
+	}{
+		"Filter":    {r.Filter(func(k []byte, v interface{}) bool { return len(k) > 3 }), 2},
+		"MapValues": {r.MapValues(func(k []byte, v interface{}) interface{} { return nil }), 4},
+		"Subtree":   {r.Subtree([]byte("foo"), false), 3},
+		"Stripped":  {r.Subtree([]byte("foo/"), true), 2},
+		"Empty":     {r.Subtree([]byte("nope"), false), 0},
+	}
+	for name, c := range checks {
+		if c.tree.Len() != c.len {
+			t.Fatalf("bad: %s %d", name, c.tree.Len())
+		}
+		if err := c.tree.Verify(); err != nil {
+			t.Fatalf("err: %s %v", name, err)
+		}
+	}
+}
+
+func TestTreeCompact(t *testing.T) {
+	// Cut the keys from one large buffer
+	buf := make([]byte, 0, 1<<16)
+	r := New()
+	for i, k := range []string{"", "foo", "foo/bar", "foo/baz", "zip"} {
+		start := len(buf)
+		buf = append(buf, k...)
+		r, _, _ = r.Insert(buf[start:len(buf):len(buf)], i)
+	}
+
+	c := r.Compact()
+	if err := c.Verify(); err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	if c.Len() != r.Len() || c.Root().Hash() != r.Root().Hash() {
+		t.Fatalf("compacted tree differs")
+	}
+
+	// Nothing is shared with the buffer
+	for i := range buf {
+		buf[i] = 'x'
+	}
+	var keys []string
+	c.Root().Walk(func(k []byte, v interface{}) bool {
+		if cap(k) != len(k) {
+			t.Fatalf("bad capacity: %q %d", k, cap(k))
+		}
+		keys = append(keys, string(k))
+		return false
+	})
+	if want := []string{"", "foo", "foo/bar", "foo/baz", "zip"}; !reflect.DeepEqual(keys, want) {
+		t.Fatalf("bad: %v", keys)
+	}
+	if v, ok := c.Get([]byte("foo/baz")); !ok || v != 3 {
+		t.Fatalf("bad: %v", v)
+	}
+}
+
+func TestTxnCompareAndSwap(t *testing.T) {
+	r := New()
+	r, _, _ = r.Insert([]byte("foo"), 1)
+	r, _, _ = r.Insert([]byte("foo/bar"), []int{1})
+
+	txn := r.Txn()
+	if txn.CompareAndSwap([]byte("foo"), 2, 3, nil) {
+		t.Fatalf("unexpected swap")
+	}
+	if txn.CompareAndSwap([]byte("fo"), nil, 3, nil) || txn.CompareAndSwap([]byte("zip"), nil, 3, nil) {
+		t.Fatalf("unexpected swap of missing key")
+	}
+	if txn.Root() != r.Root() {
+		t.Fatalf("tree changed")
+	}
+	if !txn.CompareAndSwap([]byte("foo"), 1, 2, nil) {
+		t.Fatalf("expected swap")
+	}
+
+	// Values that can't be compared with == need an eq
+	eq := func(a, b interface{}) bool { return reflect.DeepEqual(a, b) }
+	if txn.CompareAndSwap([]byte("foo/bar"), []int{1}, []int{2}, nil) {
+		t.Fatalf("unexpected swap")
+	}
+	if !txn.CompareAndSwap([]byte("foo/bar"), []int{1}, []int{2}, eq) {
+		t.Fatalf("expected swap")
+	}
+
+	r2, stats := txn.CommitStats()
+	if v, _ := r2.Get([]byte("foo")); v != 2 {
+		t.Fatalf("bad: %v", v)
+	}
+	if v, _ := r2.Get([]byte("foo/bar")); !reflect.DeepEqual(v, []int{2}) {
+		t.Fatalf("bad: %v", v)
+	}
+	if stats.LeavesUpdated != 2 || r2.Len() != 2 {
+		t.Fatalf("bad: %+v %d", stats, r2.Len())
+	}
+}
+
+func TestTxnSwap(t *testing.T) {
+	r := New()
+	r, _, _ = r.Insert([]byte("a"), 1)
+	r, _, _ = r.Insert([]byte("ab"), 2)
+	r, _, _ = r.Insert([]byte("b"), 3)
+
+	txn := r.Txn()
+	for _, keys := range [][2]string{{"a", "zip"}, {"zip", "a"}, {"zip", "zap"}, {"zip", "zip"}} {
+		if txn.Swap([]byte(keys[0]), []byte(keys[1])) {
+			t.Fatalf("unexpected swap: %v", keys)
+		}
+	}
+	if txn.Root() != r.Root() {
+		t.Fatalf("tree changed")
+	}
+	if !txn.Swap([]byte("a"), []byte("a")) || txn.Root() != r.Root() {
+		t.Fatalf("expected a no-op swap")
+	}
+
+	// Iterators notice the swap
+	it := txn.Iterator()
+	if !txn.Swap([]byte("a"), []byte("ab")) || !txn.Swap([]byte("ab"), []byte("b")) {
+		t.Fatalf("expected swap")
+	}
+	func() {
+		defer func() {
+			if recover() == nil {
+				t.Fatalf("expected panic")
+			}
+		}()
+		it.Next()
+	}()
+
+	r2, stats := txn.CommitStats()
+	for k, want := range map[string]int{"a": 2, "ab": 3, "b": 1} {
+		if v, _ := r2.Get([]byte(k)); v != want {
+			t.Fatalf("bad: %s %v", k, v)
+		}
+	}
+	if stats.LeavesUpdated != 4 || r2.Len() != 3 {
+		t.Fatalf("bad: %+v %d", stats, r2.Len())
+	}
+	if err := r2.Verify(); err != nil {
+		t.Fatalf("err: %v", err)
+	}
+}
+
+func TestTxnSwapRandom(t *testing.T) {
+	rnd := rand.New(rand.NewSource(1))
+	keys := []string{"", "a", "ab", "abc", "abd", "b", "ba", "bab", "c"}
+	for _, newTree := range []func() *Tree{New, NewWithNodePool} {
+		m := make(map[string]int)
+		txn := newTree().Txn()
+		for i := 0; i < 2000; i++ {
+			k1, k2 := keys[rnd.Intn(len(keys))], keys[rnd.Intn(len(keys))]
+			if rnd.Intn(3) == 0 {
+				txn.Insert([]byte(k1), i)
+				m[k1] = i
+				continue
+			}
+			v1, ok1 := m[k1]
+			v2, ok2 := m[k2]
+			before := txn.Root()
+			if ok := txn.Swap([]byte(k1), []byte(k2)); ok != (ok1 && ok2) {
+				t.Fatalf("bad: %q %q %v", k1, k2, ok)
+			}
+			if !ok1 || !ok2 {
+				if txn.Root() != before {
+					t.Fatalf("tree changed: %q %q", k1, k2)
+				}
+				continue
+			}
+			m[k1], m[k2] = v2, v1
+		}
+
+		r, _ := txn.Commit()
+		if err := r.Verify(); err != nil {
+			t.Fatalf("err: %v", err)
+		}
+		for k, want := range m {
+			if v, _ := r.Get([]byte(k)); v != want {
+				t.Fatalf("bad: %q %v %v", k, v, want)
+			}
+		}
+	}
+}
+
+func TestTxnSwapFold(t *testing.T) {
+	r := NewWithFold(func(b byte) byte { return b | 0x20 })
+	r, _, _ = r.Insert([]byte("Foo"), 1)
+	r, _, _ = r.Insert([]byte("Bar"), 2)
+
+	// The stored keys are kept, however the keys are spelled
+	txn := r.Txn()
+	if !txn.Swap([]byte("FOO"), []byte("bar")) {
+		t.Fatalf("expected swap")
+	}
+	var out []string
+	txn.Root().Walk(func(k []byte, v interface{}) bool {
+		out = append(out, fmt.Sprintf("%s=%v", k, v))
+		return false
+	})
+	if !reflect.DeepEqual(out, []string{"Bar=1", "Foo=2"}) {
+		t.Fatalf("bad: %v", out)
+	}
+}
+
+func TestTreeInsertAll(t *testing.T) {
+	m := make(map[string]interface{})
+	for i := 0; i < 1000; i++ {
+		m[fmt.Sprintf("%x", rand.Intn(1<<20))] = i
+	}
+	base, _, _ := New().Insert([]byte("zzz"), -1)
+
+	one := base
+	for k, v := range m {
+		one, _, _ = one.Insert([]byte(k), v)
+	}
+	all := base.InsertAll(m)
+	if err := all.Verify(); err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	if all.Len() != one.Len() || all.Len() != len(m)+1 {
+		t.Fatalf("bad: %d %d", all.Len(), one.Len())
+	}
+	Diff(one, all, func(op DiffOp, k []byte, oldVal, newVal interface{}) bool {
+		t.Fatalf("unexpected difference: %v %q", op, k)
+		return true
+	})
+	if base.Len() != 1 {
+		t.Fatalf("base changed")
+	}
+}
+
+func TestTxnInsertChanged(t *testing.T) {
+	r := New()
+	r, _, _ = r.Insert([]byte("foo"), 1)
+	r, _, _ = r.Insert([]byte("bar"), []int{1})
+
+	// Writing the same values leaves the tree as it was
+	txn := r.Txn()
+	if old, changed := txn.InsertChanged([]byte("foo"), 1, nil); changed || old != 1 {
+		t.Fatalf("bad: %v %v", old, changed)
+	}
+	eq := func(a, b interface{}) bool { return reflect.DeepEqual(a, b) }
+	if _, changed := txn.InsertChanged([]byte("bar"), []int{1}, eq); changed {
+		t.Fatalf("unexpected change")
+	}
+	if r2, mutated := txn.Commit(); mutated || r2.Root() != r.Root() {
+		t.Fatalf("expected no change")
+	}
+
+	// New keys and values do change it
+	txn = r.Txn()
+	if old, changed := txn.InsertChanged([]byte("foo"), 2, nil); !changed || old != 1 {
+		t.Fatalf("bad: %v %v", old, changed)
+	}
+	if old, changed := txn.InsertChanged([]byte("zip"), 3, nil); !changed || old != nil {
+		t.Fatalf("bad: %v %v", old, changed)
+	}
+	r2, stats := txn.CommitStats()
+	if v, _ := r2.Get([]byte("foo")); v != 2 {
+		t.Fatalf("bad: %v", v)
+	}
+	if r2.Len() != 3 || stats.LeavesInserted != 1 || stats.LeavesUpdated != 1 {
+		t.Fatalf("bad: %d %+v", r2.Len(), stats)
+	}
+}
+
+func TestIteratorPeek(t *testing.T) {
+	r := New()
+	for _, k := range []string{"a", "b", "foo", "foo/bar", "zip"} {
+		r, _, _ = r.Insert([]byte(k), k)
+	}
+	peek := func(it *Iterator) string {
+		k, v, ok := it.Peek()
+		if !ok {
+			return "<none>"
+		}
+		if v != string(k) {
+			t.Fatalf("bad: %q %v", k, v)
+		}
+		return string(k)
+	}
+	next := func(it *Iterator) string {
+		k, _, ok := it.Next()
+		if !ok {
+			return "<none>"
+		}
+		return string(k)
+	}
+
+	// Peeking doesn't advance the iterator
+	it := r.Root().Iterator()
+	for _, want := range []string{"a", "b", "foo", "foo/bar", "zip", "<none>"} {
+		if k := peek(it); k != want {
+			t.Fatalf("bad: %s", k)
+		}
+		if k := peek(it); k != want {
+			t.Fatalf("bad: %s", k)
+		}
+		if k := next(it); k != want {
+			t.Fatalf("bad: %s", k)
+		}
+	}
+
+	// Peeking works after seeks and changes of direction
+	it = r.Root().Iterator()
+	it.SeekPrefix([]byte("foo"))
+	if k := peek(it); k != "foo" {
+		t.Fatalf("bad: %s", k)
+	}
+	it.SeekLowerBound([]byte("c"))
+	if k := peek(it); k != "foo" {
+		t.Fatalf("bad: %s", k)
+	}
+	if k, _, _ := it.Prev(); string(k) != "b" {
+		t.Fatalf("bad: %s", k)
+	}
+	if k := peek(it); k != "b" {
+		t.Fatalf("bad: %s", k)
+	}
+	if k := next(it); k != "b" {
+		t.Fatalf("bad: %s", k)
+	}
+
+	// Skipping keys already behind the peeked one keeps it
+	if k := peek(it); k != "foo" {
+		t.Fatalf("bad: %s", k)
+	}
+	it.SkipPrefix([]byte("a"))
+	if k := next(it); k != "foo" {
+		t.Fatalf("bad: %s", k)
+	}
+	if k := peek(it); k != "foo/bar" {
+		t.Fatalf("bad: %s", k)
+	}
+	it.SkipPrefix([]byte("foo/"))
+	if k := next(it); k != "zip" {
+		t.Fatalf("bad: %s", k)
+	}
+
+	// Peeking respects the limit without using it up
+	it = r.Root().Iterator()
+	it.Limit(1)
+	if k := peek(it); k != "a" {
+		t.Fatalf("bad: %s", k)
+	}
+	if k := next(it); k != "a" {
+		t.Fatalf("bad: %s", k)
+	}
+	if k := peek(it); k != "<none>" {
+		t.Fatalf("bad: %s", k)
+	}
+}
+
+func TestTxnGetWithChildren(t *testing.T) {
+	r := New()
+	for _, k := range []string{"foo", "foo/bar", "foobar", "zip"} {
+		r, _, _ = r.Insert([]byte(k), k)
+	}
+	txn := r.Txn()
+	txn.Insert([]byte("zip/zap"), "zip/zap")
+	txn.Delete([]byte("foobar"))
+
+	cases := []struct {
+		key         string
+		val         interface{}
+		exists      bool
+		hasChildren bool
+	}{
+		{"", nil, false, true},
+		{"f", nil, false, true},
+		{"foo", "foo", true, true},
+		{"foo/", nil, false, true},
+		{"foo/bar", "foo/bar", true, false},
+		{"foo/bar/baz", nil, false, false},
+		{"foobar", nil, false, false},
+		{"zip", "zip", true, true},
+		{"zip/zap", "zip/zap", true, false},
+		{"nope", nil, false, false},
+	}
+	for _, c := range cases {
+		val, exists, hasChildren := txn.GetWithChildren([]byte(c.key))
+		if val != c.val || exists != c.exists || hasChildren != c.hasChildren {
+			t.Fatalf("bad %q: %v %v %v", c.key, val, exists, hasChildren)
+		}
+	}
+}
+
+func TestNewFixedLen(t *testing.T) {
+	r := NewFixedLen(4)
+	for _, k := range []string{"abcd", "abce", "abzz", "zzzz"} {
+		r, _, _ = r.Insert([]byte(k), k)
+	}
+	if _, _, err := r.Txn().InsertChecked([]byte("abc"), nil); err == nil {
+		t.Fatalf("expected an error")
+	}
+	func() {
+		defer func() {
+			if recover() == nil {
+				t.Fatalf("expected a panic")
+			}
+		}()
+		r.Insert([]byte("abcde"), nil)
+	}()
+
+	txn := r.Txn()
+	txn.Delete([]byte("zzzz"))
+	for _, k := range []string{"abcd", "abce", "abzz", "zzzz", "abcf", "abzy", "axzz", "ab", "", "abcdd"} {
+		val, ok := r.Get([]byte(k))
+		want, wantOK := r.Root().Get([]byte(k))
+		if val != want || ok != wantOK {
+			t.Fatalf("bad %q: %v %v", k, val, ok)
+		}
+
+		val, ok = txn.Get([]byte(k))
+		want, wantOK = txn.Root().Get([]byte(k))
+		if val != want || ok != wantOK {
+			t.Fatalf("bad txn %q: %v %v", k, val, ok)
+		}
+	}
+	if _, ok := txn.Get([]byte("zzzz")); ok {
+		t.Fatalf("expected a miss")
+	}
+}
+
+func TestNewFixedLenSubtree(t *testing.T) {
+	r := NewFixedLen(4)
+	for _, k := range []string{"ab01", "ab02", "cd01"} {
+		r, _, _ = r.Insert([]byte(k), k)
+	}
+
+	// Stripping shortens the key length along with the keys
+	sub := r.Subtree([]byte("ab"), true)
+	if v, ok := sub.Get([]byte("01")); !ok || v != "ab01" {
+		t.Fatalf("bad: %v %v", v, ok)
+	}
+	sub, _, _ = sub.Insert([]byte("03"), "ab03")
+	if _, _, err := sub.Txn().InsertChecked([]byte("ab04"), nil); err == nil {
+		t.Fatalf("expected error")
+	}
+	if v, ok := sub.Get([]byte("03")); !ok || v != "ab03" || sub.Len() != 3 {
+		t.Fatalf("bad: %v %v", v, ok)
+	}
+	if err := sub.Verify(); err != nil {
+		t.Fatalf("err: %v", err)
+	}
+
+	// Without stripping, the key length is kept
+	if v, ok := r.Subtree([]byte("ab"), false).Get([]byte("ab02")); !ok || v != "ab02" {
+		t.Fatalf("bad: %v %v", v, ok)
+	}
+	if _, ok := r.Subtree([]byte("ab01"), true).Get(nil); !ok {
+		t.Fatalf("expected the empty key")
+	}
+	if r.Subtree([]byte("xy"), true).Len() != 0 {
+		t.Fatalf("expected an empty tree")
+	}
+}
+
+func BenchmarkGetFixedLen(b *testing.B) {
+	keys := make([][]byte, 100000)
+	for i := range keys {
+		k, err := uuid.GenerateRandomBytes(16)
+		if err != nil {
+			b.Fatalf("err: %v", err)
+		}
+		keys[i] = k
+	}
+
+	for _, fixed := range []bool{false, true} {
+		r := New()
+		if fixed {
+			r = NewFixedLen(16)
+		}
+		txn := r.Txn()
+		for _, k := range keys {
+			txn.Insert(k, nil)
+		}
+		r, _ = txn.Commit()
+
+		b.Run(fmt.Sprintf("fixed=%v", fixed), func(b *testing.B) {
+			for n := 0; n < b.N; n++ {
+				if _, ok := r.Get(keys[n%len(keys)]); !ok {
+					b.Fatalf("missing key")
+				}
+			}
+		})
+	}
+}
+
+func TestKeysOnly(t *testing.T) {
+	r := New()
+	keys := []string{"bar/a", "bar/b", "foo", "foo/a", "foo/b"}
+	for i, k := range keys {
+		v := interface{}(i)
+		if bytes.HasPrefix([]byte(k), []byte("bar/")) {
+			v = nil
+		}
+		r, _, _ = r.Insert([]byte(k), v)
+	}
+
+	keysOnly := r.KeysOnly()
+	if keysOnly.Len() != len(keys) {
+		t.Fatalf("bad: %d", keysOnly.Len())
+	}
+	for i, k := range keys {
+		if v, ok := keysOnly.Get([]byte(k)); !ok || v != nil {
+			t.Fatalf("bad: %s %v %v", k, v, ok)
+		}
+		if v, _ := r.Get([]byte(k)); i >= 2 && v != i {
+			t.Fatalf("original modified: %s %v", k, v)
+		}
+	}
+	if err := keysOnly.Verify(); err != nil {
+		t.Fatalf("err: %v", err)
+	}
+
+	// Only subtrees that already held nil values are shared, though the
+	// keys are shared throughout
+	_, orig := r.Root().getEdge('b')
+	_, copied := keysOnly.Root().getEdge('b')
+	if orig != copied {
+		t.Fatalf("subtree was copied")
+	}
+	_, orig = r.Root().getEdge('f')
+	_, copied = keysOnly.Root().getEdge('f')
+	if orig == copied || &orig.leaf.key[0] != &copied.leaf.key[0] {
+		t.Fatalf("bad subtree")
+	}
+}
+
+func TestTreeDeletePrefixExtract(t *testing.T) {
+	r := New()
+	keys := []string{"", "cache/a", "cache/b", "cache/c/d", "cachet", "other"}
+	for i, k := range keys {
+		r, _, _ = r.Insert([]byte(k), i)
+	}
+
+	cases := []struct {
+		prefix  string
+		removed []Entry
+		want    []string
+	}{
+		{"cache/", []Entry{{[]byte("cache/a"), 1}, {[]byte("cache/b"), 2}, {[]byte("cache/c/d"), 3}}, []string{"", "cachet", "other"}},
+		{"cachet", []Entry{{[]byte("cachet"), 4}}, []string{"", "cache/a", "cache/b", "cache/c/d", "other"}},
+		{"nope", nil, keys},
+	}
+	for _, c := range cases {
+		nr, removed := r.DeletePrefixExtract([]byte(c.prefix))
+		if !reflect.DeepEqual(removed, c.removed) {
+			t.Fatalf("bad removed: %q %v", c.prefix, removed)
+		}
+		if err := nr.Verify(); err != nil {
+			t.Fatalf("err: %q %v", c.prefix, err)
+		}
+		out := []string{}
+		nr.Root().Walk(func(k []byte, _ interface{}) bool {
+			out = append(out, string(k))
+			return false
+		})
+		if !reflect.DeepEqual(out, c.want) || nr.Len() != len(c.want) {
+			t.Fatalf("bad: %q %v", c.prefix, out)
+		}
+	}
+}
+
+func TestTxnIteratorModified(t *testing.T) {
+	r := New()
+	for _, k := range []string{"a", "b", "c"} {
+		r, _, _ = r.Insert([]byte(k), nil)
+	}
+
+	expectPanic := func(name string, fn func()) {
+		t.Helper()
+		defer func() {
+			if r := recover(); r != "transaction was modified during iteration" {
+				t.Fatalf("bad %s: %v", name, r)
+			}
+		}()
+		fn()
+	}
+
+	txn := r.Txn()
+	txn.Insert([]byte("d"), nil)
+	it := txn.Iterator()
+	rit := txn.ReverseIterator()
+	if k, _, ok := it.Next(); !ok || string(k) != "a" {
+		t.Fatalf("bad: %s", k)
+	}
+	if k, _, ok := rit.Previous(); !ok || string(k) != "d" {
+		t.Fatalf("bad: %s", k)
+	}
+
+	// Reads and no-op writes leave the iterators usable
+	txn.Get([]byte("a"))
+	txn.Delete([]byte("nope"))
+	txn.InsertIfAbsent([]byte("a"), nil)
+	if k, _, ok := it.Peek(); !ok || string(k) != "b" {
+		t.Fatalf("bad: %s", k)
+	}
+
+	txn.Insert([]byte("e"), nil)
+	expectPanic("Next", func() { it.Next() })
+	expectPanic("Peek", func() { it.Peek() })
+	expectPanic("Prev", func() { it.Prev() })
+	expectPanic("Previous", func() { rit.Previous() })
+
+	// Rolling back counts as a modification too
+	sp := txn.Savepoint()
+	it = txn.Iterator()
+	txn.RollbackTo(sp)
+	expectPanic("Next", func() { it.Next() })
+
+	// Iterators from the root aren't checked, and see the tree as it was
+	it = txn.Root().Iterator()
+	txn.Delete([]byte("a"))
+	if k, _, ok := it.Next(); !ok || string(k) != "a" {
+		t.Fatalf("bad: %s", k)
+	}
+}
+
+func TestFirstLast(t *testing.T) {
+	r := New()
+	if _, _, ok := r.First(); ok {
+		t.Fatalf("expected no first key")
+	}
+	if _, _, ok := r.Last(); ok {
+		t.Fatalf("expected no last key")
+	}
+
+	for _, k := range []string{"foo", "foo/bar", "bar", "zip"} {
+		r, _, _ = r.Insert([]byte(k), k)
+	}
+	if k, v, ok := r.First(); !ok || string(k) != "bar" || v != "bar" {
+		t.Fatalf("bad: %s %v", k, v)
+	}
+	if k, v, ok := r.Last(); !ok || string(k) != "zip" || v != "zip" {
+		t.Fatalf("bad: %s %v", k, v)
+	}
+
+	txn := r.Txn()
+	txn.Insert([]byte("a"), "a")
+	txn.Delete([]byte("zip"))
+	if k, _, ok := txn.First(); !ok || string(k) != "a" {
+		t.Fatalf("bad: %s", k)
+	}
+	if k, _, ok := txn.Last(); !ok || string(k) != "foo/bar" {
+		t.Fatalf("bad: %s", k)
+	}
+}
+
+func TestTxnInsertFunc(t *testing.T) {
+	r := New()
+	r, _, _ = r.Insert([]byte("foo"), 1)
+
+	calls := 0
+	makeVal := func() interface{} {
+		calls++
+		return calls * 10
+	}
+
+	txn := r.Txn()
+	for _, k := range []string{"foo", "foobar", "fo", ""} {
+		root := txn.Root()
+		existing, ok := txn.InsertFunc([]byte(k), makeVal)
+		if k == "foo" {
+			if ok || existing != 1 || txn.Root() != root {
+				t.Fatalf("inserted: %q", k)
+			}
+		} else if !ok || existing != nil {
+			t.Fatalf("not inserted: %q", k)
+		}
+	}
+	if calls != 3 {
+		t.Fatalf("bad: %d", calls)
+	}
+	if existing, ok := txn.InsertFunc([]byte("fo"), makeVal); ok || existing != 20 || calls != 3 {
+		t.Fatalf("bad: %v %v %d", existing, ok, calls)
+	}
+
+	r, stats := txn.CommitStats()
+	if stats.LeavesInserted != 3 || stats.LeavesUpdated != 0 || r.Len() != 4 {
+		t.Fatalf("bad: %#v %d", stats, r.Len())
+	}
+	if v, _ := r.Get([]byte("")); v != 30 {
+		t.Fatalf("bad: %v", v)
+	}
+}
+
+func TestMigrate(t *testing.T) {
+	r := New()
+	keys := []string{"bar/a", "bar/b", "foo", "foo/a", "foo/b", "zip"}
+	for i, k := range keys {
+		r, _, _ = r.Insert([]byte(k), i)
+	}
+
+	// Turn the values under bar/ into strings, and drop zip
+	m := r.Migrate(func(k []byte, v interface{}) (interface{}, bool) {
+		switch {
+		case bytes.HasPrefix(k, []byte("bar/")):
+			return fmt.Sprint(v), true
+		case string(k) == "zip":
+			return nil, false
+		}
+		return v, true
+	})
+	if err := m.Verify(); err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	if m.Len() != 5 {
+		t.Fatalf("bad: %d", m.Len())
+	}
+	want := []interface{}{"0", "1", 2, 3, 4}
+	for i, k := range keys[:5] {
+		if v, ok := m.Get([]byte(k)); !ok || v != want[i] {
+			t.Fatalf("bad: %s %v %v", k, v, ok)
+		}
+	}
+	if _, ok := m.Get([]byte("zip")); ok {
+		t.Fatalf("expected zip to be dropped")
+	}
+	if v, _ := r.Get([]byte("bar/a")); v != 0 {
+		t.Fatalf("original modified: %v", v)
+	}
+
+	// Untouched subtrees are shared
+	_, orig := r.Root().getEdge('f')
+	_, migrated := m.Root().getEdge('f')
+	if orig != migrated {
+		t.Fatalf("subtree was copied")
+	}
+}
+
+// collected reports whether the finalizer closing the given channel runs
+// within a few garbage collections
+func collected(done chan struct{}) bool {
+	for i := 0; i < 10; i++ {
+		runtime.GC()
+		select {
+		case <-done:
+			return true
+		case <-time.After(10 * time.Millisecond):
+		}
+	}
+	return false
+}
+
+func TestTxnForgetOldRoot(t *testing.T) {
+	type payload struct {
+		data [1024]byte
+	}
+	for _, forget := range []bool{false, true} {
+		done := make(chan struct{})
+		val := &payload{}
+		runtime.SetFinalizer(val, func(*payload) { close(done) })
+
+		r := New()
+		r, _, _ = r.Insert([]byte("foo"), val)
+		r, _, _ = r.Insert([]byte("bar"), 1)
+		val = nil
+
+		// A long-lived transaction deletes the value and commits, and
+		// only the committed tree is kept
+		txn := r.Txn()
+		txn.ForgetOldRoot(forget)
+		txn.Savepoint()
+		txn.Delete([]byte("foo"))
+		r, changed := txn.Commit()
+		if !changed {
+			t.Fatalf("expected a change")
+		}
+
+		if got := collected(done); got != forget {
+			t.Fatalf("forget=%v: collected=%v", forget, got)
+		}
+
+		// Later commits compare against the last one when forgetting
+		if _, changed := txn.Commit(); changed != !forget {
+			t.Fatalf("forget=%v: changed=%v", forget, changed)
+		}
+		if forget && len(txn.NetChanges()) != 0 {
+			t.Fatalf("bad: %v", txn.NetChanges())
+		}
+		runtime.KeepAlive(txn)
+		runtime.KeepAlive(r)
+	}
+}
+
+func TestTxnRenamePrefix(t *testing.T) {
+	r := New()
+	for _, k := range []string{"new/b", "new/x", "old", "old/a", "old/b", "old/c/d", "other"} {
+		r, _, _ = r.Insert([]byte(k), k)
+	}
+
+	type entry struct {
+		key string
+		val interface{}
+	}
+	cases := []struct {
+		oldPrefix, newPrefix string
+		count                int
+		want                 []entry
+	}{
+		{"old/", "new/", 3, []entry{
+			{"new/a", "old/a"}, {"new/b", "old/b"}, {"new/c/d", "old/c/d"},
+			{"new/x", "new/x"}, {"old", "old"}, {"other", "other"},
+		}},
+		{"old", "o", 4, []entry{
+			{"new/b", "new/b"}, {"new/x", "new/x"}, {"o", "old"}, {"o/a", "old/a"},
+			{"o/b", "old/b"}, {"o/c/d", "old/c/d"}, {"other", "other"},
+		}},
+		{"old/", "old/sub/", 3, []entry{
+			{"new/b", "new/b"}, {"new/x", "new/x"}, {"old", "old"}, {"old/sub/a", "old/a"},
+			{"old/sub/b", "old/b"}, {"old/sub/c/d", "old/c/d"}, {"other", "other"},
+		}},
+		{"nope/", "new/", 0, []entry{
+			{"new/b", "new/b"}, {"new/x", "new/x"}, {"old", "old"}, {"old/a", "old/a"},
+			{"old/b", "old/b"}, {"old/c/d", "old/c/d"}, {"other", "other"},
+		}},
+	}
+	for _, c := range cases {
+		txn := r.Txn()
+		if count := txn.RenamePrefix([]byte(c.oldPrefix), []byte(c.newPrefix)); count != c.count {
+			t.Fatalf("bad count %q: %d", c.oldPrefix, count)
+		}
+		nr, _ := txn.Commit()
+		if err := nr.Verify(); err != nil {
+			t.Fatalf("err: %v", err)
+		}
+		out := []entry{}
+		nr.Root().Walk(func(k []byte, v interface{}) bool {
+			out = append(out, entry{string(k), v})
+			return false
+		})
+		if !reflect.DeepEqual(out, c.want) {
+			t.Fatalf("bad %q -> %q: %v", c.oldPrefix, c.newPrefix, out)
+		}
+	}
+}
+
+func TestTxnRenamePrefixChecked(t *testing.T) {
+	r := NewWithKeyValidator(func(k []byte) error {
+		if len(k) > 5 {
+			return fmt.Errorf("key %q is too long", k)
+		}
+		return nil
+	})
+	for _, k := range []string{"a/1", "a/22", "a/333"} {
+		r, _, _ = r.Insert([]byte(k), k)
+	}
+
+	// A rejected key leaves every key in place
+	txn := r.Txn()
+	if count, err := txn.RenamePrefixChecked([]byte("a/"), []byte("bb/")); err == nil || count != 0 {
+		t.Fatalf("expected error: %d", count)
+	}
+	func() {
+		defer func() {
+			if recover() == nil {
+				t.Fatalf("expected panic")
+			}
+		}()
+		txn.RenamePrefix([]byte("a/"), []byte("bb/"))
+	}()
+	if nr, _ := txn.Commit(); nr.Root() != r.Root() || nr.Len() != 3 {
+		t.Fatalf("tree changed")
+	}
+
+	txn = r.Txn()
+	if count, err := txn.RenamePrefixChecked([]byte("a/"), []byte("b/")); err != nil || count != 3 {
+		t.Fatalf("bad: %d %v", count, err)
+	}
+	nr, _ := txn.Commit()
+	if v, ok := nr.Get([]byte("b/333")); !ok || v != "a/333" || nr.Len() != 3 {
+		t.Fatalf("bad: %v %v", v, ok)
+	}
+}
@@ -0,0 +1,227 @@
+// Package iradixtest provides a model-based testing harness for iradix trees
+// and the layers built on them. A sequence of operations is applied to both a
+// target and a plain map, and the two are checked to agree after every step,
+// including their ordered iteration and lower bounds.
+package iradixtest
+
+import (
+	"bytes"
+	"fmt"
+	"reflect"
+	"sort"
+	"testing"
+
+	iradix "github.com/caravan/go-immutable-radix"
+)
+
+// OpKind is the kind of an operation applied by Compare
+type OpKind int
+
+const (
+	// OpInsert inserts the op's key with its value
+	OpInsert OpKind = iota
+
+	// OpDelete deletes the op's key
+	OpDelete
+)
+
+// Op is a single operation applied by Compare
+type Op struct {
+	Kind OpKind
+	Key  []byte
+	Val  interface{}
+}
+
+// String returns a readable form of the operation, for failure messages
+func (o Op) String() string {
+	if o.Kind == OpDelete {
+		return fmt.Sprintf("Delete(%q)", o.Key)
+	}
+	return fmt.Sprintf("Insert(%q, %v)", o.Key, o.Val)
+}
+
+// Target is the ordered key/value store under test. Insert and Delete
+// return the previous value and whether there was one, Walk visits every
+// entry in key order, and LowerBound returns the smallest key that is
+// greater than or equal to the given key.
+type Target interface {
+	Insert(k []byte, v interface{}) (interface{}, bool)
+	Delete(k []byte) (interface{}, bool)
+	Get(k []byte) (interface{}, bool)
+	Len() int
+	Walk(fn iradix.WalkFn)
+	LowerBound(k []byte) ([]byte, interface{}, bool)
+}
+
+// Verifier can be implemented by a Target to have its internal structure
+// checked after every operation, such as with Tree.Verify
+type Verifier interface {
+	Verify() error
+}
+
+// Check is used to apply the operations to the target, failing the test at
+// the first point where it disagrees with the model
+func Check(t testing.TB, target Target, ops []Op) {
+	t.Helper()
+	if err := Compare(target, ops); err != nil {
+		t.Fatal(err)
+	}
+}
+
+// Compare is used to apply the operations to both the target and a map, in
+// order, returning an error describing the first disagreement, if any.
+// After each operation the return values, the length, and the lookup and
+// lower bound of the op's key are compared, and the target is verified if
+// it's a Verifier. Once all are applied, the full ordered walks are
+// compared. The model orders keys bytewise and compares them exactly, so it
+// only suits trees without a key mapping, unlike those created by
+// NewWithFold or NewWithComparator.
+func Compare(target Target, ops []Op) error {
+	m := model{}
+	for i, op := range ops {
+		var gotVal, wantVal interface{}
+		var gotOK, wantOK bool
+		switch op.Kind {
+		case OpInsert:
+			gotVal, gotOK = target.Insert(op.Key, op.Val)
+			wantVal, wantOK = m.insert(op.Key, op.Val)
+		case OpDelete:
+			gotVal, gotOK = target.Delete(op.Key)
+			wantVal, wantOK = m.delete(op.Key)
+		default:
+			return fmt.Errorf("op %d: unknown kind %d", i, op.Kind)
+		}
+		if gotOK != wantOK || !reflect.DeepEqual(gotVal, wantVal) {
+			return fmt.Errorf("op %d %v: returned %v %v, want %v %v",
+				i, op, gotVal, gotOK, wantVal, wantOK)
+		}
+		if err := compareStep(target, m, op.Key); err != nil {
+			return fmt.Errorf("op %d %v: %v", i, op, err)
+		}
+	}
+	return compareWalk(target, m)
+}
+
+// compareStep checks the target against the model around the given key
+func compareStep(target Target, m model, k []byte) error {
+	if got, want := target.Len(), len(m); got != want {
+		return fmt.Errorf("length is %d, want %d", got, want)
+	}
+
+	gotVal, gotOK := target.Get(k)
+	wantVal, wantOK := m[string(k)]
+	if gotOK != wantOK || !reflect.DeepEqual(gotVal, wantVal) {
+		return fmt.Errorf("Get(%q) is %v %v, want %v %v", k, gotVal, gotOK, wantVal, wantOK)
+	}
+
+	// Check the lower bound of the key and of a key just past it, so that
+	// both an exact match and the following key are covered
+	for _, b := range [][]byte{k, append(k[:len(k):len(k)], 0)} {
+		gotKey, gotVal, gotOK := target.LowerBound(b)
+		wantKey, wantVal, wantOK := m.lowerBound(b)
+		if gotOK != wantOK || !bytes.Equal(gotKey, wantKey) || !reflect.DeepEqual(gotVal, wantVal) {
+			return fmt.Errorf("LowerBound(%q) is %q %v %v, want %q %v %v",
+				b, gotKey, gotVal, gotOK, wantKey, wantVal, wantOK)
+		}
+	}
+
+	if v, ok := target.(Verifier); ok {
+		if err := v.Verify(); err != nil {
+			return fmt.Errorf("invalid: %v", err)
+		}
+	}
+	return nil
+}
+
+// compareWalk checks that the target walks the same entries as the model,
+// in key order
+func compareWalk(target Target, m model) error {
+	var got []string
+	target.Walk(func(k []byte, v interface{}) bool {
+		got = append(got, fmt.Sprintf("%q=%v", k, v))
+		return false
+	})
+	want := make([]string, 0, len(m))
+	for _, k := range m.keys() {
+		want = append(want, fmt.Sprintf("%q=%v", k, m[k]))
+	}
+	if len(got) != len(want) {
+		return fmt.Errorf("walk visited %d entries, want %d", len(got), len(want))
+	}
+	for i := range got {
+		if got[i] != want[i] {
+			return fmt.Errorf("walk entry %d is %s, want %s", i, got[i], want[i])
+		}
+	}
+	return nil
+}
+
+// DecodeOps is used to turn arbitrary bytes, such as from a fuzzer, into a
+// sequence of operations. Keys are drawn from a small alphabet and are kept
+// short, so that they often collide and are prefixes of one another, which
+// exercises the splitting and merging of nodes. Each inserted value is the
+// index of its op.
+func DecodeOps(data []byte) []Op {
+	var ops []Op
+	for len(data) >= 2 {
+		kind := OpInsert
+		if data[0]&1 == 1 {
+			kind = OpDelete
+		}
+		n := int(data[0]>>1) % 6
+		data = data[1:]
+		if n > len(data) {
+			n = len(data)
+		}
+		key := make([]byte, n)
+		for i := range key {
+			key[i] = 'a' + data[i]%4
+		}
+		data = data[n:]
+
+		op := Op{Kind: kind, Key: key}
+		if kind == OpInsert {
+			op.Val = len(ops)
+		}
+		ops = append(ops, op)
+	}
+	return ops
+}
+
+// model is the reference the target is compared against
+type model map[string]interface{}
+
+func (m model) insert(k []byte, v interface{}) (interface{}, bool) {
+	old, ok := m[string(k)]
+	m[string(k)] = v
+	return old, ok
+}
+
+func (m model) delete(k []byte) (interface{}, bool) {
+	old, ok := m[string(k)]
+	delete(m, string(k))
+	return old, ok
+}
+
+func (m model) keys() []string {
+	keys := make([]string, 0, len(m))
+	for k := range m {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+func (m model) lowerBound(k []byte) ([]byte, interface{}, bool) {
+	var best string
+	found := false
+	for key := range m {
+		if key >= string(k) && (!found || key < best) {
+			best, found = key, true
+		}
+	}
+	if !found {
+		return nil, nil, false
+	}
+	return []byte(best), m[best], true
+}
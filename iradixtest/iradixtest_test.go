@@ -0,0 +1,61 @@
+package iradixtest
+
+import (
+	"math/rand"
+	"strings"
+	"testing"
+
+	iradix "github.com/caravan/go-immutable-radix"
+)
+
+func TestCheckTree(t *testing.T) {
+	rnd := rand.New(rand.NewSource(1))
+	for i := 0; i < 200; i++ {
+		data := make([]byte, rnd.Intn(400))
+		rnd.Read(data)
+		Check(t, NewTreeTarget(iradix.New()), DecodeOps(data))
+	}
+}
+
+func TestDecodeOps(t *testing.T) {
+	ops := DecodeOps([]byte{4, 0, 1, 5, 1, 0xff})
+	if len(ops) != 2 {
+		t.Fatalf("bad: %v", ops)
+	}
+	if ops[0].Kind != OpInsert || string(ops[0].Key) != "ab" || ops[0].Val != 0 {
+		t.Fatalf("bad: %v", ops[0])
+	}
+	if ops[1].Kind != OpDelete || string(ops[1].Key) != "bd" || ops[1].Val != nil {
+		t.Fatalf("bad: %v", ops[1])
+	}
+}
+
+// forgetful is a broken target that ignores deletes of keys under "b"
+type forgetful struct {
+	*TreeTarget
+}
+
+func (f forgetful) Delete(k []byte) (interface{}, bool) {
+	if strings.HasPrefix(string(k), "b") {
+		v, ok := f.Get(k)
+		return v, ok
+	}
+	return f.TreeTarget.Delete(k)
+}
+
+func TestCompareCatchesBugs(t *testing.T) {
+	ops := []Op{
+		{Kind: OpInsert, Key: []byte("a"), Val: 1},
+		{Kind: OpInsert, Key: []byte("b"), Val: 2},
+		{Kind: OpDelete, Key: []byte("a")},
+		{Kind: OpDelete, Key: []byte("b")},
+	}
+	if err := Compare(NewTreeTarget(iradix.New()), ops); err != nil {
+		t.Fatalf("err: %v", err)
+	}
+
+	err := Compare(forgetful{NewTreeTarget(iradix.New())}, ops)
+	if err == nil || !strings.HasPrefix(err.Error(), "op 3 ") {
+		t.Fatalf("bad: %v", err)
+	}
+}
@@ -0,0 +1,65 @@
+package iradixtest
+
+import (
+	iradix "github.com/caravan/go-immutable-radix"
+)
+
+// TreeTarget adapts a Tree to the Target interface, replacing its tree with
+// the result of each write
+type TreeTarget struct {
+	tree *iradix.Tree
+}
+
+// NewTreeTarget returns a TreeTarget starting from the given tree
+func NewTreeTarget(tree *iradix.Tree) *TreeTarget {
+	return &TreeTarget{tree: tree}
+}
+
+// Insert is used to add or update a given key
+func (t *TreeTarget) Insert(k []byte, v interface{}) (interface{}, bool) {
+	var old interface{}
+	var ok bool
+	t.tree, old, ok = t.tree.Insert(k, v)
+	return old, ok
+}
+
+// Delete is used to delete a given key
+func (t *TreeTarget) Delete(k []byte) (interface{}, bool) {
+	var old interface{}
+	var ok bool
+	t.tree, old, ok = t.tree.Delete(k)
+	return old, ok
+}
+
+// Get is used to lookup a specific key
+func (t *TreeTarget) Get(k []byte) (interface{}, bool) {
+	return t.tree.Get(k)
+}
+
+// Len returns the number of keys in the tree
+func (t *TreeTarget) Len() int {
+	return t.tree.Len()
+}
+
+// Walk is used to walk every entry of the tree in key order
+func (t *TreeTarget) Walk(fn iradix.WalkFn) {
+	t.tree.Root().Walk(fn)
+}
+
+// LowerBound returns the smallest key in the tree that is greater than or
+// equal to the given key
+func (t *TreeTarget) LowerBound(k []byte) ([]byte, interface{}, bool) {
+	it := t.tree.Iterator()
+	it.SeekLowerBound(k)
+	return it.Next()
+}
+
+// Verify checks the structure of the tree, as with Tree.Verify
+func (t *TreeTarget) Verify() error {
+	return t.tree.Verify()
+}
+
+// Tree returns the current tree
+func (t *TreeTarget) Tree() *iradix.Tree {
+	return t.tree
+}
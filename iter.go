@@ -7,13 +7,90 @@ import "bytes"
 type Iterator struct {
 	node  *Node
 	stack []edges
+
+	// spare holds the storage of a wiped stack for reuse, and first holds
+	// the edge to the node the stack starts from, so that iterating doesn't
+	// have to allocate once the iterator has been used
+	spare []edges
+	first [1]edge
+
+	// root is the node the iterator was created at, which it seeks from
+	// when repositioning itself to change direction. Keys found after
+	// repositioning are bounded to the prefix given to SeekPrefix.
+	root    *Node
+	prefix  []byte
+	bounded bool
+
+	// opts are the options of the tree the iterator was obtained from, if
+	// any, which are applied to the keys given to seeks
+	opts *options
+
+	// rev is used to step backwards after a call to Prev, until the next
+	// call to Next
+	rev *ReverseIterator
+
+	// peeked is set when Peek has fetched the result of the next call to
+	// Next, which is held in peekKey, peekVal and peekOK
+	peeked  bool
+	peekKey []byte
+	peekVal interface{}
+	peekOK  bool
+
+	// cursor is the path of the key the iterator is positioned beside, if
+	// hasCursor is set. The position is just after the cursor if after is set, and just
+	// before it otherwise.
+	cursor    []byte
+	hasCursor bool
+	after     bool
+
+	// atEnd is set when the iterator has been positioned after every key,
+	// where there is no cursor to seek from
+	atEnd bool
+
+	// remaining is the number of results left to return, if limited is set
+	remaining int
+	limited   bool
+
+	// txn is the transaction the iterator was obtained from, if any, when
+	// it had made the given number of mutations
+	txn       *Txn
+	mutations uint64
+}
+
+// Limit restricts the iterator to returning at most n more results, after
+// which Next and Prev return false. Seeking doesn't affect the limit, so it
+// can be set either before or after seeking. A negative n removes the limit.
+func (i *Iterator) Limit(n int) {
+	i.remaining, i.limited = n, n >= 0
+}
+
+// Reset is used to point the iterator at a new node, as though it had been
+// returned by the node's Iterator method. All of its state is cleared,
+// including any seek and limit, but storage is kept for reuse, so that
+// iterating with a reset iterator needn't allocate.
+func (i *Iterator) Reset(n *Node) {
+	i.wipeStack()
+	*i = Iterator{node: n, root: n, spare: i.spare}
+}
+
+// wipeStack empties the stack, keeping its storage for reuse
+func (i *Iterator) wipeStack() {
+	if i.stack != nil {
+		i.spare = i.stack[:0]
+	}
+	i.stack = nil
 }
 
-// SeekPrefix is used to seek the iterator to a given prefix
+// SeekPrefix is used to seek the iterator to a given prefix. Like the other
+// seeks, it seeks from the node the iterator was created at, replacing any
+// earlier seek.
 func (i *Iterator) SeekPrefix(prefix []byte) {
 	// Wipe the stack
-	i.stack = nil
-	n := i.node
+	i.wipeStack()
+	i.resetCursor()
+	prefix = i.opts.path(prefix)
+	i.prefix = prefix
+	n := i.root
 	search := prefix
 	for {
 		// Check for key exhaustion
@@ -48,10 +125,13 @@ func (i *Iterator) recurseMin(n *Node) *Node {
 	if n.leaf != nil {
 		return n
 	}
-	if len(n.edges) > 0 {
+	nEdges := len(n.edges)
+	if nEdges > 1 {
 		// Add all the other edges to the stack (the min node will be added as
 		// we recurse)
 		i.stack = append(i.stack, n.edges[1:])
+	}
+	if nEdges > 0 {
 		return i.recurseMin(n.edges[0].node)
 	}
 	// Shouldn't be possible
@@ -63,18 +143,43 @@ func (i *Iterator) recurseMin(n *Node) *Node {
 // predict based on the radix structure which node(s) changes might affect the
 // result.
 func (i *Iterator) SeekLowerBound(key []byte) {
+	key = i.opts.path(key)
+	i.node, i.prefix = i.root, nil
+	i.seekLowerBound(key)
+	i.resetCursor()
+	i.cursor, i.hasCursor = key, true
+}
+
+// seekLowerBound does the work of SeekLowerBound without positioning the
+// cursor
+func (i *Iterator) seekLowerBound(key []byte) {
 	// Wipe the stack. Unlike Prefix iteration, we need to build the stack as we
 	// go because we need only a subset of edges of many nodes in the path to the
-	// leaf with the lower bound.
-	i.stack = []edges{}
+	// leaf with the lower bound. Note that the iterator will still recurse into
+	// children that we don't traverse on the way to the lower bound as it walks
+	// the stack.
+	i.wipeStack()
+	i.stack = i.spare[:0]
+	// i.node starts off pointing to the node to seek from. By
+	// the time we return we have either found a lower bound and set up the
+	// stack to traverse all larger keys, or we have not and the stack holds
+	// whatever larger keys remain. Either way the node needs to end up as nil
+	// so that Next doesn't assume it is iterating the whole subtree.
 	n := i.node
+	i.node = nil
 	search := key
 
 	found := func(n *Node) {
-		i.node = n
 		i.stack = append(i.stack, edges{edge{node: n}})
 	}
 
+	findMin := func(n *Node) {
+		n = i.recurseMin(n)
+		if n != nil {
+			found(n)
+		}
+	}
+
 	for {
 		// Compare current prefix with the search key's same-length prefix.
 		var prefixCmp int
@@ -88,42 +193,33 @@ func (i *Iterator) SeekLowerBound(key []byte) {
 			// Prefix is larger, that means the lower bound is greater than the search
 			// and from now on we need to follow the minimum path to the smallest
 			// leaf under this subtree.
-			n = i.recurseMin(n)
-			if n != nil {
-				found(n)
-			}
+			findMin(n)
 			return
 		}
 
 		if prefixCmp < 0 {
 			// Prefix is smaller than search prefix, that means there is no lower
 			// bound
-			i.node = nil
 			return
 		}
 
-		// Prefix is equal, we are still heading for an exact match. If this is a
-		// leaf we're done.
-		if n.leaf != nil {
-			if bytes.Compare(n.leaf.key, key) < 0 {
-				i.node = nil
-				return
-			}
-			found(n)
-			return
-		}
+		// Prefix is equal, we are still heading for an exact match. Consume the
+		// search prefix. This is safe because if the prefix were longer than the
+		// search, prefixCmp would have been > 0 above.
+		search = search[len(n.prefix):]
 
-		// Consume the search prefix
-		if len(n.prefix) > len(search) {
-			search = []byte{}
-		} else {
-			search = search[len(n.prefix):]
+		if len(search) == 0 {
+			// We've exhausted the search key, so this node's leaf, if any, is an
+			// exact match and is the lower bound. Otherwise all its children are
+			// greater, so the smallest key in this subtree is the lower bound.
+			findMin(n)
+			return
 		}
 
-		// Otherwise, take the lower bound next edge.
+		// Otherwise, take the lower bound next edge. Any leaf here is a
+		// proper prefix of the search key, and so is smaller than it.
 		idx, lbNode := n.getLowerBoundEdge(search[0])
 		if lbNode == nil {
-			i.node = nil
 			return
 		}
 
@@ -132,21 +228,197 @@ func (i *Iterator) SeekLowerBound(key []byte) {
 			i.stack = append(i.stack, n.edges[idx+1:])
 		}
 
-		i.node = lbNode
 		// Recurse
 		n = lbNode
 	}
 }
 
+// checkTxn panics if the iterator was obtained from a transaction that has
+// since been modified
+func (i *Iterator) checkTxn() {
+	if i.txn != nil && i.txn.mutations != i.mutations {
+		panic("transaction was modified during iteration")
+	}
+}
+
 // Next returns the next node in order
 func (i *Iterator) Next() ([]byte, interface{}, bool) {
+	i.checkTxn()
+	if i.limited && i.remaining == 0 {
+		return nil, nil, false
+	}
+
+	k, v, ok := i.peekKey, i.peekVal, i.peekOK
+	if !i.peeked {
+		k, v, ok = i.fetch()
+	}
+	i.peeked = false
+	if !ok {
+		return nil, nil, false
+	}
+	i.cursor, i.hasCursor, i.after = i.opts.path(k), true, true
+	i.remaining--
+	return k, v, true
+}
+
+// Peek returns what the next call to Next will, without advancing the
+// iterator, such as to decide which of several iterators to advance
+func (i *Iterator) Peek() ([]byte, interface{}, bool) {
+	i.checkTxn()
+	if i.limited && i.remaining == 0 {
+		return nil, nil, false
+	}
+	if !i.peeked {
+		i.peekKey, i.peekVal, i.peekOK = i.fetch()
+		i.peeked = true
+	}
+	return i.peekKey, i.peekVal, i.peekOK
+}
+
+// fetch does the work of Next, without moving the cursor
+func (i *Iterator) fetch() ([]byte, interface{}, bool) {
+	// Reposition to step forwards if we've been stepping backwards
+	if i.rev != nil {
+		i.seekForward()
+	}
+
+	if i.atEnd {
+		return nil, nil, false
+	}
+
+	k, v, ok := i.next()
+	if !ok {
+		return nil, nil, false
+	}
+	if i.bounded && !bytes.HasPrefix(i.opts.path(k), i.prefix) {
+		return nil, nil, false
+	}
+	return k, v, true
+}
+
+// Prev returns the previous node in order, stepping the iterator backwards
+// from its current position. The position is between keys, as with a text
+// cursor, so Prev right after Next returns that same key again, as does
+// Next right after Prev. After a seek, Prev returns the keys before the
+// sought position, but stays within any prefix given to SeekPrefix.
+// Changing direction repositions the iterator by seeking from the node it
+// was created at, so it costs about as much as a seek.
+func (i *Iterator) Prev() ([]byte, interface{}, bool) {
+	i.checkTxn()
+	if i.limited && i.remaining == 0 {
+		return nil, nil, false
+	}
+
+	skip := false
+	if i.rev == nil {
+		// There's nothing before an iterator that hasn't moved
+		if !i.hasCursor && !i.atEnd {
+			return nil, nil, false
+		}
+		skip = i.seekBackward()
+	}
+
+	k, v, ok := i.rev.Previous()
+	if !ok {
+		return nil, nil, false
+	}
+	p := i.opts.path(k)
+	if skip && bytes.Equal(p, i.cursor) {
+		if k, v, ok = i.rev.Previous(); !ok {
+			return nil, nil, false
+		}
+		p = i.opts.path(k)
+	}
+	if !bytes.HasPrefix(p, i.prefix) {
+		return nil, nil, false
+	}
+	i.cursor, i.hasCursor, i.after = p, true, false
+	i.atEnd = false
+	i.remaining--
+	return k, v, true
+}
+
+// SkipPrefix advances the iterator past all the keys under the given prefix,
+// so that Next returns the first key after them, without visiting them. This
+// does nothing if the iterator is already past them.
+func (i *Iterator) SkipPrefix(prefix []byte) {
+	if i.atEnd {
+		return
+	}
+	end := prefixEnd(i.opts.path(prefix))
+	if end == nil {
+		// Every key after the prefix is under it
+		i.rev = nil
+		i.peeked = false
+		i.node, i.stack = nil, []edges{}
+		i.atEnd = true
+		return
+	}
+	if i.hasCursor {
+		cmp := bytes.Compare(i.cursor, end)
+		if cmp > 0 || cmp == 0 && i.after {
+			return
+		}
+	}
+	i.cursor, i.hasCursor, i.after = end, true, false
+	i.seekForward()
+}
+
+// seekForward repositions the iterator from its root, so that the stack
+// holds the keys after the cursor
+func (i *Iterator) seekForward() {
+	i.rev = nil
+	i.peeked = false
+	if i.atEnd {
+		i.node, i.stack = nil, []edges{}
+		return
+	}
+	target := i.cursor
+	if i.after {
+		// Appending a zero byte gives the very next possible key
+		target = append(target[:len(target):len(target)], 0)
+	}
+	if bytes.Compare(target, i.prefix) < 0 {
+		target = i.prefix
+	}
+	i.node = i.root
+	i.seekLowerBound(target)
+	i.bounded = true
+}
+
+// seekBackward repositions the iterator from its root, so that the reverse
+// iterator holds the keys before the cursor. The cursor may be moved to
+// just past the end of the prefix bounds, and the return reports whether
+// the key under the cursor must be skipped.
+func (i *Iterator) seekBackward() bool {
+	i.peeked = false
+	i.rev = NewReverseIterator(i.root)
+	if i.atEnd {
+		// Seek to the end of the prefix bounds, if there is one
+		if end := prefixEnd(i.prefix); end != nil {
+			i.cursor = end
+			i.rev.SeekReverseLowerBound(end)
+			return true
+		}
+		return false
+	}
+
+	skip := !i.after
+	if !bytes.HasPrefix(i.cursor, i.prefix) && bytes.Compare(i.cursor, i.prefix) > 0 {
+		if end := prefixEnd(i.prefix); end != nil {
+			i.cursor, skip = end, true
+		}
+	}
+	i.rev.SeekReverseLowerBound(i.cursor)
+	return skip
+}
+
+// next returns the next node in pre-order from the stack
+func (i *Iterator) next() ([]byte, interface{}, bool) {
 	// Initialize our stack if needed
 	if i.stack == nil && i.node != nil {
-		i.stack = []edges{
-			{
-				edge{node: i.node},
-			},
-		}
+		i.first[0] = edge{node: i.node}
+		i.stack = append(i.spare[:0], i.first[:])
 	}
 
 	for len(i.stack) > 0 {
@@ -174,3 +446,26 @@ func (i *Iterator) Next() ([]byte, interface{}, bool) {
 	}
 	return nil, nil, false
 }
+
+// resetCursor forgets the position of the iterator, as is done when seeking
+func (i *Iterator) resetCursor() {
+	i.rev = nil
+	i.peeked = false
+	i.bounded = false
+	i.cursor, i.hasCursor, i.after = nil, false, false
+	i.atEnd = false
+}
+
+// prefixEnd returns the smallest key that sorts after every key with the
+// given prefix, or nil if there is no such key
+func prefixEnd(prefix []byte) []byte {
+	for i := len(prefix) - 1; i >= 0; i-- {
+		if prefix[i] != 0xff {
+			end := make([]byte, i+1)
+			copy(end, prefix)
+			end[i]++
+			return end
+		}
+	}
+	return nil
+}
@@ -0,0 +1,51 @@
+package iradix
+
+// TrackChanges sets whether the transaction records a journal of the
+// changes its writes make, to be returned by Changes, such as to replay
+// them onto a replica. It's off by default, so that writes don't pay for
+// it. Turning it on starts an empty journal, and turning it off discards
+// the journal.
+func (t *Txn) TrackChanges(track bool) {
+	t.checkActive()
+	t.trackChanges = track
+	t.changes = nil
+}
+
+// Changes returns the journal of changes recorded since TrackChanges was
+// turned on, in the order they were made. Every write that changed the
+// tree is included, not just the net change to each key, so a key inserted
+// and then deleted appears twice, and replaying the journal in order gives
+// the same tree. Writes that left the tree unchanged, such as deletes of
+// missing keys, aren't recorded. The journal is kept across commits, and
+// is cut back by RollbackTo. Use NetChanges for just the net changes.
+func (t *Txn) Changes() []Change {
+	t.checkActive()
+	return append([]Change(nil), t.changes...)
+}
+
+// recordWrite records an insert or update of a key, given its previous
+// value and whether it had one, looking up the value now stored, which
+// may differ from the one written if the tree interns values
+func (t *Txn) recordWrite(k []byte, oldVal interface{}, existed bool) {
+	newVal, _ := t.root.Get(t.opts.path(k))
+	if existed {
+		t.changes = append(t.changes, Change{Op: DiffChanged, Key: k, OldVal: oldVal, NewVal: newVal})
+		return
+	}
+	t.changes = append(t.changes, Change{Op: DiffAdded, Key: k, NewVal: newVal})
+}
+
+// recordDelete records the deletion of a key, given its value
+func (t *Txn) recordDelete(k []byte, oldVal interface{}) {
+	t.changes = append(t.changes, Change{Op: DiffRemoved, Key: k, OldVal: oldVal})
+}
+
+// recordSwap records the exchange of the values of two keys, as a change
+// to each
+func (t *Txn) recordSwap(k1, k2 []byte) {
+	key1, v1, _ := t.root.GetFull(t.opts.path(k1))
+	key2, v2, _ := t.root.GetFull(t.opts.path(k2))
+	t.changes = append(t.changes,
+		Change{Op: DiffChanged, Key: key1, OldVal: v2, NewVal: v1},
+		Change{Op: DiffChanged, Key: key2, OldVal: v1, NewVal: v2})
+}
@@ -0,0 +1,100 @@
+package iradix
+
+import (
+	"reflect"
+	"testing"
+)
+
+func journalEntries(txn *Txn) []diffEntry {
+	out := []diffEntry{}
+	for _, c := range txn.Changes() {
+		out = append(out, diffEntry{c.Op, string(c.Key), c.OldVal, c.NewVal})
+	}
+	return out
+}
+
+func TestTxnChanges(t *testing.T) {
+	r := New()
+	r, _, _ = r.Insert([]byte("a"), 1)
+	r, _, _ = r.Insert([]byte("b"), 2)
+	r, _, _ = r.Insert([]byte("c/1"), 3)
+	r, _, _ = r.Insert([]byte("c/2"), 4)
+
+	txn := r.Txn()
+	txn.Insert([]byte("x"), 0)
+	if got := txn.Changes(); len(got) != 0 {
+		t.Fatalf("recorded changes while not tracking: %v", got)
+	}
+
+	txn.TrackChanges(true)
+	txn.Insert([]byte("z"), 9)
+	txn.Insert([]byte("a"), 10)
+	txn.Delete([]byte("z"))
+	txn.Delete([]byte("missing"))
+	txn.InsertChanged([]byte("b"), 2, nil)
+	txn.Swap([]byte("a"), []byte("b"))
+	txn.DeleteWhereUnder([]byte("c/"), func(k []byte, v interface{}) bool {
+		return v.(int) == 3
+	})
+
+	expect := []diffEntry{
+		{DiffAdded, "z", nil, 9},
+		{DiffChanged, "a", 1, 10},
+		{DiffRemoved, "z", 9, nil},
+		{DiffChanged, "a", 10, 2},
+		{DiffChanged, "b", 2, 10},
+		{DiffRemoved, "c/1", 3, nil},
+	}
+	if got := journalEntries(txn); !reflect.DeepEqual(got, expect) {
+		t.Fatalf("bad journal\ngot:    %v\nexpect: %v", got, expect)
+	}
+
+	// Replaying the journal onto the original tree gives the same tree,
+	// apart from the write made before tracking was turned on.
+	replay := r.Txn()
+	replay.Insert([]byte("x"), 0)
+	for _, c := range txn.Changes() {
+		if c.Op == DiffRemoved {
+			replay.Delete(c.Key)
+		} else {
+			replay.Insert(c.Key, c.NewVal)
+		}
+	}
+	got, _ := replay.Commit()
+	want, _ := txn.Commit()
+	if d := collectDiff(want, got); len(d) != 0 {
+		t.Fatalf("replayed journal gave a different tree: %v", d)
+	}
+
+	// The journal is kept across commits.
+	if got := journalEntries(txn); !reflect.DeepEqual(got, expect) {
+		t.Fatalf("journal changed by commit: %v", got)
+	}
+
+	txn.TrackChanges(false)
+	txn.Insert([]byte("y"), 1)
+	if got := txn.Changes(); len(got) != 0 {
+		t.Fatalf("recorded changes after tracking was turned off: %v", got)
+	}
+}
+
+func TestTxnChangesRollback(t *testing.T) {
+	txn := New().Txn()
+	txn.TrackChanges(true)
+	txn.Insert([]byte("a"), 1)
+	sp := txn.Savepoint()
+	txn.Insert([]byte("b"), 2)
+	txn.Delete([]byte("a"))
+	txn.RollbackTo(sp)
+
+	expect := []diffEntry{{DiffAdded, "a", nil, 1}}
+	if got := journalEntries(txn); !reflect.DeepEqual(got, expect) {
+		t.Fatalf("bad journal after rollback\ngot:    %v\nexpect: %v", got, expect)
+	}
+
+	txn.Insert([]byte("c"), 3)
+	expect = append(expect, diffEntry{DiffAdded, "c", nil, 3})
+	if got := journalEntries(txn); !reflect.DeepEqual(got, expect) {
+		t.Fatalf("bad journal\ngot:    %v\nexpect: %v", got, expect)
+	}
+}
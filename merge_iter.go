@@ -0,0 +1,58 @@
+package iradix
+
+import "bytes"
+
+// MergeIterator is used to iterate over the keys of two trees together, in
+// order, as in a merge join
+type MergeIterator struct {
+	a, b *Iterator
+
+	// The next entry of each iterator, if the iterator has one
+	aKey, bKey []byte
+	aVal, bVal interface{}
+	aOK, bOK   bool
+
+	// started is set once the first entries have been read
+	started bool
+}
+
+// NewMergeIterator returns an iterator over the keys under both a and b. Keys
+// are compared bytewise, so the nodes should come from trees that index
+// keys as they are, rather than by a fold or comparator.
+func NewMergeIterator(a, b *Node) *MergeIterator {
+	return &MergeIterator{a: a.Iterator(), b: b.Iterator()}
+}
+
+// Next returns the next key in order, along with its value in each tree and
+// whether it's in each tree. A key in only one of the trees has a nil value
+// for the other. Returns ok=false once both trees have been exhausted.
+func (m *MergeIterator) Next() (k []byte, aVal, bVal interface{}, inA, inB bool, ok bool) {
+	if !m.started {
+		m.aKey, m.aVal, m.aOK = m.a.Next()
+		m.bKey, m.bVal, m.bOK = m.b.Next()
+		m.started = true
+	}
+
+	switch {
+	case !m.aOK && !m.bOK:
+		return nil, nil, nil, false, false, false
+	case !m.bOK:
+		inA = true
+	case !m.aOK:
+		inB = true
+	default:
+		cmp := bytes.Compare(m.aKey, m.bKey)
+		inA, inB = cmp <= 0, cmp >= 0
+	}
+
+	// Return the entries for the smaller key, and advance past it
+	if inA {
+		k, aVal = m.aKey, m.aVal
+		m.aKey, m.aVal, m.aOK = m.a.Next()
+	}
+	if inB {
+		k, bVal = m.bKey, m.bVal
+		m.bKey, m.bVal, m.bOK = m.b.Next()
+	}
+	return k, aVal, bVal, inA, inB, true
+}
@@ -0,0 +1,81 @@
+package iradix
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestMergeIterator(t *testing.T) {
+	build := func(keys ...string) *Tree {
+		r := New()
+		for _, k := range keys {
+			r, _, _ = r.Insert([]byte(k), k+"!")
+		}
+		return r
+	}
+
+	type entry struct {
+		key        string
+		aVal, bVal interface{}
+		inA, inB   bool
+	}
+	cases := []struct {
+		a, b *Tree
+		want []entry
+	}{
+		{
+			build("", "a", "ab", "c"),
+			build("a", "abc", "b", "c", "d"),
+			[]entry{
+				{"", "!", nil, true, false},
+				{"a", "a!", "a!", true, true},
+				{"ab", "ab!", nil, true, false},
+				{"abc", nil, "abc!", false, true},
+				{"b", nil, "b!", false, true},
+				{"c", "c!", "c!", true, true},
+				{"d", nil, "d!", false, true},
+			},
+		},
+		{
+			build(),
+			build("a", "b"),
+			[]entry{
+				{"a", nil, "a!", false, true},
+				{"b", nil, "b!", false, true},
+			},
+		},
+		{
+			build("a", "b"),
+			build(),
+			[]entry{
+				{"a", "a!", nil, true, false},
+				{"b", "b!", nil, true, false},
+			},
+		},
+		{
+			build(),
+			build(),
+			[]entry{},
+		},
+	}
+
+	for _, c := range cases {
+		m := NewMergeIterator(c.a.Root(), c.b.Root())
+		out := []entry{}
+		for {
+			k, aVal, bVal, inA, inB, ok := m.Next()
+			if !ok {
+				break
+			}
+			out = append(out, entry{string(k), aVal, bVal, inA, inB})
+		}
+		if !reflect.DeepEqual(out, c.want) {
+			t.Fatalf("bad: %v", out)
+		}
+
+		// Exhausted iterators stay exhausted
+		if _, _, _, _, _, ok := m.Next(); ok {
+			t.Fatalf("expected no more keys")
+		}
+	}
+}
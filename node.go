@@ -2,7 +2,11 @@ package iradix
 
 import (
 	"bytes"
+	"context"
+	"math"
+	"math/rand"
 	"sort"
+	"unsafe"
 )
 
 type (
@@ -11,6 +15,20 @@ type (
 	// be terminated.
 	WalkFn func(k []byte, v interface{}) bool
 
+	// WalkErrFn is used when walking the tree with WalkErr. Takes a
+	// key and value, returning an error to terminate iteration
+	// with.
+	WalkErrFn func(k []byte, v interface{}) error
+
+	// ReduceFn is used when reducing the tree with Reduce. Takes the
+	// accumulator and a key and value, returning the new accumulator.
+	ReduceFn func(acc interface{}, k []byte, v interface{}) interface{}
+
+	// WalkSkippableFn is used when walking the tree with WalkSkippable.
+	// Takes a key and value, returning if the keys that extend it should
+	// be skipped, and if iteration should be terminated.
+	WalkSkippableFn func(k []byte, v interface{}) (skipSubtree bool, stop bool)
+
 	// leafNode is used to represent a value
 	leafNode struct {
 		key []byte
@@ -25,6 +43,13 @@ type (
 
 	edges []edge
 
+	// GetResult is the result of looking up one of the keys given to
+	// GetMulti
+	GetResult struct {
+		Val interface{}
+		Ok  bool
+	}
+
 	// Node is an immutable node in the radix tree
 	Node struct {
 		// leaf is used to store possible leaf
@@ -37,6 +62,17 @@ type (
 		// We avoid a fully materialized slice to save memory,
 		// since in most cases we expect to be sparse
 		edges edges
+
+		// size is the number of leaves in the subtree rooted at the node,
+		// including its own
+		size int
+
+		// gen is the generation of the pooling transaction that created
+		// the node, or zero if it wasn't created by one
+		gen uint64
+
+		// hashed caches the *nodeHash of the node, once it's been hashed
+		hashed unsafe.Pointer
 	}
 )
 
@@ -104,14 +140,175 @@ func (n *Node) delEdge(label byte) {
 }
 
 func (n *Node) Get(k []byte) (interface{}, bool) {
+	if leaf, _ := n.getLeaf(k); leaf != nil {
+		return leaf.val, true
+	}
+	return nil, false
+}
+
+// GetWithChildren is like Get, but also returns whether any longer keys
+// extend k, found in the same descent
+func (n *Node) GetWithChildren(k []byte) (interface{}, bool, bool) {
 	search := k
 	curr := n
 	for {
 		// Check for key exhaustion
 		if len(search) == 0 {
-			if curr.isLeaf() {
-				return curr.leaf.val, true
+			hasChildren := len(curr.edges) > 0
+			if curr.leaf != nil {
+				return curr.leaf.val, true, hasChildren
 			}
+			return nil, false, hasChildren
+		}
+
+		// Look for an edge
+		_, curr = curr.getEdge(search[0])
+		if curr == nil {
+			return nil, false, false
+		}
+
+		// Consume the search prefix, or stop if the key ends part way
+		// along the edge, where every key below extends it
+		if bytes.HasPrefix(search, curr.prefix) {
+			search = search[len(curr.prefix):]
+		} else {
+			return nil, false, bytes.HasPrefix(curr.prefix, search)
+		}
+	}
+}
+
+// GetFull is like Get, but also returns the key stored in the leaf, which
+// can differ from k if the tree indexes keys by a fold
+func (n *Node) GetFull(k []byte) ([]byte, interface{}, bool) {
+	if leaf, _ := n.getLeaf(k); leaf != nil {
+		return leaf.key, leaf.val, true
+	}
+	return nil, nil, false
+}
+
+// GetMulti is used to look up a batch of keys, returning the result for
+// each in the same order. Each lookup resumes from the deepest node along
+// the path of the previous key that is shared with its own, rather than
+// descending from n, so keys that are sorted, or otherwise grouped by common
+// prefixes, are looked up with less work.
+func (n *Node) GetMulti(keys [][]byte) []GetResult {
+	type frame struct {
+		node  *Node
+		depth int
+	}
+	res := make([]GetResult, len(keys))
+	stack := []frame{{node: n}}
+	var prev []byte
+	for i, k := range keys {
+		// Return to the deepest node the keys have in common
+		common := longestPrefix(prev, k)
+		for stack[len(stack)-1].depth > common {
+			stack = stack[:len(stack)-1]
+		}
+		prev = k
+
+		top := stack[len(stack)-1]
+		search := k[top.depth:]
+		curr := top.node
+		for {
+			// Check for key exhaustion
+			if len(search) == 0 {
+				if curr.leaf != nil {
+					res[i] = GetResult{Val: curr.leaf.val, Ok: true}
+				}
+				break
+			}
+
+			// Look for an edge
+			_, curr = curr.getEdge(search[0])
+			if curr == nil || !bytes.HasPrefix(search, curr.prefix) {
+				break
+			}
+
+			// Consume the search prefix, remembering the node
+			search = search[len(curr.prefix):]
+			stack = append(stack, frame{node: curr, depth: len(k) - len(search)})
+		}
+	}
+	return res
+}
+
+// GetProfiled is like Get, but also returns the number of edges followed
+// from n during the lookup, whether or not k was found. This is a measure of
+// the cost of the lookup, which shows whether keys make for a deep or a
+// shallow tree.
+func (n *Node) GetProfiled(k []byte) (interface{}, bool, int) {
+	leaf, hops := n.getLeaf(k)
+	if leaf != nil {
+		return leaf.val, true, hops
+	}
+	return nil, false, hops
+}
+
+// getLeaf returns the leaf stored at k, or nil if there isn't one, along
+// with the number of edges followed to look for it
+func (n *Node) getLeaf(k []byte) (*leafNode, int) {
+	search := k
+	curr := n
+	hops := 0
+	for {
+		// Check for key exhaustion
+		if len(search) == 0 {
+			return curr.leaf, hops
+		}
+
+		// Look for an edge
+		_, curr = curr.getEdge(search[0])
+		if curr == nil {
+			return nil, hops
+		}
+		hops++
+
+		// Consume the search prefix
+		if bytes.HasPrefix(search, curr.prefix) {
+			search = search[len(curr.prefix):]
+		} else {
+			return nil, hops
+		}
+	}
+}
+
+// getFixed is like Get, for a tree whose keys all have the given length.
+// Only the first byte of each node's prefix is checked, by its edge, on the
+// way down, since the leaf that's reached is then compared with k in full.
+func (n *Node) getFixed(k []byte, keyLen int) (interface{}, bool) {
+	if len(k) != keyLen {
+		return nil, false
+	}
+	search := k
+	curr := n
+	for len(search) > 0 {
+		_, curr = curr.getEdge(search[0])
+		if curr == nil || len(curr.prefix) > len(search) {
+			return nil, false
+		}
+		search = search[len(curr.prefix):]
+	}
+	if curr.leaf == nil || !bytes.Equal(curr.leaf.key, k) {
+		return nil, false
+	}
+	return curr.leaf.val, true
+}
+
+// LongestPrefix is like Get, but instead of an
+// exact match, it will return the longest prefix match.
+func (n *Node) LongestPrefix(k []byte) ([]byte, interface{}, bool) {
+	var last *leafNode
+	search := k
+	curr := n
+	for {
+		// Look for a leaf node
+		if curr.isLeaf() {
+			last = curr.leaf
+		}
+
+		// Check for key exhaustion
+		if len(search) == 0 {
 			break
 		}
 
@@ -128,7 +325,157 @@ func (n *Node) Get(k []byte) (interface{}, bool) {
 			break
 		}
 	}
-	return nil, false
+	if last != nil {
+		return last.key, last.val, true
+	}
+	return nil, nil, false
+}
+
+// LongestPrefixSep is like LongestPrefix, but only matches stored keys that
+// end at a boundary between segments of k separated by sep. That's where
+// the key is all of k, or is followed in k by a separator, or itself ends
+// with one, so that "foo/bar" matches "foo/bar/baz" but not "foo/barbaz".
+// The empty key is always at a boundary.
+func (n *Node) LongestPrefixSep(k []byte, sep byte) ([]byte, interface{}, bool) {
+	var last *leafNode
+	search := k
+	curr := n
+	for {
+		// Look for a leaf node at a boundary
+		if curr.isLeaf() {
+			i := len(k) - len(search)
+			if i == 0 || i == len(k) || k[i] == sep || k[i-1] == sep {
+				last = curr.leaf
+			}
+		}
+
+		// Check for key exhaustion
+		if len(search) == 0 {
+			break
+		}
+
+		// Look for an edge
+		_, curr = curr.getEdge(search[0])
+		if curr == nil {
+			break
+		}
+
+		// Consume the search prefix
+		if bytes.HasPrefix(search, curr.prefix) {
+			search = search[len(curr.prefix):]
+		} else {
+			break
+		}
+	}
+	if last != nil {
+		return last.key, last.val, true
+	}
+	return nil, nil, false
+}
+
+// ShortestPrefix is like WalkPath, but instead of visiting every key along
+// the path, it returns the first, which is the shortest stored key that is a
+// prefix of k, along with its value
+func (n *Node) ShortestPrefix(k []byte) ([]byte, interface{}, bool) {
+	search := k
+	curr := n
+	for {
+		// Return the first leaf found
+		if curr.leaf != nil {
+			return curr.leaf.key, curr.leaf.val, true
+		}
+
+		// Check for key exhaustion
+		if len(search) == 0 {
+			break
+		}
+
+		// Look for an edge
+		_, curr = curr.getEdge(search[0])
+		if curr == nil {
+			break
+		}
+
+		// Consume the search prefix
+		if bytes.HasPrefix(search, curr.prefix) {
+			search = search[len(curr.prefix):]
+		} else {
+			break
+		}
+	}
+	return nil, nil, false
+}
+
+// GetClosest is used to find how far a key matches along the tree, which is
+// useful for suggestions when a Get fails. It returns the length of the
+// longest prefix of k present along any path in the tree, which may end
+// partway along an edge, along with the minimum key and value under the
+// point where the search diverged. This is found in a single descent, and
+// unlike LongestPrefix, the divergence point is reported even when no
+// stored key is itself a prefix of k. The key is an exact match when
+// matchedLen is len(k) and the nearest key equals k. Returns ok=false only
+// if there are no keys under the divergence point.
+func (n *Node) GetClosest(k []byte) (matchedLen int, nearestKey []byte, nearestVal interface{}, ok bool) {
+	search := k
+	curr := n
+	for len(search) != 0 {
+		// Look for an edge
+		_, child := curr.getEdge(search[0])
+		if child == nil {
+			break
+		}
+
+		// Consume as much of the child prefix as matches
+		common := longestPrefix(search, child.prefix)
+		matchedLen += common
+		curr = child
+		if common < len(child.prefix) {
+			break
+		}
+		search = search[common:]
+	}
+	nearestKey, nearestVal, ok = curr.Minimum()
+	return matchedLen, nearestKey, nearestVal, ok
+}
+
+// Neighbors returns up to before keys that sort before k, nearest first,
+// and up to after keys that sort after it, also nearest first. The key k
+// itself isn't included either way, whether or not it's present.
+func (n *Node) Neighbors(k []byte, before, after int) (prev [][]byte, next [][]byte) {
+	it := n.Iterator()
+	it.SeekLowerBound(k)
+	for len(prev) < before {
+		key, _, ok := it.Prev()
+		if !ok {
+			break
+		}
+		prev = append(prev, key)
+	}
+
+	it.SeekLowerBound(k)
+	for len(next) < after {
+		key, _, ok := it.Next()
+		if !ok {
+			break
+		}
+		if bytes.Equal(key, k) {
+			continue
+		}
+		next = append(next, key)
+	}
+	return prev, next
+}
+
+// CommonPrefix returns the longest prefix shared by every key under n,
+// starting from n's own prefix. It only follows the nodes down to where the
+// keys first branch, or one of them ends.
+func (n *Node) CommonPrefix() []byte {
+	prefix := concat(nil, n.prefix)
+	for n.leaf == nil && len(n.edges) == 1 {
+		n = n.edges[0].node
+		prefix = append(prefix, n.prefix...)
+	}
+	return prefix
 }
 
 // Minimum is used to return the minimum value in the tree
@@ -164,10 +511,26 @@ func (n *Node) Maximum() ([]byte, interface{}, bool) {
 	return nil, nil, false
 }
 
+// MinimumPrefix is used to return the minimum value under a prefix
+func (n *Node) MinimumPrefix(prefix []byte) ([]byte, interface{}, bool) {
+	if sub, _ := n.seekPrefix(prefix); sub != nil {
+		return sub.Minimum()
+	}
+	return nil, nil, false
+}
+
+// MaximumPrefix is used to return the maximum value under a prefix
+func (n *Node) MaximumPrefix(prefix []byte) ([]byte, interface{}, bool) {
+	if sub, _ := n.seekPrefix(prefix); sub != nil {
+		return sub.Maximum()
+	}
+	return nil, nil, false
+}
+
 // Iterator is used to return an iterator at
 // the given node to walk the tree
 func (n *Node) Iterator() *Iterator {
-	return &Iterator{node: n}
+	return &Iterator{node: n, root: n}
 }
 
 // ReverseIterator is used to return an iterator at
@@ -178,12 +541,256 @@ func (n *Node) ReverseIterator() *ReverseIterator {
 
 // Walk is used to walk the tree
 func (n *Node) Walk(fn WalkFn) {
-	recursiveWalk(n, fn)
+	n.WalkDir(false, fn)
+}
+
+// WalkDir is used to walk the tree in either direction, walking backwards
+// if reverse is set
+func (n *Node) WalkDir(reverse bool, fn WalkFn) {
+	if reverse {
+		reverseRecursiveWalk(n, fn)
+	} else {
+		recursiveWalk(n, fn)
+	}
+}
+
+// WalkErr is used to walk the tree, stopping at the first error returned by
+// the callback, which is returned
+func (n *Node) WalkErr(fn WalkErrFn) error {
+	var err error
+	recursiveWalk(n, func(k []byte, v interface{}) bool {
+		err = fn(k, v)
+		return err != nil
+	})
+	return err
+}
+
+// walkCtxInterval is the number of keys WalkCtx visits between checks of
+// its context, which is often enough to stop promptly, without the checks
+// costing much compared to the callbacks
+const walkCtxInterval = 256
+
+// WalkCtx is used to walk the tree like Walk, but stops early if the context
+// is done, returning its error. The context is checked before the walk and
+// then every walkCtxInterval keys, so the callback may be called for a few
+// more keys after the context is done. Returns nil if the walk finishes or
+// the callback stops it.
+func (n *Node) WalkCtx(ctx context.Context, fn WalkFn) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	var err error
+	count := 0
+	recursiveWalk(n, func(k []byte, v interface{}) bool {
+		if fn(k, v) {
+			return true
+		}
+		if count++; count%walkCtxInterval == 0 {
+			err = ctx.Err()
+		}
+		return err != nil
+	})
+	return err
 }
 
 // WalkBackwards is used to walk the tree in reverse order
 func (n *Node) WalkBackwards(fn WalkFn) {
-	reverseRecursiveWalk(n, fn)
+	n.WalkDir(true, fn)
+}
+
+// WalkFrom is like Walk, but skips the keys less than start. Subtrees with
+// only smaller keys aren't visited at all.
+func (n *Node) WalkFrom(start []byte, fn WalkFn) {
+	walkFrom(n, start, fn)
+}
+
+// WalkRange is used to walk the keys from start up to but not including end,
+// in order. A nil end walks to the last key. Subtrees with no keys in the
+// range aren't visited at all, so walking a small range of a large tree is
+// cheap.
+func (n *Node) WalkRange(start, end []byte, fn WalkFn) {
+	rangeWalk(n, nil, start, end, false, fn)
+}
+
+// PresentInRange returns the keys from lo up to but not including hi that
+// are in the tree, in order, such as to find gaps in sequence-numbered keys.
+// A nil hi includes every key from lo onwards. Like WalkRange, only the
+// subtrees that hold keys in the range are visited.
+func (n *Node) PresentInRange(lo, hi []byte) [][]byte {
+	var keys [][]byte
+	rangeWalk(n, nil, lo, hi, false, func(k []byte, _ interface{}) bool {
+		keys = append(keys, k)
+		return false
+	})
+	return keys
+}
+
+// WalkRangeBackwards is like WalkRange, but walks the keys in the range in
+// reverse order, from the last key before end back to start
+func (n *Node) WalkRangeBackwards(start, end []byte, fn WalkFn) {
+	rangeWalk(n, nil, start, end, true, fn)
+}
+
+// GroupByPrefix calls fn with each key under any of the given prefixes,
+// along with its value and the longest of the prefixes it's under. This
+// walks the keys once, in order, even when the prefixes overlap, which is
+// cheaper than walking the keys under each prefix in turn.
+func (n *Node) GroupByPrefix(prefixes [][]byte, fn func(prefix []byte, k []byte, v interface{})) {
+	sorted := make([][]byte, len(prefixes))
+	copy(sorted, prefixes)
+	sort.Slice(sorted, func(i, j int) bool {
+		return bytes.Compare(sorted[i], sorted[j]) < 0
+	})
+
+	// The keys are matched to their longest prefix by a tree of the prefixes
+	txn := New().Txn()
+	for _, p := range sorted {
+		txn.Insert(p, p)
+	}
+	set := txn.Root()
+
+	// Prefixes under another one sort right after it, and needn't be walked
+	var outer []byte
+	for i, p := range sorted {
+		if i > 0 && bytes.HasPrefix(p, outer) {
+			continue
+		}
+		outer = p
+		n.WalkPrefix(p, func(k []byte, v interface{}) bool {
+			_, prefix, _ := set.LongestPrefix(k)
+			fn(prefix.([]byte), k, v)
+			return false
+		})
+	}
+}
+
+// HasPrefix returns whether any key under n starts with the given prefix.
+// It only descends as far as the node for the prefix, so it's cheaper than
+// walking the keys under the prefix.
+func (n *Node) HasPrefix(prefix []byte) bool {
+	curr, _ := n.seekPrefix(prefix)
+
+	// Only the root node can be empty
+	return curr != nil && (curr.leaf != nil || len(curr.edges) != 0)
+}
+
+// Size returns the number of keys in the subtree rooted at n. It's kept up
+// to date as nodes are copied, so it's known without walking the subtree.
+func (n *Node) Size() int {
+	return n.size
+}
+
+// CountPrefix returns the number of keys under n that start with the given
+// prefix. It only descends as far as the node for the prefix, and returns
+// its size, so the cost depends on the length of the prefix rather than on
+// the number of keys.
+func (n *Node) CountPrefix(prefix []byte) int {
+	if curr, _ := n.seekPrefix(prefix); curr != nil {
+		return curr.size
+	}
+	return 0
+}
+
+// Select returns the key of the given rank under n, and its value, where
+// the smallest key has rank zero, such as to find the median key. It
+// descends by the sizes of the subtrees, so it only visits the nodes along
+// the path to the key. Returns false if the rank is out of range.
+func (n *Node) Select(rank int) ([]byte, interface{}, bool) {
+	if rank < 0 || rank >= n.size {
+		return nil, nil, false
+	}
+	curr := n
+	for {
+		if curr.leaf != nil {
+			if rank == 0 {
+				return curr.leaf.key, curr.leaf.val, true
+			}
+			rank--
+		}
+
+		// Descend into the child holding the rank
+		var next *Node
+		for _, e := range curr.edges {
+			if rank < e.node.size {
+				next = e.node
+				break
+			}
+			rank -= e.node.size
+		}
+		if next == nil {
+			return nil, nil, false
+		}
+		curr = next
+	}
+}
+
+// Rank returns the number of keys under n that are strictly less than k,
+// which is the rank k has or would have. Like Select, it only visits the
+// nodes along the path to k, counting the subtrees before it by their size.
+func (n *Node) Rank(k []byte) int {
+	count := 0
+	search := k
+	curr := n
+	for len(search) > 0 {
+		// A key ending here is a prefix of k, so is less than it
+		if curr.leaf != nil {
+			count++
+		}
+
+		var next *Node
+		for _, e := range curr.edges {
+			if e.label < search[0] {
+				count += e.node.size
+				continue
+			}
+			if e.label == search[0] {
+				next = e.node
+			}
+			break
+		}
+		if next == nil {
+			return count
+		}
+
+		// Compare the child's prefix with the search, to see if its keys
+		// are all less, all greater, or need a closer look
+		l := len(next.prefix)
+		if len(search) < l {
+			l = len(search)
+		}
+		switch bytes.Compare(next.prefix[:l], search[:l]) {
+		case -1:
+			return count + next.size
+		case 1:
+			return count
+		}
+		if len(search) < len(next.prefix) {
+			return count
+		}
+		search = search[len(next.prefix):]
+		curr = next
+	}
+	return count
+}
+
+// NextBytes returns the bytes that can follow the given prefix in the keys
+// under n, in sorted order, along with whether the prefix is itself a key.
+// This reflects the compressed structure of the tree, so each byte starts
+// an edge that may hold several more bytes before the keys branch again,
+// and a prefix ending partway along an edge has just the one next byte.
+func (n *Node) NextBytes(prefix []byte) ([]byte, bool) {
+	curr, path := n.seekPrefix(prefix)
+	if curr == nil {
+		return nil, false
+	}
+	if len(path) > len(prefix) {
+		return []byte{path[len(prefix)]}, false
+	}
+	next := make([]byte, len(curr.edges))
+	for i, e := range curr.edges {
+		next[i] = e.label
+	}
+	return next, curr.leaf != nil
 }
 
 // WalkPrefix is used to walk the tree under a prefix
@@ -217,37 +824,395 @@ func (n *Node) WalkPrefix(prefix []byte, fn WalkFn) {
 	}
 }
 
+// WalkValues is like Walk, but only passes fn the values, for scans that
+// don't need the keys
+func (n *Node) WalkValues(fn func(v interface{}) bool) {
+	valuesWalk(n, fn)
+}
+
+// WalkPrefixValues is like WalkPrefix, but only passes fn the values
+func (n *Node) WalkPrefixValues(prefix []byte, fn func(v interface{}) bool) {
+	if curr, _ := n.seekPrefix(prefix); curr != nil {
+		valuesWalk(curr, fn)
+	}
+}
+
+// WalkRangeValues is like WalkRange, but only passes fn the values
+func (n *Node) WalkRangeValues(start, end []byte, fn func(v interface{}) bool) {
+	n.WalkRange(start, end, func(_ []byte, v interface{}) bool {
+		return fn(v)
+	})
+}
+
+// WalkSkippable is like Walk, but fn can also skip the keys that extend the
+// one it's given, which are all in the subtree below it, and continue the
+// walk after them. Skipped subtrees aren't visited at all, which is much
+// faster than visiting the keys and ignoring them.
+func (n *Node) WalkSkippable(fn WalkSkippableFn) {
+	skippableWalk(n, fn)
+}
+
+// SampleWalk is like Walk, but only visits each key with the given
+// probability, as decided by a random source seeded with seed, so the same
+// keys are visited each time for the same tree and seed. Rather than
+// drawing for every key, it draws the number of keys to skip before the next
+// sample, and passes over whole subtrees with fewer keys than that by their
+// size, so sparse samples of large trees visit few nodes.
+func (n *Node) SampleWalk(rate float64, seed int64, fn WalkFn) {
+	// A NaN rate is treated as zero
+	if !(rate > 0) {
+		return
+	}
+	rnd := rand.New(rand.NewSource(seed))
+	gap := func() int {
+		if rate >= 1 {
+			return 0
+		}
+		// The gaps between independent samples are geometric. Log1p keeps
+		// the denominator from rounding to zero for tiny rates.
+		g := math.Floor(math.Log1p(-rnd.Float64()) / math.Log1p(-rate))
+		if g >= float64(n.size) {
+			return n.size
+		}
+		return int(g)
+	}
+	skip := gap()
+
+	var walk func(curr *Node) bool
+	walk = func(curr *Node) bool {
+		if curr.size <= skip {
+			skip -= curr.size
+			return false
+		}
+		if curr.leaf != nil {
+			if skip == 0 {
+				if fn(curr.leaf.key, curr.leaf.val) {
+					return true
+				}
+				skip = gap()
+			} else {
+				skip--
+			}
+		}
+		for _, e := range curr.edges {
+			if walk(e.node) {
+				return true
+			}
+		}
+		return false
+	}
+	walk(n)
+}
+
+// Reduce is used to fold the keys of the tree into an accumulator, in
+// order, such as to sum their values. Starting from acc, each key and value
+// is passed to fn along with the accumulator, and the accumulator that fn
+// returns for the last key is returned.
+func (n *Node) Reduce(acc interface{}, fn ReduceFn) interface{} {
+	recursiveWalk(n, func(k []byte, v interface{}) bool {
+		acc = fn(acc, k, v)
+		return false
+	})
+	return acc
+}
+
+// ReducePrefix is like Reduce, but only folds the keys under a prefix, as
+// found by WalkPrefix
+func (n *Node) ReducePrefix(prefix []byte, acc interface{}, fn ReduceFn) interface{} {
+	n.WalkPrefix(prefix, func(k []byte, v interface{}) bool {
+		acc = fn(acc, k, v)
+		return false
+	})
+	return acc
+}
+
+// ReduceRange is like Reduce, but only folds the keys from start up to but
+// not including end, as found by WalkRange
+func (n *Node) ReduceRange(start, end []byte, acc interface{}, fn ReduceFn) interface{} {
+	n.WalkRange(start, end, func(k []byte, v interface{}) bool {
+		acc = fn(acc, k, v)
+		return false
+	})
+	return acc
+}
+
+// WalkPrefixSuffix is like WalkPrefix, but also passes fn the suffix of each
+// key that follows the prefix, even when the prefix ends partway along an
+// edge
+func (n *Node) WalkPrefixSuffix(prefix []byte, fn func(suffix []byte, fullKey []byte, v interface{}) bool) {
+	curr, path := n.seekPrefix(prefix)
+	if curr == nil {
+		return
+	}
+	suffixWalk(curr, len(path)-len(prefix), fn)
+}
+
+// WalkBitPrefix is like WalkPrefix, but the prefix is the first bits bits of
+// the given bytes, most significant bit first, so it can end partway
+// through a byte, as with the prefixes of addresses in CIDR notation. It
+// panics if bits is negative or more than the length of prefix in bits.
+func (n *Node) WalkBitPrefix(prefix []byte, bits int, fn WalkFn) {
+	if bits < 0 || bits > 8*len(prefix) {
+		panic("bit prefix length out of range")
+	}
+	whole, partial := prefix[:bits/8], bits%8
+	if partial == 0 {
+		n.WalkPrefix(whole, fn)
+		return
+	}
+	mask := byte(0xff) << (8 - partial)
+	want := prefix[len(whole)] & mask
+
+	curr, path := n.seekPrefix(whole)
+	if curr == nil {
+		return
+	}
+
+	// If the whole bytes end partway along an edge, only the next byte on
+	// the edge needs checking
+	if len(path) > len(whole) {
+		if path[len(whole)]&mask == want {
+			recursiveWalk(curr, fn)
+		}
+		return
+	}
+
+	// Otherwise, the edges whose labels match the partial byte are adjacent,
+	// and any leaf is too short to match
+	idx, _ := curr.getLowerBoundEdge(want)
+	if idx == -1 {
+		return
+	}
+	for _, e := range curr.edges[idx:] {
+		if e.label&mask != want || recursiveWalk(e.node, fn) {
+			return
+		}
+	}
+}
+
+// WalkChildren is used to list the immediate children of a prefix, treating
+// keys as paths made of segments separated by sep, much like a directory
+// listing. Keys under the prefix with no further separator are visited as
+// they are. Keys that continue into a deeper segment are visited once per
+// segment, as the segment up to and including sep, along with the value of
+// the key that ends exactly there, if any. Descent stops at the first
+// separator past the prefix, so nested entries are never walked.
+func (n *Node) WalkChildren(prefix []byte, sep byte, fn WalkFn) {
+	curr, path := n.seekPrefix(prefix)
+	if curr == nil {
+		return
+	}
+	childrenWalk(curr, path, len(prefix), sep, fn)
+}
+
+// ChildGroup is an immediate child of a prefix listed by ChildGroups, and
+// the number of keys under it
+type ChildGroup struct {
+	Segment []byte
+	Count   int
+}
+
+// ChildGroups is like WalkChildren, but returns the children of the prefix
+// in key order along with the number of keys under each, such as to list a
+// directory's entries with their sizes. A key with no further separator
+// counts just itself, and a segment counts every key it prefixes, taken
+// from the subtree sizes, so nested entries are still never walked.
+func (n *Node) ChildGroups(prefix []byte, sep byte) []ChildGroup {
+	curr, path := n.seekPrefix(prefix)
+	if curr == nil {
+		return nil
+	}
+	var groups []ChildGroup
+	childGroupsWalk(curr, path, len(prefix), sep, &groups)
+	return groups
+}
+
 // WalkPath is used to walk the tree, but only visiting nodes
 // from the root down to a given leaf. Where WalkPrefix walks
 // all the entries *under* the given prefix, this walks the
 // entries *above* the given prefix.
 func (n *Node) WalkPath(path []byte, fn WalkFn) {
+	n.WalkPathRemainder(path, fn)
+}
+
+// WalkPathRemainder is like WalkPath, but returns the part of the path left
+// unmatched below the deepest node it reached, which is where a lookup of
+// the path fell off the tree. The remainder is empty if the whole path was
+// matched. If fn ends the walk, the remainder is what's left of the path
+// below the leaf fn was given.
+func (n *Node) WalkPathRemainder(path []byte, fn WalkFn) []byte {
 	search := path
 	curr := n
 	for {
 		// Visit the leaf values if any
 		if curr.leaf != nil && fn(curr.leaf.key, curr.leaf.val) {
-			return
+			return search
 		}
 
 		// Check for key exhaustion
 		if len(search) == 0 {
-			return
+			return search
+		}
+
+		// Look for an edge
+		_, next := curr.getEdge(search[0])
+		if next == nil || !bytes.HasPrefix(search, next.prefix) {
+			return search
+		}
+
+		// Consume the search prefix
+		curr = next
+		search = search[len(curr.prefix):]
+	}
+}
+
+// PrefixChain returns every key in the tree that is a prefix of k, along
+// with its value, from the shortest to the longest. This suits layered
+// lookups, such as configuration where a key inherits from each of its
+// ancestors.
+func (n *Node) PrefixChain(k []byte) []Entry {
+	var chain []Entry
+	n.WalkPath(k, func(key []byte, val interface{}) bool {
+		chain = append(chain, Entry{Key: key, Val: val})
+		return false
+	})
+	return chain
+}
+
+// WalkNodes is used to walk every node of the tree in pre-order, rather than
+// just the leaves, exposing the structure of the tree such as for rendering
+// it. The callback is given the full path to each node, its depth in edges
+// from n, and whether it holds a leaf along with the leaf's value. Returning
+// true from the callback ends the walk.
+func (n *Node) WalkNodes(fn func(prefix []byte, depth int, isLeaf bool, val interface{}) bool) {
+	nodesWalk(n, n.prefix, 0, func(node *Node, path []byte, depth int) bool {
+		if node.leaf != nil {
+			return fn(path, depth, true, node.leaf.val)
+		}
+		return fn(path, depth, false, nil)
+	})
+}
+
+// WalkStructure is like WalkNodes, but passes fn each node's own prefix,
+// which is the compressed run of bytes along the edge leading to it, rather
+// than its full path, along with its number of edges. Together with the
+// depth, that's enough to draw the tree as it's laid out, including the
+// nodes without leaves where keys branch.
+func (n *Node) WalkStructure(fn func(prefix []byte, depth int, numEdges int, isLeaf bool, val interface{}) bool) {
+	nodesWalk(n, n.prefix, 0, func(node *Node, _ []byte, depth int) bool {
+		if node.leaf != nil {
+			return fn(node.prefix, depth, len(node.edges), true, node.leaf.val)
+		}
+		return fn(node.prefix, depth, len(node.edges), false, nil)
+	})
+}
+
+// Prefixes is like WalkNodes, but only passes fn the full path to each node
+// and whether it holds a leaf. The paths of the nodes without leaves are
+// where keys branch without any key ending, which shows how the keys are
+// distributed.
+func (n *Node) Prefixes(fn func(prefix []byte, isLeaf bool) bool) {
+	n.WalkNodes(func(prefix []byte, _ int, isLeaf bool, _ interface{}) bool {
+		return fn(prefix, isLeaf)
+	})
+}
+
+// seekPrefix is used to find the root of the subtree holding every key under
+// the given prefix, returning it along with its full path from n. The path
+// runs past the end of the prefix when the prefix ends partway along an edge.
+// Returns a nil node if there are no keys under the prefix.
+func (n *Node) seekPrefix(prefix []byte) (*Node, []byte) {
+	search := prefix
+	curr := n
+	for {
+		// Check for key exhaustion
+		if len(search) == 0 {
+			return curr, prefix
 		}
 
 		// Look for an edge
 		_, curr = curr.getEdge(search[0])
 		if curr == nil {
-			return
+			return nil, nil
 		}
 
 		// Consume the search prefix
 		if bytes.HasPrefix(search, curr.prefix) {
 			search = search[len(curr.prefix):]
+		} else if bytes.HasPrefix(curr.prefix, search) {
+			// Child may be under our search prefix
+			consumed := prefix[:len(prefix)-len(search)]
+			return curr, concat(consumed, curr.prefix)
 		} else {
-			break
+			return nil, nil
+		}
+	}
+}
+
+// childrenWalk is used to walk the immediate children for WalkChildren. The
+// path is the full path to n, and only the bytes of it from offset onward
+// have yet to be checked for the separator. Returns true if the walk should
+// be aborted
+func childrenWalk(n *Node, path []byte, offset int, sep byte, fn WalkFn) bool {
+	// Stop descending at the first separator, visiting the segment once
+	if idx := bytes.IndexByte(path[offset:], sep); idx != -1 {
+		segment := path[:offset+idx+1]
+		if n.leaf != nil && len(segment) == len(path) {
+			return fn(n.leaf.key, n.leaf.val)
+		}
+		return fn(segment, nil)
+	}
+
+	// Visit the leaf values if any
+	if n.leaf != nil && fn(n.leaf.key, n.leaf.val) {
+		return true
+	}
+
+	// Recurse on the children
+	for _, e := range n.edges {
+		childPath := concat(path, e.node.prefix)
+		if childrenWalk(e.node, childPath, len(path), sep, fn) {
+			return true
+		}
+	}
+	return false
+}
+
+// childGroupsWalk is used to collect the groups for ChildGroups, descending
+// in the same way as childrenWalk
+func childGroupsWalk(n *Node, path []byte, offset int, sep byte, groups *[]ChildGroup) {
+	// Every key under n is in the segment ending at the first separator
+	if idx := bytes.IndexByte(path[offset:], sep); idx != -1 {
+		*groups = append(*groups, ChildGroup{Segment: path[:offset+idx+1], Count: n.size})
+		return
+	}
+
+	// A leaf before the separator is a child of its own
+	if n.leaf != nil {
+		*groups = append(*groups, ChildGroup{Segment: n.leaf.key, Count: 1})
+	}
+
+	// Recurse on the children
+	for _, e := range n.edges {
+		childPath := concat(path, e.node.prefix)
+		childGroupsWalk(e.node, childPath, len(path), sep, groups)
+	}
+}
+
+// nodesWalk is used to do a pre-order walk of every node for WalkNodes and
+// WalkStructure, passing fn each node with its full path and depth
+func nodesWalk(n *Node, path []byte, depth int, fn func(*Node, []byte, int) bool) bool {
+	if fn(n, path, depth) {
+		return true
+	}
+
+	// Recurse on the children
+	for _, e := range n.edges {
+		if nodesWalk(e.node, concat(path, e.node.prefix), depth+1, fn) {
+			return true
 		}
 	}
+	return false
 }
 
 // recursiveWalk is used to do a pre-order walk of a node
@@ -267,15 +1232,134 @@ func recursiveWalk(n *Node, fn WalkFn) bool {
 	return false
 }
 
-// reverseRecursiveWalk is used to do a reverse pre-order
-// walk of a node recursively. Returns true if the walk
-// should be aborted
-func reverseRecursiveWalk(n *Node, fn WalkFn) bool {
-	// Visit the leaf values if any
-	if n.leaf != nil && fn(n.leaf.key, n.leaf.val) {
+// skippableWalk is like recursiveWalk, but doesn't recurse on the children
+// of a node whose leaf fn asks to skip. Returns true if the walk should be
+// aborted
+func skippableWalk(n *Node, fn WalkSkippableFn) bool {
+	if n.leaf != nil {
+		skip, stop := fn(n.leaf.key, n.leaf.val)
+		if stop {
+			return true
+		}
+		if skip {
+			return false
+		}
+	}
+
+	for _, e := range n.edges {
+		if skippableWalk(e.node, fn) {
+			return true
+		}
+	}
+	return false
+}
+
+// walkFrom is used to do a pre-order walk of the keys under n that aren't
+// less than the given search key, which is the remainder of the start key
+// from n's prefix on. Returns true if the walk should be aborted
+func walkFrom(n *Node, search []byte, fn WalkFn) bool {
+	l := len(n.prefix)
+	if len(search) < l {
+		l = len(search)
+	}
+	switch cmp := bytes.Compare(n.prefix[:l], search[:l]); {
+	case cmp < 0:
+		// Every key under n is less than the start
+		return false
+	case cmp > 0 || l == len(search):
+		// Every key under n comes after the start, or is equal to it
+		return recursiveWalk(n, fn)
+	}
+
+	// The leaf is a prefix of the start, and so less than it, as are the
+	// children on lower edges
+	search = search[l:]
+	idx, _ := n.getLowerBoundEdge(search[0])
+	if idx == -1 {
+		return false
+	}
+	for _, e := range n.edges[idx:] {
+		if walkFrom(e.node, search, fn) {
+			return true
+		}
+	}
+	return false
+}
+
+// valuesWalk is used to do a pre-order walk of the values under n. Returns
+// true if the walk should be aborted
+func valuesWalk(n *Node, fn func(v interface{}) bool) bool {
+	if n.leaf != nil && fn(n.leaf.val) {
+		return true
+	}
+	for _, e := range n.edges {
+		if valuesWalk(e.node, fn) {
+			return true
+		}
+	}
+	return false
+}
+
+// suffixWalk is used to do a pre-order walk of the subtree at n, passing fn
+// the last depth bytes of the key at n, and correspondingly more of the keys
+// below it. Returns true if the walk should be aborted
+func suffixWalk(n *Node, depth int, fn func(suffix []byte, fullKey []byte, v interface{}) bool) bool {
+	if n.leaf != nil {
+		k := n.leaf.key
+		if fn(k[len(k)-depth:], k, n.leaf.val) {
+			return true
+		}
+	}
+	for _, e := range n.edges {
+		if suffixWalk(e.node, depth+len(e.node.prefix), fn) {
+			return true
+		}
+	}
+	return false
+}
+
+// rangeWalk is used to walk the keys under n from start up to but not
+// including end, or to the last key if end is nil. The path is the path to
+// n's parent. Returns true if the walk should be aborted
+func rangeWalk(n *Node, path, start, end []byte, reverse bool, fn WalkFn) bool {
+	// Every key under n starts with its path
+	path = append(path, n.prefix...)
+	afterStart := bytes.Compare(path, start) >= 0
+	if !afterStart && !bytes.HasPrefix(start, path) {
+		return false
+	}
+	if end != nil && bytes.Compare(path, end) >= 0 {
+		return false
+	}
+
+	// Whole subtrees inside the range are walked without further checks
+	if afterStart && (end == nil || !bytes.HasPrefix(end, path)) {
+		if reverse {
+			return reverseRecursiveWalk(n, fn)
+		}
+		return recursiveWalk(n, fn)
+	}
+
+	// The leaf comes before the children
+	if !reverse && afterStart && n.leaf != nil && fn(n.leaf.key, n.leaf.val) {
 		return true
 	}
+	for i := range n.edges {
+		e := n.edges[i]
+		if reverse {
+			e = n.edges[len(n.edges)-1-i]
+		}
+		if rangeWalk(e.node, path, start, end, reverse, fn) {
+			return true
+		}
+	}
+	return reverse && afterStart && n.leaf != nil && fn(n.leaf.key, n.leaf.val)
+}
 
+// reverseRecursiveWalk is used to do a reverse post-order
+// walk of a node recursively. Returns true if the walk
+// should be aborted
+func reverseRecursiveWalk(n *Node, fn WalkFn) bool {
 	// Recurse on the children in reverse order
 	for i := len(n.edges) - 1; i >= 0; i-- {
 		e := n.edges[i]
@@ -283,5 +1367,7 @@ func reverseRecursiveWalk(n *Node, fn WalkFn) bool {
 			return true
 		}
 	}
-	return false
+
+	// Visit the leaf values if any, which come before any of the children
+	return n.leaf != nil && fn(n.leaf.key, n.leaf.val)
 }
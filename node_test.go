@@ -1,6 +1,17 @@
 package iradix
 
-import "testing"
+import (
+	"context"
+	"errors"
+	"fmt"
+	"math"
+	"math/rand"
+	"reflect"
+	"sort"
+	"strings"
+	"testing"
+	"testing/quick"
+)
 
 func TestNodeWalk(t *testing.T) {
 	r := New()
@@ -51,3 +62,1382 @@ func TestNodeWalkBackwards(t *testing.T) {
 		return false
 	})
 }
+
+func TestNodeWalkChildren(t *testing.T) {
+	r := New()
+	keys := []string{
+		"foo",
+		"foo/",
+		"foo/bar",
+		"foo/bar/baz",
+		"foo/bar/",
+		"foo/baz",
+		"foo/zip/zap",
+		"foo/zip/zoo/zed",
+		"foobar",
+	}
+	for i, k := range keys {
+		r, _, _ = r.Insert([]byte(k), i)
+	}
+
+	type exp struct {
+		inp string
+		out []string
+		val []interface{}
+	}
+	cases := []exp{
+		{
+			"",
+			[]string{"foo", "foo/", "foobar"},
+			[]interface{}{0, 1, 8},
+		},
+		{
+			"foo/",
+			[]string{"foo/", "foo/bar", "foo/bar/", "foo/baz", "foo/zip/"},
+			[]interface{}{1, 2, 4, 5, nil},
+		},
+		{
+			"foo/z",
+			[]string{"foo/zip/"},
+			[]interface{}{nil},
+		},
+		{
+			"foo/zip/",
+			[]string{"foo/zip/zap", "foo/zip/zoo/"},
+			[]interface{}{6, nil},
+		},
+		{
+			"nope",
+			[]string{},
+			[]interface{}{},
+		},
+	}
+
+	for _, test := range cases {
+		out := []string{}
+		val := []interface{}{}
+		r.Root().WalkChildren([]byte(test.inp), '/', func(k []byte, v interface{}) bool {
+			out = append(out, string(k))
+			val = append(val, v)
+			return false
+		})
+		if !reflect.DeepEqual(out, test.out) {
+			t.Fatalf("mis-match: %q %v %v", test.inp, out, test.out)
+		}
+		if !reflect.DeepEqual(val, test.val) {
+			t.Fatalf("value mis-match: %q %v %v", test.inp, val, test.val)
+		}
+	}
+
+	// Aborting the walk stops at the first child
+	var out []string
+	r.Root().WalkChildren([]byte("foo/"), '/', func(k []byte, _ interface{}) bool {
+		out = append(out, string(k))
+		return true
+	})
+	if len(out) != 1 || out[0] != "foo/" {
+		t.Fatalf("bad: %v", out)
+	}
+}
+
+func TestNodeChildGroups(t *testing.T) {
+	r := New()
+	keys := []string{
+		"foo",
+		"foo/",
+		"foo/bar",
+		"foo/bar/baz",
+		"foo/bar/",
+		"foo/baz",
+		"foo/zip/zap",
+		"foo/zip/zoo/zed",
+		"foo/zip/zoo/zee",
+		"foobar",
+	}
+	for i, k := range keys {
+		r, _, _ = r.Insert([]byte(k), i)
+	}
+
+	cases := []struct {
+		inp string
+		out []string
+	}{
+		{"", []string{"foo=1", "foo/=8", "foobar=1"}},
+		{"foo/", []string{"foo/=1", "foo/bar=1", "foo/bar/=2", "foo/baz=1", "foo/zip/=3"}},
+		{"foo/z", []string{"foo/zip/=3"}},
+		{"foo/zip/", []string{"foo/zip/zap=1", "foo/zip/zoo/=2"}},
+		{"nope", nil},
+	}
+	for _, test := range cases {
+		var out []string
+		for _, g := range r.Root().ChildGroups([]byte(test.inp), '/') {
+			out = append(out, fmt.Sprintf("%s=%d", g.Segment, g.Count))
+		}
+		if !reflect.DeepEqual(out, test.out) {
+			t.Fatalf("bad: %q %v", test.inp, out)
+		}
+	}
+
+	// The counts agree with CountPrefix for segments past the prefix
+	for _, g := range r.Root().ChildGroups([]byte("foo/"), '/') {
+		isSegment := len(g.Segment) > len("foo/") && g.Segment[len(g.Segment)-1] == '/'
+		if isSegment && g.Count != r.CountPrefix(g.Segment) {
+			t.Fatalf("bad: %s %d", g.Segment, g.Count)
+		}
+	}
+}
+
+func TestNodeGetClosest(t *testing.T) {
+	r := New()
+	keys := []string{"foo", "foobar", "foobaz", "zip/zap"}
+	for i, k := range keys {
+		r, _, _ = r.Insert([]byte(k), i)
+	}
+
+	cases := []struct {
+		inp     string
+		matched int
+		nearest string
+		val     interface{}
+		ok      bool
+	}{
+		{"foo", 3, "foo", 0, true},
+		{"foobaz", 6, "foobaz", 2, true},
+		{"foobax", 5, "foobar", 1, true},
+		{"foob", 4, "foobar", 1, true},
+		{"fox", 2, "foo", 0, true},
+		{"zip/zoo", 5, "zip/zap", 3, true},
+		{"zap", 1, "zip/zap", 3, true},
+		{"nope", 0, "foo", 0, true},
+		{"", 0, "foo", 0, true},
+	}
+	for _, c := range cases {
+		matched, nearest, val, ok := r.Root().GetClosest([]byte(c.inp))
+		if matched != c.matched || string(nearest) != c.nearest || val != c.val || ok != c.ok {
+			t.Fatalf("bad: %q %d %q %v %v", c.inp, matched, nearest, val, ok)
+		}
+	}
+
+	if matched, _, _, ok := New().Root().GetClosest([]byte("foo")); matched != 0 || ok {
+		t.Fatalf("bad: %d %v", matched, ok)
+	}
+}
+
+func TestNodeShortestPrefix(t *testing.T) {
+	r := New()
+	keys := []string{"foo", "foo/bar", "foo/bar/baz", "zip/zap"}
+	for i, k := range keys {
+		r, _, _ = r.Insert([]byte(k), i)
+	}
+
+	cases := []struct {
+		inp string
+		out string
+		val interface{}
+		ok  bool
+	}{
+		{"foo", "foo", 0, true},
+		{"foo/bar/baz/zoo", "foo", 0, true},
+		{"foo/bar", "foo", 0, true},
+		{"fo", "", nil, false},
+		{"zip/zap/zoo", "zip/zap", 3, true},
+		{"zip/", "", nil, false},
+		{"", "", nil, false},
+	}
+	for _, c := range cases {
+		k, v, ok := r.Root().ShortestPrefix([]byte(c.inp))
+		if string(k) != c.out || v != c.val || ok != c.ok {
+			t.Fatalf("bad: %q %q %v %v", c.inp, k, v, ok)
+		}
+	}
+
+	// An empty key is the shortest prefix of everything
+	r, _, _ = r.Insert([]byte(""), "root")
+	if k, v, ok := r.Root().ShortestPrefix([]byte("zip")); string(k) != "" || v != "root" || !ok {
+		t.Fatalf("bad: %q %v %v", k, v, ok)
+	}
+}
+
+func TestNodeGetFull(t *testing.T) {
+	r := New()
+	for i, k := range []string{"foo", "foobar", ""} {
+		r, _, _ = r.Insert([]byte(k), i)
+	}
+	for _, c := range []struct {
+		inp string
+		val interface{}
+		ok  bool
+	}{
+		{"foo", 0, true},
+		{"foobar", 1, true},
+		{"", 2, true},
+		{"foob", nil, false},
+		{"fooba", nil, false},
+		{"zip", nil, false},
+	} {
+		k, v, ok := r.Root().GetFull([]byte(c.inp))
+		if ok != c.ok || v != c.val || (ok && string(k) != c.inp) {
+			t.Fatalf("bad: %q %q %v %v", c.inp, k, v, ok)
+		}
+	}
+
+	// The stored key is returned from a folded tree
+	f := NewWithFold(func(b byte) byte { return b | 0x20 })
+	f, _, _ = f.Insert([]byte("FooBar"), 1)
+	if k, v, ok := f.GetFull([]byte("foobar")); string(k) != "FooBar" || v != 1 || !ok {
+		t.Fatalf("bad: %q %v %v", k, v, ok)
+	}
+}
+
+func TestNodeWalkNodes(t *testing.T) {
+	r := New()
+	for i, k := range []string{"foo", "foobar", "foobaz", "zip"} {
+		r, _, _ = r.Insert([]byte(k), i)
+	}
+
+	type visit struct {
+		prefix string
+		depth  int
+		isLeaf bool
+		val    interface{}
+	}
+	out := []visit{}
+	r.Root().WalkNodes(func(prefix []byte, depth int, isLeaf bool, val interface{}) bool {
+		out = append(out, visit{string(prefix), depth, isLeaf, val})
+		return false
+	})
+	want := []visit{
+		{"", 0, false, nil},
+		{"foo", 1, true, 0},
+		{"fooba", 2, false, nil},
+		{"foobar", 3, true, 1},
+		{"foobaz", 3, true, 2},
+		{"zip", 1, true, 3},
+	}
+	if !reflect.DeepEqual(out, want) {
+		t.Fatalf("bad: %v", out)
+	}
+
+	// The walk can be stopped
+	out = out[:0]
+	r.Root().WalkNodes(func(prefix []byte, depth int, isLeaf bool, val interface{}) bool {
+		out = append(out, visit{string(prefix), depth, isLeaf, val})
+		return depth == 2
+	})
+	if len(out) != 3 {
+		t.Fatalf("bad: %v", out)
+	}
+}
+
+func TestNodeMinimumMaximumPrefix(t *testing.T) {
+	r := New()
+	keys := []string{"foo", "foo/bar", "foo/baz", "foobar", "zip/zap"}
+	for i, k := range keys {
+		r, _, _ = r.Insert([]byte(k), i)
+	}
+
+	cases := []struct {
+		prefix string
+		min    string
+		max    string
+		ok     bool
+	}{
+		{"", "foo", "zip/zap", true},
+		{"foo", "foo", "foobar", true},
+		{"foo/", "foo/bar", "foo/baz", true},
+		{"foo/b", "foo/bar", "foo/baz", true},
+		{"zi", "zip/zap", "zip/zap", true},
+		{"zip/zap", "zip/zap", "zip/zap", true},
+		{"zip/zapp", "", "", false},
+		{"foox", "", "", false},
+		{"a", "", "", false},
+	}
+	for _, c := range cases {
+		k, _, ok := r.Root().MinimumPrefix([]byte(c.prefix))
+		if string(k) != c.min || ok != c.ok {
+			t.Fatalf("bad minimum: %q %q %v", c.prefix, k, ok)
+		}
+		k, _, ok = r.Root().MaximumPrefix([]byte(c.prefix))
+		if string(k) != c.max || ok != c.ok {
+			t.Fatalf("bad maximum: %q %q %v", c.prefix, k, ok)
+		}
+	}
+}
+
+func TestNodeWalkErr(t *testing.T) {
+	r := New()
+	keys := []string{"001", "002", "005", "010", "100"}
+	for _, k := range keys {
+		r, _, _ = r.Insert([]byte(k), nil)
+	}
+
+	out := []string{}
+	err := r.Root().WalkErr(func(k []byte, _ interface{}) error {
+		out = append(out, string(k))
+		return nil
+	})
+	if err != nil || !reflect.DeepEqual(out, keys) {
+		t.Fatalf("bad: %v %v", out, err)
+	}
+
+	stop := errors.New("stop")
+	out = out[:0]
+	err = r.Root().WalkErr(func(k []byte, _ interface{}) error {
+		out = append(out, string(k))
+		if string(k) == "005" {
+			return stop
+		}
+		return nil
+	})
+	if err != stop || !reflect.DeepEqual(out, keys[:3]) {
+		t.Fatalf("bad: %v %v", out, err)
+	}
+}
+
+func TestNodeGetProfiled(t *testing.T) {
+	r := New()
+	for i, k := range []string{"", "foo", "foobar", "foobaz", "zip"} {
+		r, _, _ = r.Insert([]byte(k), i)
+	}
+
+	cases := []struct {
+		inp  string
+		val  interface{}
+		ok   bool
+		hops int
+	}{
+		{"", 0, true, 0},
+		{"foo", 1, true, 1},
+		{"foobar", 2, true, 3},
+		{"zip", 4, true, 1},
+		{"fooba", nil, false, 2},
+		{"foobax", nil, false, 2},
+		{"fox", nil, false, 1},
+		{"nope", nil, false, 0},
+	}
+	for _, c := range cases {
+		val, ok, hops := r.Root().GetProfiled([]byte(c.inp))
+		if val != c.val || ok != c.ok || hops != c.hops {
+			t.Fatalf("bad: %q %v %v %d", c.inp, val, ok, hops)
+		}
+	}
+}
+
+func TestNodeWalkDir(t *testing.T) {
+	r := New()
+	keys := []string{"", "001", "0010", "002", "010", "100"}
+	for _, k := range keys {
+		r, _, _ = r.Insert([]byte(k), nil)
+	}
+
+	for _, reverse := range []bool{false, true} {
+		out := []string{}
+		r.Root().WalkDir(reverse, func(k []byte, _ interface{}) bool {
+			out = append(out, string(k))
+			return len(out) == 4
+		})
+		want := []string{"", "001", "0010", "002"}
+		if reverse {
+			want = []string{"100", "010", "002", "0010"}
+		}
+		if !reflect.DeepEqual(out, want) {
+			t.Fatalf("bad: %v %v", reverse, out)
+		}
+	}
+}
+
+func TestNodeLongestPrefix(t *testing.T) {
+	r := New()
+	for _, k := range []string{"", "foo", "foobar", "foobarbaz", "foozip"} {
+		r, _, _ = r.Insert([]byte(k), k)
+	}
+
+	cases := []struct {
+		inp string
+		out string
+	}{
+		{"a", ""},
+		{"abc", ""},
+		{"fo", ""},
+		{"foo", "foo"},
+		{"foob", "foo"},
+		{"foobar", "foobar"},
+		{"foobarba", "foobar"},
+		{"foobarbaz", "foobarbaz"},
+		{"foobarbazzip", "foobarbaz"},
+		{"foozi", "foo"},
+		{"foozip", "foozip"},
+		{"foozipzap", "foozip"},
+	}
+	for _, c := range cases {
+		k, v, ok := r.Root().LongestPrefix([]byte(c.inp))
+		if !ok || string(k) != c.out || v != c.out {
+			t.Fatalf("bad: %q %q %v %v", c.inp, k, v, ok)
+		}
+	}
+
+	if _, _, ok := New().Root().LongestPrefix([]byte("foo")); ok {
+		t.Fatalf("unexpected match")
+	}
+}
+
+func TestNodeGetMulti(t *testing.T) {
+	r := New()
+	keys := []string{"", "foo", "foo/bar", "foo/bar/baz", "foo/zip", "foobar", "zip"}
+	for i, k := range keys {
+		r, _, _ = r.Insert([]byte(k), i)
+	}
+
+	queries := []string{
+		"", "f", "foo", "foo/", "foo/bar", "foo/bar/baz", "foo/bar/bazz",
+		"foo/zip", "foobar", "zip", "zipzap",
+	}
+	for _, shuffle := range []bool{false, true} {
+		if shuffle {
+			rand.Shuffle(len(queries), func(i, j int) {
+				queries[i], queries[j] = queries[j], queries[i]
+			})
+		}
+		in := make([][]byte, len(queries))
+		for i, q := range queries {
+			in[i] = []byte(q)
+		}
+		out := r.Root().GetMulti(in)
+		if len(out) != len(queries) {
+			t.Fatalf("bad: %d", len(out))
+		}
+		for i, q := range queries {
+			v, ok := r.Get([]byte(q))
+			if out[i].Val != v || out[i].Ok != ok {
+				t.Fatalf("bad: %q %v", q, out[i])
+			}
+		}
+	}
+
+	if out := r.Root().GetMulti(nil); len(out) != 0 {
+		t.Fatalf("bad: %v", out)
+	}
+}
+
+func TestNodeHasPrefix(t *testing.T) {
+	r := New()
+	for _, k := range []string{"foo", "foobar", "foozip", "zip/zap"} {
+		r, _, _ = r.Insert([]byte(k), nil)
+	}
+
+	cases := []struct {
+		prefix string
+		out    bool
+	}{
+		{"", true},
+		{"f", true},
+		{"foo", true},
+		{"foob", true},
+		{"foobar", true},
+		{"foobarb", false},
+		{"fooz", true},
+		{"fop", false},
+		{"zip/", true},
+		{"zip/zap/", false},
+		{"a", false},
+	}
+	for _, c := range cases {
+		walked := false
+		r.Root().WalkPrefix([]byte(c.prefix), func(k []byte, v interface{}) bool {
+			walked = true
+			return true
+		})
+		if out := r.Root().HasPrefix([]byte(c.prefix)); out != c.out || out != walked {
+			t.Fatalf("bad: %q %v", c.prefix, out)
+		}
+	}
+
+	if New().Root().HasPrefix(nil) {
+		t.Fatalf("unexpected match")
+	}
+}
+
+func TestNodeWalkFrom(t *testing.T) {
+	r := New()
+	keys := []string{"", "a", "ab", "abc", "abd", "b", "ba", "bb", "c"}
+	for _, k := range keys {
+		r, _, _ = r.Insert([]byte(k), nil)
+	}
+
+	for _, start := range []string{"", "a", "aa", "ab", "abb", "abc", "abcd", "ac", "b", "bab", "c", "d"} {
+		var out []string
+		r.Root().WalkFrom([]byte(start), func(k []byte, v interface{}) bool {
+			out = append(out, string(k))
+			return false
+		})
+		var want []string
+		for _, k := range keys {
+			if k >= start {
+				want = append(want, k)
+			}
+		}
+		if !reflect.DeepEqual(out, want) {
+			t.Fatalf("mis-match: start=%q\n  got=%v\n  want=%v", start, out, want)
+		}
+	}
+
+	// The walk can be stopped early
+	var out []string
+	r.Root().WalkFrom([]byte("abd"), func(k []byte, v interface{}) bool {
+		out = append(out, string(k))
+		return len(out) == 2
+	})
+	if want := []string{"abd", "b"}; !reflect.DeepEqual(out, want) {
+		t.Fatalf("bad: %v", out)
+	}
+}
+
+func TestNodeWalkFromFuzz(t *testing.T) {
+	r := New()
+	set := []string{}
+
+	radixAddAndWalk := func(newKey, start readableString) []string {
+		r, _, _ = r.Insert([]byte(newKey), nil)
+		result := []string{}
+		r.Root().WalkFrom([]byte(start), func(k []byte, v interface{}) bool {
+			result = append(result, string(k))
+			return false
+		})
+		return result
+	}
+
+	sliceAddSortAndFilter := func(newKey, start readableString) []string {
+		set = append(set, string(newKey))
+		sort.Strings(set)
+		result := []string{}
+		var prev string
+		for i, k := range set {
+			if k >= string(start) && (i == 0 || k != prev) {
+				result = append(result, k)
+			}
+			prev = k
+		}
+		return result
+	}
+
+	if err := quick.CheckEqual(radixAddAndWalk, sliceAddSortAndFilter, nil); err != nil {
+		t.Error(err)
+	}
+}
+
+func TestNodeWalkBitPrefix(t *testing.T) {
+	r := New()
+	for _, ip := range [][]byte{
+		{10, 0, 0, 1}, {10, 0, 0, 15}, {10, 0, 0, 16}, {10, 0, 0, 31},
+		{10, 0, 0, 32}, {10, 0, 1, 16}, {10, 128, 0, 0}, {192, 168, 1, 1},
+		{10}, {10, 0},
+	} {
+		r, _, _ = r.Insert(ip, nil)
+	}
+
+	// Check each bit length against a brute force match
+	hasBitPrefix := func(k, prefix []byte, bits int) bool {
+		if 8*len(k) < bits {
+			return false
+		}
+		for b := 0; b < bits; b++ {
+			mask := byte(0x80) >> (b % 8)
+			if k[b/8]&mask != prefix[b/8]&mask {
+				return false
+			}
+		}
+		return true
+	}
+	for _, prefix := range [][]byte{{10, 0, 0, 16}, {10, 0, 1, 255}, {10, 255, 0, 0}, {192, 168, 1, 1}} {
+		for bits := 0; bits <= 32; bits++ {
+			var out [][]byte
+			r.Root().WalkBitPrefix(prefix, bits, func(k []byte, v interface{}) bool {
+				out = append(out, k)
+				return false
+			})
+			var want [][]byte
+			r.Root().Walk(func(k []byte, v interface{}) bool {
+				if hasBitPrefix(k, prefix, bits) {
+					want = append(want, k)
+				}
+				return false
+			})
+			if !reflect.DeepEqual(out, want) {
+				t.Fatalf("mis-match: %v/%d\n  got=%v\n  want=%v", prefix, bits, out, want)
+			}
+		}
+	}
+
+	// A /28 holds the 16 addresses from 10.0.0.16
+	var out [][]byte
+	r.Root().WalkBitPrefix([]byte{10, 0, 0, 16}, 28, func(k []byte, v interface{}) bool {
+		out = append(out, k)
+		return false
+	})
+	if want := [][]byte{{10, 0, 0, 16}, {10, 0, 0, 31}}; !reflect.DeepEqual(out, want) {
+		t.Fatalf("bad: %v", out)
+	}
+
+	defer func() {
+		if recover() == nil {
+			t.Fatalf("expected panic")
+		}
+	}()
+	r.Root().WalkBitPrefix([]byte{10}, 9, func(k []byte, v interface{}) bool { return false })
+}
+
+func TestNodeNeighbors(t *testing.T) {
+	r := New()
+	for _, k := range []string{"a", "ab", "abc", "b", "ba", "c"} {
+		r, _, _ = r.Insert([]byte(k), nil)
+	}
+	strs := func(keys [][]byte) []string {
+		out := []string{}
+		for _, k := range keys {
+			out = append(out, string(k))
+		}
+		return out
+	}
+
+	cases := []struct {
+		k             string
+		before, after int
+		prev, next    []string
+	}{
+		{"b", 2, 2, []string{"abc", "ab"}, []string{"ba", "c"}},
+		{"aa", 1, 1, []string{"a"}, []string{"ab"}},
+		{"abc", 5, 5, []string{"ab", "a"}, []string{"b", "ba", "c"}},
+		{"", 1, 2, []string{}, []string{"a", "ab"}},
+		{"z", 2, 1, []string{"c", "ba"}, []string{}},
+		{"b", 0, 0, []string{}, []string{}},
+	}
+	for _, c := range cases {
+		prev, next := r.Root().Neighbors([]byte(c.k), c.before, c.after)
+		if !reflect.DeepEqual(strs(prev), c.prev) || !reflect.DeepEqual(strs(next), c.next) {
+			t.Fatalf("bad: %q %v %v", c.k, strs(prev), strs(next))
+		}
+	}
+}
+
+func TestNodeGroupByPrefix(t *testing.T) {
+	r := New()
+	keys := []string{"a", "foo", "foo/bar", "foo/bar/baz", "foo/zip", "foobar", "zip", "zip/zap"}
+	for _, k := range keys {
+		r, _, _ = r.Insert([]byte(k), k)
+	}
+
+	prefixes := [][]byte{[]byte("zip/"), []byte("foo/bar"), []byte("foo"), []byte("nope"), []byte("foo/")}
+	var out []string
+	r.Root().GroupByPrefix(prefixes, func(prefix []byte, k []byte, v interface{}) {
+		if v != string(k) {
+			t.Fatalf("bad: %q %v", k, v)
+		}
+		out = append(out, string(prefix)+"="+string(k))
+	})
+	want := []string{
+		"foo=foo",
+		"foo/bar=foo/bar",
+		"foo/bar=foo/bar/baz",
+		"foo/=foo/zip",
+		"foo=foobar",
+		"zip/=zip/zap",
+	}
+	if !reflect.DeepEqual(out, want) {
+		t.Fatalf("bad: %v", out)
+	}
+
+	// The empty prefix matches every key not under another prefix
+	out = nil
+	r.Root().GroupByPrefix([][]byte{[]byte("foo/"), []byte("")}, func(prefix []byte, k []byte, v interface{}) {
+		out = append(out, string(prefix)+"="+string(k))
+	})
+	want = []string{"=a", "=foo", "foo/=foo/bar", "foo/=foo/bar/baz", "foo/=foo/zip", "=foobar", "=zip", "=zip/zap"}
+	if !reflect.DeepEqual(out, want) {
+		t.Fatalf("bad: %v", out)
+	}
+}
+
+func TestNodeWalkRange(t *testing.T) {
+	r := New()
+	keys := []string{"", "a", "ab", "abc", "abd", "b", "ba", "bb", "c", "\xff", "\xff\xff"}
+	for _, k := range keys {
+		r, _, _ = r.Insert([]byte(k), nil)
+	}
+
+	bounds := []string{"", "a", "aa", "ab", "abc", "abcd", "ac", "b", "bab", "c", "d", "\xff", "\xff\xff\xff"}
+	for _, start := range bounds {
+		for _, end := range append(bounds, "<nil>") {
+			var endKey []byte
+			if end != "<nil>" {
+				endKey = []byte(end)
+			}
+			want := []string{}
+			for _, k := range keys {
+				if k >= start && (endKey == nil || k < end) {
+					want = append(want, k)
+				}
+			}
+
+			out := []string{}
+			r.Root().WalkRange([]byte(start), endKey, func(k []byte, v interface{}) bool {
+				out = append(out, string(k))
+				return false
+			})
+			if !reflect.DeepEqual(out, want) {
+				t.Fatalf("mis-match: [%q, %q)\n  got=%v\n  want=%v", start, end, out, want)
+			}
+
+			out = []string{}
+			r.Root().WalkRangeBackwards([]byte(start), endKey, func(k []byte, v interface{}) bool {
+				out = append([]string{string(k)}, out...)
+				return false
+			})
+			if !reflect.DeepEqual(out, want) {
+				t.Fatalf("mis-match backwards: [%q, %q)\n  got=%v\n  want=%v", start, end, out, want)
+			}
+		}
+	}
+
+	// The walks can be stopped early, such as for the latest keys before a
+	// given one
+	var out []string
+	r.Root().WalkRangeBackwards(nil, []byte("bb"), func(k []byte, v interface{}) bool {
+		out = append(out, string(k))
+		return len(out) == 3
+	})
+	if want := []string{"ba", "b", "abd"}; !reflect.DeepEqual(out, want) {
+		t.Fatalf("bad: %v", out)
+	}
+}
+
+func TestNodeNextBytes(t *testing.T) {
+	r := New()
+	for _, k := range []string{"foo", "foobar", "foobaz", "fooqux", "zip"} {
+		r, _, _ = r.Insert([]byte(k), nil)
+	}
+
+	cases := []struct {
+		prefix string
+		next   string
+		isKey  bool
+	}{
+		{"", "fz", false},
+		{"f", "o", false},
+		{"foo", "bq", true},
+		{"foob", "a", false},
+		{"fooba", "rz", false},
+		{"foobar", "", true},
+		{"zi", "p", false},
+		{"nope", "", false},
+	}
+	for _, c := range cases {
+		next, isKey := r.Root().NextBytes([]byte(c.prefix))
+		if string(next) != c.next || isKey != c.isKey {
+			t.Fatalf("bad: %q %q %v", c.prefix, next, isKey)
+		}
+	}
+}
+
+func TestNodeLongestPrefixSep(t *testing.T) {
+	r := New()
+	for _, k := range []string{"foo", "foo/bar", "foo/bar/", "zip/"} {
+		r, _, _ = r.Insert([]byte(k), k)
+	}
+
+	cases := []struct {
+		inp string
+		out string
+		ok  bool
+	}{
+		{"foo", "foo", true},
+		{"foo/", "foo", true},
+		{"foo/ba", "foo", true},
+		{"foo/bar", "foo/bar", true},
+		{"foo/barbaz", "foo", true},
+		{"foo/bar/baz", "foo/bar/", true},
+		{"foo/bar//", "foo/bar/", true},
+		{"foobar", "", false},
+		{"zip/zap", "zip/", true},
+		{"zip", "", false},
+	}
+	for _, c := range cases {
+		k, v, ok := r.Root().LongestPrefixSep([]byte(c.inp), '/')
+		if ok != c.ok || string(k) != c.out || ok && v != c.out {
+			t.Fatalf("bad: %q %q %v %v", c.inp, k, v, ok)
+		}
+
+		// Without the boundaries, the match can be longer
+		if lk, _, _ := r.Root().LongestPrefix([]byte(c.inp)); len(lk) < len(k) {
+			t.Fatalf("bad: %q %q", c.inp, lk)
+		}
+	}
+
+	// The empty key matches anything
+	r, _, _ = r.Insert([]byte(""), "")
+	if k, _, ok := r.Root().LongestPrefixSep([]byte("foobar"), '/'); !ok || len(k) != 0 {
+		t.Fatalf("bad: %q %v", k, ok)
+	}
+}
+
+func TestNodeWalkPrefixSuffix(t *testing.T) {
+	r := New()
+	for _, k := range []string{"foo", "foo/bar", "foo/bar/baz", "foo/zip", "zip"} {
+		r, _, _ = r.Insert([]byte(k), k)
+	}
+
+	walk := func(n *Node, prefix string) []string {
+		out := []string{}
+		n.WalkPrefixSuffix([]byte(prefix), func(suffix []byte, k []byte, v interface{}) bool {
+			if v != string(k) {
+				t.Fatalf("bad: %q %v", k, v)
+			}
+			out = append(out, string(suffix)+"|"+string(k))
+			return false
+		})
+		return out
+	}
+
+	cases := []struct {
+		prefix string
+		out    []string
+	}{
+		{"", []string{"foo|foo", "foo/bar|foo/bar", "foo/bar/baz|foo/bar/baz", "foo/zip|foo/zip", "zip|zip"}},
+		{"foo/", []string{"bar|foo/bar", "bar/baz|foo/bar/baz", "zip|foo/zip"}},
+		{"foo/b", []string{"ar|foo/bar", "ar/baz|foo/bar/baz"}},
+		{"foo/bar/", []string{"baz|foo/bar/baz"}},
+		{"foo/bar/baz", []string{"|foo/bar/baz"}},
+		{"nope", []string{}},
+	}
+	for _, c := range cases {
+		if out := walk(r.Root(), c.prefix); !reflect.DeepEqual(out, c.out) {
+			t.Fatalf("bad: %q %v", c.prefix, out)
+		}
+	}
+
+	// Prefixes follow on from the node walked, though keys are whole
+	_, foo := r.Root().getEdge('f')
+	if out := walk(foo, "/z"); !reflect.DeepEqual(out, []string{"ip|foo/zip"}) {
+		t.Fatalf("bad: %v", out)
+	}
+}
+
+func TestNodeWalkValues(t *testing.T) {
+	r := New()
+	keys := []string{"a", "foo", "foo/bar", "foo/baz", "foobar", "zip"}
+	for _, k := range keys {
+		r, _, _ = r.Insert([]byte(k), k)
+	}
+
+	collect := func(walk func(fn func(v interface{}) bool)) []interface{} {
+		out := []interface{}{}
+		walk(func(v interface{}) bool {
+			out = append(out, v)
+			return false
+		})
+		return out
+	}
+	if out := collect(r.Root().WalkValues); !reflect.DeepEqual(out, []interface{}{"a", "foo", "foo/bar", "foo/baz", "foobar", "zip"}) {
+		t.Fatalf("bad: %v", out)
+	}
+	prefix := func(fn func(v interface{}) bool) { r.Root().WalkPrefixValues([]byte("foo/"), fn) }
+	if out := collect(prefix); !reflect.DeepEqual(out, []interface{}{"foo/bar", "foo/baz"}) {
+		t.Fatalf("bad: %v", out)
+	}
+	rng := func(fn func(v interface{}) bool) { r.Root().WalkRangeValues([]byte("foo/baz"), []byte("zip"), fn) }
+	if out := collect(rng); !reflect.DeepEqual(out, []interface{}{"foo/baz", "foobar"}) {
+		t.Fatalf("bad: %v", out)
+	}
+
+	// The walk can be stopped early
+	n := 0
+	r.Root().WalkValues(func(v interface{}) bool {
+		n++
+		return n == 2
+	})
+	if n != 2 {
+		t.Fatalf("bad: %d", n)
+	}
+}
+
+func benchmarkWalk(b *testing.B, values bool) {
+	txn := New().Txn()
+	for i := 0; i < 100000; i++ {
+		txn.Insert([]byte(fmt.Sprintf("%08d", i)), i)
+	}
+	r, _ := txn.Commit()
+
+	b.ResetTimer()
+	for n := 0; n < b.N; n++ {
+		sum := 0
+		if values {
+			r.Root().WalkValues(func(v interface{}) bool {
+				sum += v.(int)
+				return false
+			})
+		} else {
+			r.Root().Walk(func(k []byte, v interface{}) bool {
+				sum += v.(int)
+				return false
+			})
+		}
+	}
+}
+
+func BenchmarkWalk(b *testing.B) {
+	benchmarkWalk(b, false)
+}
+
+func BenchmarkWalkValues(b *testing.B) {
+	benchmarkWalk(b, true)
+}
+
+func TestNodePrefixes(t *testing.T) {
+	r := New()
+	for _, k := range []string{"foo", "foo/bar", "foo/baz", "zip"} {
+		r, _, _ = r.Insert([]byte(k), nil)
+	}
+
+	var out []string
+	r.Root().Prefixes(func(prefix []byte, isLeaf bool) bool {
+		if !isLeaf {
+			out = append(out, string(prefix)+"*")
+		} else {
+			out = append(out, string(prefix))
+		}
+		return false
+	})
+	if want := []string{"*", "foo", "foo/ba*", "foo/bar", "foo/baz", "zip"}; !reflect.DeepEqual(out, want) {
+		t.Fatalf("bad: %v", out)
+	}
+}
+
+func TestNodeCommonPrefix(t *testing.T) {
+	cases := []struct {
+		keys []string
+		out  string
+	}{
+		{[]string{}, ""},
+		{[]string{"foo/bar"}, "foo/bar"},
+		{[]string{"foo/bar", "foo/baz"}, "foo/ba"},
+		{[]string{"foo", "foo/bar", "foo/baz"}, "foo"},
+		{[]string{"foo/bar", "zip"}, ""},
+		{[]string{"", "foo"}, ""},
+	}
+	for _, c := range cases {
+		r := New()
+		for _, k := range c.keys {
+			r, _, _ = r.Insert([]byte(k), nil)
+		}
+		if out := r.Root().CommonPrefix(); string(out) != c.out {
+			t.Fatalf("bad: %v %q", c.keys, out)
+		}
+	}
+}
+
+func TestNodeWalkCtx(t *testing.T) {
+	txn := New().Txn()
+	for i := 0; i < 10000; i++ {
+		txn.Insert([]byte(fmt.Sprintf("%05d", i)), i)
+	}
+	r, _ := txn.Commit()
+
+	// An uncancelled walk visits every key
+	n := 0
+	if err := r.Root().WalkCtx(context.Background(), func(k []byte, v interface{}) bool {
+		n++
+		return false
+	}); err != nil || n != 10000 {
+		t.Fatalf("bad: %v %d", err, n)
+	}
+
+	// Cancelling stops the walk within a check interval
+	ctx, cancel := context.WithCancel(context.Background())
+	n = 0
+	err := r.Root().WalkCtx(ctx, func(k []byte, v interface{}) bool {
+		if n++; n == 1000 {
+			cancel()
+		}
+		return false
+	})
+	if err != context.Canceled || n >= 1000+walkCtxInterval {
+		t.Fatalf("bad: %v %d", err, n)
+	}
+
+	// A done context stops the walk before it starts
+	n = 0
+	if err := r.Root().WalkCtx(ctx, func(k []byte, v interface{}) bool {
+		n++
+		return false
+	}); err != context.Canceled || n != 0 {
+		t.Fatalf("bad: %v %d", err, n)
+	}
+}
+
+func TestNodeReduce(t *testing.T) {
+	r := New()
+	for i, k := range []string{"a", "foo", "foo/bar", "foo/baz", "foobar", "zip"} {
+		r, _, _ = r.Insert([]byte(k), i+1)
+	}
+	sum := func(acc interface{}, _ []byte, v interface{}) interface{} {
+		return acc.(int) + v.(int)
+	}
+	join := func(acc interface{}, k []byte, _ interface{}) interface{} {
+		return acc.(string) + string(k) + ","
+	}
+
+	n := r.Root()
+	if out := n.Reduce(0, sum); out != 21 {
+		t.Fatalf("bad: %v", out)
+	}
+	if out := n.Reduce("", join); out != "a,foo,foo/bar,foo/baz,foobar,zip," {
+		t.Fatalf("bad: %v", out)
+	}
+	if out := n.ReducePrefix([]byte("foo/"), 0, sum); out != 7 {
+		t.Fatalf("bad: %v", out)
+	}
+	if out := n.ReducePrefix([]byte("nope"), 0, sum); out != 0 {
+		t.Fatalf("bad: %v", out)
+	}
+	if out := n.ReduceRange([]byte("foo/"), []byte("zip"), "", join); out != "foo/bar,foo/baz,foobar," {
+		t.Fatalf("bad: %v", out)
+	}
+	if out := New().Root().Reduce("empty", join); out != "empty" {
+		t.Fatalf("bad: %v", out)
+	}
+}
+
+func TestNodePresentInRange(t *testing.T) {
+	r := New()
+	for _, i := range []int{0, 1, 2, 4, 5, 7, 10, 11} {
+		r, _, _ = r.Insert([]byte(fmt.Sprintf("seq/%04d", i)), nil)
+	}
+
+	cases := []struct {
+		lo, hi string
+		want   []string
+	}{
+		{"seq/0000", "seq/0008", []string{"seq/0000", "seq/0001", "seq/0002", "seq/0004", "seq/0005", "seq/0007"}},
+		{"seq/0003", "seq/0004", nil},
+		{"seq/0003", "seq/0005", []string{"seq/0004"}},
+		{"seq/0008", "", []string{"seq/0010", "seq/0011"}},
+		{"zzz", "", nil},
+	}
+	for _, c := range cases {
+		var hi []byte
+		if c.hi != "" {
+			hi = []byte(c.hi)
+		}
+		var out []string
+		for _, k := range r.Root().PresentInRange([]byte(c.lo), hi) {
+			out = append(out, string(k))
+		}
+		if !reflect.DeepEqual(out, c.want) {
+			t.Fatalf("bad: [%q, %q) %v", c.lo, c.hi, out)
+		}
+	}
+}
+
+// checkSizes checks the size of every node under n against a count of the
+// leaves below it, returning the count
+func checkSizes(t *testing.T, n *Node, path string) int {
+	t.Helper()
+	count := 0
+	if n.leaf != nil {
+		count++
+	}
+	for _, e := range n.edges {
+		count += checkSizes(t, e.node, path+string(e.node.prefix))
+	}
+	if n.Size() != count {
+		t.Fatalf("node at %q has size %d but %d keys", path, n.Size(), count)
+	}
+	return count
+}
+
+func TestNodeSizeFuzz(t *testing.T) {
+	rnd := rand.New(rand.NewSource(1))
+	key := func() []byte {
+		b := make([]byte, rnd.Intn(6))
+		for i := range b {
+			b[i] = "abc"[rnd.Intn(3)]
+		}
+		return b
+	}
+
+	for _, pool := range []bool{false, true} {
+		r := New()
+		if pool {
+			r = NewWithNodePool()
+		}
+		keys := make(map[string]bool)
+		for round := 0; round < 500; round++ {
+			// Each transaction mixes inserts, which split nodes, with
+			// deletes, which merge them, so nodes it already copied are
+			// modified again
+			txn := r.Txn()
+			for n := rnd.Intn(20); n > 0; n-- {
+				k := key()
+				switch rnd.Intn(4) {
+				case 0, 1:
+					txn.Insert(k, nil)
+					keys[string(k)] = true
+				case 2:
+					txn.Delete(k)
+					delete(keys, string(k))
+				case 3:
+					txn.DeleteWhereUnder(k, func(k []byte, _ interface{}) bool {
+						if rnd.Intn(2) == 0 {
+							delete(keys, string(k))
+							return true
+						}
+						return false
+					})
+				}
+				checkSizes(t, txn.Root(), "")
+			}
+			r, _ = txn.Commit()
+			if err := r.Verify(); err != nil {
+				t.Fatalf("round %d: %v", round, err)
+			}
+			if got := checkSizes(t, r.Root(), ""); got != len(keys) {
+				t.Fatalf("round %d: bad: %d %d", round, got, len(keys))
+			}
+
+			for n := 0; n < 5; n++ {
+				prefix := key()
+				want := 0
+				for k := range keys {
+					if strings.HasPrefix(k, string(prefix)) {
+						want++
+					}
+				}
+				if got := r.CountPrefix(prefix); got != want {
+					t.Fatalf("round %d: bad count %q: %d %d", round, prefix, got, want)
+				}
+			}
+		}
+
+		// Trees made by rebuilding and bulk loading are sized too
+		checkSizes(t, r.Filter(func([]byte, interface{}) bool { return rnd.Intn(2) == 0 }).Root(), "")
+		checkSizes(t, r.Subtree([]byte("a"), true).Root(), "")
+		checkSizes(t, r.Compact().Root(), "")
+		it := r.Root().Iterator()
+		built, err := BuildSorted(func() ([]byte, interface{}, bool) {
+			return it.Next()
+		})
+		if err != nil {
+			t.Fatalf("err: %v", err)
+		}
+		if got := checkSizes(t, built.Root(), ""); got != len(keys) {
+			t.Fatalf("bad: %d %d", got, len(keys))
+		}
+	}
+}
+
+func TestNodeWalkSkippable(t *testing.T) {
+	r := New()
+	for _, k := range []string{"a", "a/b", "a/b/c", "a/d", "b", "b/a", "c"} {
+		r, _, _ = r.Insert([]byte(k), nil)
+	}
+
+	cases := []struct {
+		skip, stop string
+		want       []string
+	}{
+		{"", "", []string{"a", "a/b", "a/b/c", "a/d", "b", "b/a", "c"}},
+		{"a", "", []string{"a", "b", "b/a", "c"}},
+		{"a/b", "", []string{"a", "a/b", "a/d", "b", "b/a", "c"}},
+		{"b", "", []string{"a", "a/b", "a/b/c", "a/d", "b", "c"}},
+		{"a", "b/a", []string{"a", "b", "b/a"}},
+		{"", "a", []string{"a"}},
+	}
+	for _, c := range cases {
+		var out []string
+		r.Root().WalkSkippable(func(k []byte, _ interface{}) (bool, bool) {
+			out = append(out, string(k))
+			return string(k) == c.skip, string(k) == c.stop
+		})
+		if !reflect.DeepEqual(out, c.want) {
+			t.Fatalf("bad: skip=%q stop=%q %v", c.skip, c.stop, out)
+		}
+	}
+}
+
+func TestNodeWalkStructure(t *testing.T) {
+	r := New()
+	for i, k := range []string{"foo", "foobar", "foobaz", "zip"} {
+		r, _, _ = r.Insert([]byte(k), i)
+	}
+
+	type visit struct {
+		prefix   string
+		depth    int
+		numEdges int
+		isLeaf   bool
+		val      interface{}
+	}
+	out := []visit{}
+	r.Root().WalkStructure(func(prefix []byte, depth int, numEdges int, isLeaf bool, val interface{}) bool {
+		out = append(out, visit{string(prefix), depth, numEdges, isLeaf, val})
+		return false
+	})
+	want := []visit{
+		{"", 0, 2, false, nil},
+		{"foo", 1, 1, true, 0},
+		{"ba", 2, 2, false, nil},
+		{"r", 3, 0, true, 1},
+		{"z", 3, 0, true, 2},
+		{"zip", 1, 0, true, 3},
+	}
+	if !reflect.DeepEqual(out, want) {
+		t.Fatalf("bad: %v", out)
+	}
+
+	// The walk can be stopped
+	out = out[:0]
+	r.Root().WalkStructure(func(prefix []byte, depth int, numEdges int, isLeaf bool, val interface{}) bool {
+		out = append(out, visit{string(prefix), depth, numEdges, isLeaf, val})
+		return depth == 2
+	})
+	if len(out) != 3 {
+		t.Fatalf("bad: %v", out)
+	}
+}
+
+func TestNodeSampleWalk(t *testing.T) {
+	txn := New().Txn()
+	for i := 0; i < 100000; i++ {
+		txn.Insert([]byte(fmt.Sprintf("%06d", i)), i)
+	}
+	r, _ := txn.Commit()
+
+	sample := func(rate float64, seed int64) []int {
+		var out []int
+		r.Root().SampleWalk(rate, seed, func(_ []byte, v interface{}) bool {
+			out = append(out, v.(int))
+			return false
+		})
+		return out
+	}
+
+	// The same seed gives the same sample, in order
+	a, b := sample(0.01, 1), sample(0.01, 1)
+	if !reflect.DeepEqual(a, b) || !sort.IntsAreSorted(a) {
+		t.Fatalf("bad sample")
+	}
+	if c := sample(0.01, 2); reflect.DeepEqual(a, c) {
+		t.Fatalf("expected a different sample")
+	}
+
+	// Roughly the expected number of keys are sampled
+	if len(a) < 800 || len(a) > 1200 {
+		t.Fatalf("bad: %d", len(a))
+	}
+	if n := len(sample(1, 1)); n != 100000 {
+		t.Fatalf("bad: %d", n)
+	}
+	if n := len(sample(0, 1)); n != 0 {
+		t.Fatalf("bad: %d", n)
+	}
+	for _, rate := range []float64{1e-12, 1e-17, math.SmallestNonzeroFloat64, math.NaN()} {
+		if n := len(sample(rate, 1)); n != 0 {
+			t.Fatalf("bad: %v %d", rate, n)
+		}
+	}
+
+	// The walk can be stopped
+	n := 0
+	r.Root().SampleWalk(0.5, 1, func([]byte, interface{}) bool {
+		n++
+		return n == 10
+	})
+	if n != 10 {
+		t.Fatalf("bad: %d", n)
+	}
+}
+
+func TestNodeSelectRank(t *testing.T) {
+	rnd := rand.New(rand.NewSource(1))
+	key := func() []byte {
+		b := make([]byte, rnd.Intn(6))
+		for i := range b {
+			b[i] = "abc"[rnd.Intn(3)]
+		}
+		return b
+	}
+
+	r := New()
+	for round := 0; round < 100; round++ {
+		txn := r.Txn()
+		for n := rnd.Intn(10); n > 0; n-- {
+			if rnd.Intn(3) == 0 {
+				txn.Delete(key())
+			} else {
+				k := key()
+				txn.Insert(k, string(k))
+			}
+		}
+		r, _ = txn.Commit()
+
+		keys := []string{}
+		r.Root().Walk(func(k []byte, _ interface{}) bool {
+			keys = append(keys, string(k))
+			return false
+		})
+		for i, want := range keys {
+			k, v, ok := r.Root().Select(i)
+			if !ok || string(k) != want || v != want {
+				t.Fatalf("round %d: bad select %d: %q", round, i, k)
+			}
+		}
+		for _, rank := range []int{-1, len(keys)} {
+			if _, _, ok := r.Root().Select(rank); ok {
+				t.Fatalf("round %d: expected no key at %d", round, rank)
+			}
+		}
+		for n := 0; n < 20; n++ {
+			k := string(key())
+			if got, want := r.Root().Rank([]byte(k)), sort.SearchStrings(keys, k); got != want {
+				t.Fatalf("round %d: bad rank %q: %d %d", round, k, got, want)
+			}
+		}
+	}
+}
+
+func TestNodePrefixChain(t *testing.T) {
+	r := New()
+	for _, k := range []string{"", "app", "app/db", "app/db/pool", "app/dbx", "app/web"} {
+		r, _, _ = r.Insert([]byte(k), "cfg:"+k)
+	}
+
+	cases := []struct {
+		inp string
+		out []string
+	}{
+		{"", []string{""}},
+		{"ap", []string{""}},
+		{"app/db/pool/size", []string{"", "app", "app/db", "app/db/pool"}},
+		{"app/db/po", []string{"", "app", "app/db"}},
+		{"app/dbx/timeout", []string{"", "app", "app/db", "app/dbx"}},
+		{"app/web", []string{"", "app", "app/web"}},
+		{"other", []string{""}},
+	}
+	for _, test := range cases {
+		var out []string
+		for _, e := range r.Root().PrefixChain([]byte(test.inp)) {
+			if e.Val != "cfg:"+string(e.Key) {
+				t.Fatalf("bad: %q %v", e.Key, e.Val)
+			}
+			out = append(out, string(e.Key))
+		}
+		if !reflect.DeepEqual(out, test.out) {
+			t.Fatalf("bad: %q %v", test.inp, out)
+		}
+	}
+
+	// Without the empty key, an unrelated key has no chain
+	r, _, _ = r.Delete(nil)
+	if chain := r.Root().PrefixChain([]byte("other")); chain != nil {
+		t.Fatalf("bad: %v", chain)
+	}
+}
@@ -0,0 +1,48 @@
+package iradix
+
+import (
+	"sync"
+	"sync/atomic"
+)
+
+var (
+	// nodePool holds nodes recycled by transactions on trees created with
+	// NewWithNodePool
+	nodePool = sync.Pool{
+		New: func() interface{} {
+			return new(Node)
+		},
+	}
+
+	// lastGen is the most recently issued transaction generation
+	lastGen uint64
+)
+
+// nextGen returns a new generation to stamp the nodes of a pooling
+// transaction with. Generations are never zero.
+func nextGen() uint64 {
+	return atomic.AddUint64(&lastGen, 1)
+}
+
+// newNode returns an empty node to be filled in by the transaction, drawing
+// it from the pool if pooling is enabled
+func (t *Txn) newNode() *Node {
+	t.stats.NodesCreated++
+	if t.gen == 0 {
+		return &Node{}
+	}
+	n := nodePool.Get().(*Node)
+	n.gen = t.gen
+	return n
+}
+
+// recycle returns a superseded node to the pool, but only if it was created
+// by this transaction since it last committed. Any other node may be shared
+// with a committed tree, and so must never be reused.
+func (t *Txn) recycle(n *Node) {
+	if t.gen == 0 || n.gen != t.gen {
+		return
+	}
+	*n = Node{}
+	nodePool.Put(n)
+}
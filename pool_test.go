@@ -0,0 +1,148 @@
+package iradix
+
+import (
+	"fmt"
+	"reflect"
+	"testing"
+)
+
+func TestNodePool(t *testing.T) {
+	keys := make([]string, 0, 1000)
+	for i := 0; i < 1000; i++ {
+		keys = append(keys, fmt.Sprintf("key/%03d/%d", i%97, i))
+	}
+
+	r := NewWithNodePool()
+	txn := r.Txn()
+	for i, k := range keys[:500] {
+		txn.Insert([]byte(k), i)
+	}
+	committed, _ := txn.Commit()
+	committedCopy := CopyTree(committed)
+
+	// Keep writing to the same transaction, which must not recycle any of
+	// the nodes now shared with the committed tree
+	for i, k := range keys[500:] {
+		txn.Insert([]byte(k), i+500)
+	}
+	for _, k := range keys[:250] {
+		txn.Delete([]byte(k))
+	}
+	final, _ := txn.Commit()
+
+	var got []string
+	committed.Root().Walk(func(k []byte, _ interface{}) bool {
+		got = append(got, string(k))
+		return false
+	})
+	var want []string
+	committedCopy.Root().Walk(func(k []byte, _ interface{}) bool {
+		want = append(want, string(k))
+		return false
+	})
+	if !reflect.DeepEqual(got, want) {
+		t.Fatalf("committed tree was modified")
+	}
+
+	for i, k := range keys {
+		val, ok := final.Get([]byte(k))
+		if i < 250 {
+			if ok {
+				t.Fatalf("deleted key present: %s", k)
+			}
+			continue
+		}
+		if !ok || val != i {
+			t.Fatalf("bad: %s %v %v", k, val, ok)
+		}
+	}
+
+	// Trees derived from a pooled tree keep pooling
+	if final.opts == nil || !final.opts.pool {
+		t.Fatalf("pooling not carried over")
+	}
+}
+
+func TestTxnInsertNode(t *testing.T) {
+	txn := NewWithNodePool().Txn()
+	var roots []*Node
+	for i := 0; i < 100; i++ {
+		root, _, ok := txn.InsertNode([]byte(fmt.Sprintf("key/%02d", i)), i)
+		if ok {
+			t.Fatalf("bad: %d", i)
+		}
+		roots = append(roots, root)
+	}
+	root, old, ok := txn.InsertNode([]byte("key/00"), "new")
+	if !ok || old != 0 || root != txn.Root() {
+		t.Fatalf("bad: %v %v", old, ok)
+	}
+
+	// Each returned root still holds exactly the keys inserted up to it,
+	// despite the later writes to the pooled transaction
+	for i, root := range roots {
+		if root.Size() != i+1 {
+			t.Fatalf("bad: %d %d", i, root.Size())
+		}
+		for j := 0; j <= i; j++ {
+			if v, ok := root.Get([]byte(fmt.Sprintf("key/%02d", j))); !ok || v != j {
+				t.Fatalf("bad: %d %d %v %v", i, j, v, ok)
+			}
+		}
+	}
+}
+
+func TestTxnDeleteWithNodePool(t *testing.T) {
+	keys := func(n *Node) []string {
+		var out []string
+		n.Walk(func(k []byte, _ interface{}) bool {
+			out = append(out, string(k))
+			return false
+		})
+		return out
+	}
+
+	txn := NewWithNodePool().Txn()
+	for i := 0; i < 100; i++ {
+		txn.Insert([]byte(fmt.Sprintf("key/%02d", i)), i)
+	}
+	_, ok, changed := txn.DeleteWithNode([]byte("key/50"))
+	if !ok || changed == nil {
+		t.Fatalf("bad: %v", ok)
+	}
+	want := keys(changed)
+
+	// Later writes to the pooled transaction leave the node intact
+	for i := 0; i < 100; i++ {
+		txn.Insert([]byte(fmt.Sprintf("key/%02d", i)), -i)
+		txn.Delete([]byte(fmt.Sprintf("key/%02d", i)))
+	}
+	if got := keys(changed); !reflect.DeepEqual(got, want) {
+		t.Fatalf("bad: %v %v", got, want)
+	}
+}
+
+func benchmarkInsertTxn(b *testing.B, newTree func() *Tree) {
+	keys := make([][]byte, 10000)
+	for i := range keys {
+		keys[i] = []byte(fmt.Sprintf("%08x/%d", i*2654435761, i))
+	}
+
+	b.ReportAllocs()
+	b.ResetTimer()
+	for n := 0; n < b.N; n++ {
+		txn := newTree().Txn()
+		for _, k := range keys {
+			txn.Insert(k, nil)
+		}
+		txn.Commit()
+	}
+}
+
+func BenchmarkInsertTxn(b *testing.B) {
+	benchmarkInsertTxn(b, New)
+}
+
+func BenchmarkInsertTxnPooled(b *testing.B) {
+	benchmarkInsertTxn(b, NewWithNodePool)
+}
@@ -0,0 +1,71 @@
+package iradix
+
+// rebuild returns the subtree at n with each leaf replaced by the result of
+// fn, which may return the leaf unchanged, or nil to drop it. Nodes are only
+// copied along the paths to changed leaves, so any subtree in which every
+// leaf is unchanged is shared with the original as is. Nodes left with
+// neither a leaf nor edges are removed, and nodes left with just one edge are
+// merged with their child, unless they're the root. The result is nil if no
+// leaves remain below a node other than the root.
+func rebuild(n *Node, isRoot bool, fn func(l *leafNode) *leafNode) *Node {
+	leaf := n.leaf
+	if leaf != nil {
+		leaf = fn(leaf)
+	}
+
+	// Rebuild the children, only copying the edges once one changes
+	var es edges
+	edgesChanged := false
+	for idx, e := range n.edges {
+		child := rebuild(e.node, false, fn)
+		if child != e.node && !edgesChanged {
+			es = make(edges, idx, len(n.edges))
+			copy(es, n.edges[:idx])
+			edgesChanged = true
+		}
+		if edgesChanged && child != nil {
+			es = append(es, edge{label: e.label, node: child})
+		}
+	}
+	if leaf == n.leaf && !edgesChanged {
+		return n
+	}
+	if !edgesChanged {
+		es = n.edges
+	}
+
+	size := 0
+	if leaf != nil {
+		size++
+	}
+	for _, e := range es {
+		size += e.node.size
+	}
+
+	switch {
+	case isRoot:
+		return &Node{leaf: leaf, prefix: n.prefix, edges: es, size: size}
+	case leaf == nil && len(es) == 0:
+		return nil
+	case leaf == nil && len(es) == 1:
+		child := es[0].node
+		return &Node{
+			leaf:   child.leaf,
+			prefix: concat(n.prefix, child.prefix),
+			edges:  child.edges,
+			size:   size,
+		}
+	}
+	return &Node{leaf: leaf, prefix: n.prefix, edges: es, size: size}
+}
+
+// sameValue reports whether a and b are identical, as with ==, but reports
+// false rather than panicking if they hold values that can't be compared
+func sameValue(a, b interface{}) (same bool) {
+	defer func() {
+		if recover() != nil {
+			same = false
+		}
+	}()
+	return a == b
+}
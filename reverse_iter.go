@@ -6,34 +6,54 @@ import "bytes"
 // in reverse in-order
 type ReverseIterator struct {
 	i *Iterator
+
+	// expandedParents stores the set of parent nodes whose relevant children
+	// have already been pushed onto the stack. Unlike forward iteration, we
+	// need to recurse into children before we can return the leaf stored in
+	// an internal node, since all of its children are greater. This tracks
+	// which nodes have already had their children put on the stack.
+	expandedParents map[*Node]struct{}
+
+	// peeked is set when Peek has fetched the result of the next call to
+	// Previous, which is held in peekKey, peekVal and peekOK
+	peeked  bool
+	peekKey []byte
+	peekVal interface{}
+	peekOK  bool
 }
 
 // NewReverseIterator returns a new ReverseIterator at a node
 func NewReverseIterator(n *Node) *ReverseIterator {
 	return &ReverseIterator{
-		i: &Iterator{node: n},
+		i: &Iterator{node: n, root: n},
 	}
 }
 
-// SeekPrefix is used to seek the iterator to a given prefix
+// SeekPrefix is used to seek the iterator to a given prefix, so that
+// Previous returns the keys under the prefix in descending order. As with
+// WalkPrefix, the prefix may end partway along an edge.
 func (ri *ReverseIterator) SeekPrefix(prefix []byte) {
+	ri.expandedParents = nil
+	ri.peeked = false
 	ri.i.SeekPrefix(prefix)
 }
 
-func (ri *ReverseIterator) recurseMax(n *Node) *Node {
-	// Traverse to the maximum child
-	if n.leaf != nil {
-		return n
-	}
-	if len(n.edges) > 0 {
-		// Add all the other edges to the stack (the max node will be added as
-		// we recurse)
-		m := len(n.edges)
-		ri.i.stack = append(ri.i.stack, n.edges[:m-1])
-		return ri.recurseMax(n.edges[m-1].node)
-	}
-	// Shouldn't be possible
-	return nil
+// Limit restricts the iterator to returning at most n more results, after
+// which Previous returns false. Seeking doesn't affect the limit, so it can be
+// set either before or after seeking. A negative n removes the limit.
+func (ri *ReverseIterator) Limit(n int) {
+	ri.i.Limit(n)
+}
+
+// SeekUpperBound is used to seek the iterator to the largest key that is
+// lower or equal to the given bound, so that Previous returns the keys from
+// there down in descending order. It's the same as SeekReverseLowerBound,
+// which is named for being the reverse of Iterator.SeekLowerBound, but it
+// names the bound by the role it plays in a range: Iterator.SeekLowerBound
+// starts an ascending scan at the smallest key >= its bound, whereas this
+// starts a descending scan at the largest key <= its bound.
+func (ri *ReverseIterator) SeekUpperBound(bound []byte) {
+	ri.SeekReverseLowerBound(bound)
 }
 
 // SeekReverseLowerBound is used to seek the iterator to the largest key that is
@@ -43,14 +63,25 @@ func (ri *ReverseIterator) recurseMax(n *Node) *Node {
 func (ri *ReverseIterator) SeekReverseLowerBound(key []byte) {
 	// Wipe the stack. Unlike Prefix iteration, we need to build the stack as we
 	// go because we need only a subset of edges of many nodes in the path to the
-	// leaf with the lower bound.
+	// leaf with the lower bound. Note that the iterator will still recurse into
+	// children that we don't traverse on the way to the reverse lower bound as
+	// it walks the stack.
 	ri.i.stack = []edges{}
-	n := ri.i.node
-	search := key
+	// The node needs to end up as nil either way, so that Previous doesn't
+	// assume it is iterating the whole subtree.
+	n := ri.i.root
+	ri.i.node = nil
+	search := ri.i.opts.path(key)
+	ri.expandedParents = make(map[*Node]struct{})
+	ri.peeked = false
 
 	found := func(n *Node) {
-		ri.i.node = n
 		ri.i.stack = append(ri.i.stack, edges{edge{node: n}})
+		// We need to mark this node as expanded in advance too, otherwise the
+		// iterator will walk all of its children even though they are greater
+		// than the lower bound we have found. All of its children that we want
+		// to walk are already on the stack, which is to say none of them.
+		ri.expandedParents[n] = struct{}{}
 	}
 
 	for {
@@ -63,41 +94,50 @@ func (ri *ReverseIterator) SeekReverseLowerBound(key []byte) {
 		}
 
 		if prefixCmp < 0 {
-			// Prefix is smaller than search prefix, that means there is no lower bound.
-			// But we are looking in reverse, so the reverse lower bound will be the
-			// largest leaf under this subtree, since it is the value that would come
-			// right before the current search prefix if it were in the tree. So we need
-			// to follow the maximum path in this subtree to find it.
-			n = ri.recurseMax(n)
-			if n != nil {
-				found(n)
-			}
+			// Prefix is smaller than search prefix, that means there is no exact
+			// match for the search key. But we are looking in reverse, so the
+			// reverse lower bound will be the largest leaf under this subtree,
+			// since it is the value that would come right before the search key
+			// if it were in the tree. This is exactly what the iterator will do
+			// with an unexpanded node on the stack, so we leave it to Previous
+			// to recurse through the children.
+			ri.i.stack = append(ri.i.stack, edges{edge{node: n}})
 			return
 		}
 
 		if prefixCmp > 0 {
-			// Prefix is larger than search prefix, that means there is no reverse lower
-			// bound since nothing comes before our current search prefix.
-			ri.i.node = nil
+			// Prefix is larger than search prefix, that means there is no reverse
+			// lower bound since nothing comes before our current search prefix.
 			return
 		}
 
-		// Prefix is equal, we are still heading for an exact match. If this is a
-		// leaf we're done.
+		// Prefix is equal. If this is a leaf, its key is either an exact match
+		// for the search, or it's lower. It can't be greater.
 		if n.leaf != nil {
-			if bytes.Compare(n.leaf.key, key) < 0 {
-				ri.i.node = nil
+			// If it's an exact match, or there are no children to search, this
+			// leaf is the lower bound and we're done.
+			if len(n.prefix) == len(search) || len(n.edges) == 0 {
+				found(n)
 				return
 			}
-			found(n)
-			return
+
+			// This leaf is internal so we'll keep searching, but it still has
+			// to be iterated after its children. It goes on the stack first,
+			// marked as expanded since we'll add its relevant children below.
+			ri.i.stack = append(ri.i.stack, edges{edge{node: n}})
+			ri.expandedParents[n] = struct{}{}
 		}
 
-		// Consume the search prefix
-		if len(n.prefix) > len(search) {
-			search = []byte{}
-		} else {
-			search = search[len(n.prefix):]
+		// Consume the search prefix. This is safe because if the prefix were
+		// longer than the search, prefixCmp would have been > 0 above.
+		search = search[len(n.prefix):]
+
+		if len(search) == 0 {
+			// We've exhausted the search key but aren't at a leaf. That means
+			// all the children are greater than the search key, so there's no
+			// reverse lower bound in this subtree. The stack already holds any
+			// smaller nodes from further up the tree.
+			return
 		}
 
 		// Otherwise, take the lower bound next edge.
@@ -120,11 +160,9 @@ func (ri *ReverseIterator) SeekReverseLowerBound(key []byte) {
 		// Exit if there's not lower bound edge. The stack will have the
 		// previous nodes already.
 		if lbNode == nil {
-			ri.i.node = nil
 			return
 		}
 
-		ri.i.node = lbNode
 		// Recurse
 		n = lbNode
 	}
@@ -132,6 +170,39 @@ func (ri *ReverseIterator) SeekReverseLowerBound(key []byte) {
 
 // Previous returns the previous node in reverse order
 func (ri *ReverseIterator) Previous() ([]byte, interface{}, bool) {
+	ri.i.checkTxn()
+	if ri.i.limited && ri.i.remaining == 0 {
+		return nil, nil, false
+	}
+
+	k, v, ok := ri.peekKey, ri.peekVal, ri.peekOK
+	if !ri.peeked {
+		k, v, ok = ri.fetch()
+	}
+	ri.peeked = false
+	if !ok {
+		return nil, nil, false
+	}
+	ri.i.remaining--
+	return k, v, true
+}
+
+// Peek returns what the next call to Previous will, without advancing the
+// iterator, such as to decide which of several iterators to advance
+func (ri *ReverseIterator) Peek() ([]byte, interface{}, bool) {
+	ri.i.checkTxn()
+	if ri.i.limited && ri.i.remaining == 0 {
+		return nil, nil, false
+	}
+	if !ri.peeked {
+		ri.peekKey, ri.peekVal, ri.peekOK = ri.fetch()
+		ri.peeked = true
+	}
+	return ri.peekKey, ri.peekVal, ri.peekOK
+}
+
+// fetch does the work of Previous, popping the stack to the next leaf
+func (ri *ReverseIterator) fetch() ([]byte, interface{}, bool) {
 	// Initialize our stack if needed
 	if ri.i.stack == nil && ri.i.node != nil {
 		ri.i.stack = []edges{
@@ -141,6 +212,10 @@ func (ri *ReverseIterator) Previous() ([]byte, interface{}, bool) {
 		}
 	}
 
+	if ri.expandedParents == nil {
+		ri.expandedParents = make(map[*Node]struct{})
+	}
+
 	for len(ri.i.stack) > 0 {
 		// Inspect the last element of the stack
 		n := len(ri.i.stack)
@@ -148,6 +223,16 @@ func (ri *ReverseIterator) Previous() ([]byte, interface{}, bool) {
 		m := len(last)
 		elem := last[m-1].node
 
+		// If this is an internal node we haven't seen yet, we need to leave
+		// it on the stack so we can return its leaf, if any, after we've
+		// recursed through all of its children.
+		_, alreadyExpanded := ri.expandedParents[elem]
+		if len(elem.edges) > 0 && !alreadyExpanded {
+			ri.expandedParents[elem] = struct{}{}
+			ri.i.stack = append(ri.i.stack, elem.edges)
+			continue
+		}
+
 		// Update the stack
 		if m > 1 {
 			ri.i.stack[n-1] = last[:m-1]
@@ -155,9 +240,9 @@ func (ri *ReverseIterator) Previous() ([]byte, interface{}, bool) {
 			ri.i.stack = ri.i.stack[:n-1]
 		}
 
-		// Push the edges onto the frontier
-		if len(elem.edges) > 0 {
-			ri.i.stack = append(ri.i.stack, elem.edges)
+		// The node won't be visited again, so it no longer needs tracking
+		if alreadyExpanded {
+			delete(ri.expandedParents, elem)
 		}
 
 		// Return the leaf values if any
@@ -1,6 +1,7 @@
 package iradix
 
 import (
+	"reflect"
 	"sort"
 	"testing"
 	"testing/quick"
@@ -11,17 +12,14 @@ func TestReverseIterator_SeekReverseLowerBoundFuzz(t *testing.T) {
 	set := []string{}
 
 	// This specifies a property where each call adds a new random key to the radix
-	// tree (with a null byte appended since our tree doesn't support one key
-	// being a prefix of another and treats null bytes specially).
+	// tree. Keys may be prefixes of one another.
 	//
 	// It also maintains a plain sorted list of the same set of keys and asserts
 	// that iterating from some random key to the beginning using ReverseLowerBound
 	// produces the same list as filtering all sorted keys that are bigger.
 
 	radixAddAndScan := func(newKey, searchKey readableString) []string {
-		// Append a null byte
-		key := []byte(newKey + "\x00")
-		r, _, _ = r.Insert(key, nil)
+		r, _, _ = r.Insert([]byte(newKey), nil)
 
 		// Now iterate the tree from searchKey to the beginning
 		it := r.Root().ReverseIterator()
@@ -32,8 +30,7 @@ func TestReverseIterator_SeekReverseLowerBoundFuzz(t *testing.T) {
 			if !ok {
 				break
 			}
-			// Strip the null byte and append to result set
-			result = append(result, string(key[0:len(key)-1]))
+			result = append(result, string(key))
 		}
 		return result
 	}
@@ -73,6 +70,10 @@ func TestReverseIterator_SeekReverseLowerBoundFuzzFromNonRoot(t *testing.T) {
 	// But when starting from a non-root node, the prefix is not empty and so
 	// it will require a recursive search for the global maximum in the
 	// sub-tree, which is not needed when starting from the root.
+	//
+	// A null byte is appended to each key so that none is a prefix of
+	// another, which keeps the expected results simple to work out from the
+	// prefix of the first child of the root.
 
 	r := New()
 	set := []string{}
@@ -197,3 +198,230 @@ func TestReverseIterator_Previous(t *testing.T) {
 		}
 	}
 }
+
+func TestReverseIterator_SeekReverseLowerBoundPrefixKeys(t *testing.T) {
+	r := New()
+	keys := []string{"a", "ab", "abc", "abd", "abe", "b"}
+	for _, k := range keys {
+		r, _, _ = r.Insert([]byte(k), nil)
+	}
+
+	cases := []struct {
+		search string
+		want   []string
+	}{
+		{"abd", []string{"abd", "abc", "ab", "a"}},
+		{"abca", []string{"abc", "ab", "a"}},
+		{"ab", []string{"ab", "a"}},
+		{"aba", []string{"ab", "a"}},
+		{"abz", []string{"abe", "abd", "abc", "ab", "a"}},
+		{"z", []string{"b", "abe", "abd", "abc", "ab", "a"}},
+		{"0", []string{}},
+	}
+	for _, c := range cases {
+		it := r.Root().ReverseIterator()
+		it.SeekReverseLowerBound([]byte(c.search))
+		out := []string{}
+		for {
+			key, _, ok := it.Previous()
+			if !ok {
+				break
+			}
+			out = append(out, string(key))
+		}
+		if !reflect.DeepEqual(out, c.want) {
+			t.Fatalf("mis-match: key=%s\n  got=%v\n  want=%v", c.search, out, c.want)
+		}
+	}
+
+	// Without a seek, each key still comes after all of the keys below it
+	it := r.Root().ReverseIterator()
+	out := []string{}
+	for {
+		key, _, ok := it.Previous()
+		if !ok {
+			break
+		}
+		out = append(out, string(key))
+	}
+	if want := []string{"b", "abe", "abd", "abc", "ab", "a"}; !reflect.DeepEqual(out, want) {
+		t.Fatalf("mis-match: got=%v want=%v", out, want)
+	}
+}
+
+func TestReverseIterator_Limit(t *testing.T) {
+	r := New()
+	keys := []string{"001", "002", "005", "010", "100"}
+	for _, k := range keys {
+		r, _, _ = r.Insert([]byte(k), nil)
+	}
+
+	for _, limitFirst := range []bool{true, false} {
+		it := r.Root().ReverseIterator()
+		if limitFirst {
+			it.Limit(2)
+		}
+		it.SeekReverseLowerBound([]byte("009"))
+		if !limitFirst {
+			it.Limit(2)
+		}
+		out := []string{}
+		for k, _, ok := it.Previous(); ok; k, _, ok = it.Previous() {
+			out = append(out, string(k))
+		}
+		if want := []string{"005", "002"}; !reflect.DeepEqual(out, want) {
+			t.Fatalf("bad: %v %v", limitFirst, out)
+		}
+	}
+}
+
+func TestReverseIterator_SeekPrefixOrder(t *testing.T) {
+	r := New()
+	keys := []string{"foo", "foo/", "foo/bar", "foo/bar/baz", "foo/baz", "foobar", "zipzap"}
+	for _, k := range keys {
+		r, _, _ = r.Insert([]byte(k), nil)
+	}
+
+	cases := []struct {
+		prefix string
+		want   []string
+	}{
+		{"", []string{"zipzap", "foobar", "foo/baz", "foo/bar/baz", "foo/bar", "foo/", "foo"}},
+		{"foo/", []string{"foo/baz", "foo/bar/baz", "foo/bar", "foo/"}},
+		{"foo/b", []string{"foo/baz", "foo/bar/baz", "foo/bar"}},
+		{"foo/bar/", []string{"foo/bar/baz"}},
+		{"z", []string{"zipzap"}},
+		{"zip", []string{"zipzap"}},
+		{"zipzap", []string{"zipzap"}},
+		{"zipzapp", []string{}},
+		{"zz", []string{}},
+	}
+	for _, c := range cases {
+		it := r.Root().ReverseIterator()
+		it.SeekPrefix([]byte(c.prefix))
+		out := []string{}
+		for k, _, ok := it.Previous(); ok; k, _, ok = it.Previous() {
+			out = append(out, string(k))
+		}
+		if !reflect.DeepEqual(out, c.want) {
+			t.Fatalf("mis-match: prefix=%q\n  got=%v\n  want=%v", c.prefix, out, c.want)
+		}
+	}
+}
+
+func TestReverseIterator_SeekUpperBound(t *testing.T) {
+	r := New()
+	keys := []string{"a", "ab", "abc", "b", "ba", "bb", "c"}
+	for _, k := range keys {
+		r, _, _ = r.Insert([]byte(k), nil)
+	}
+
+	// Scanning the range [lo, hi] forwards from the lower bound and
+	// backwards from the upper bound gives the same keys in opposite orders
+	cases := []struct {
+		lo, hi string
+		want   []string
+	}{
+		{"a", "c", keys},
+		{"ab", "b", []string{"ab", "abc", "b"}},
+		{"aa", "bab", []string{"ab", "abc", "b", "ba"}},
+		{"abc", "abc", []string{"abc"}},
+		{"abd", "az", []string{}},
+		{"", "", []string{}},
+		{"d", "z", []string{}},
+	}
+	for _, c := range cases {
+		forward := []string{}
+		it := r.Root().Iterator()
+		it.SeekLowerBound([]byte(c.lo))
+		for k, _, ok := it.Next(); ok && string(k) <= c.hi; k, _, ok = it.Next() {
+			forward = append(forward, string(k))
+		}
+
+		backward := []string{}
+		rit := r.Root().ReverseIterator()
+		rit.SeekUpperBound([]byte(c.hi))
+		for k, _, ok := rit.Previous(); ok && string(k) >= c.lo; k, _, ok = rit.Previous() {
+			backward = append([]string{string(k)}, backward...)
+		}
+
+		if !reflect.DeepEqual(forward, c.want) || !reflect.DeepEqual(backward, c.want) {
+			t.Fatalf("bad: [%q, %q]\n  forward=%v\n  backward=%v\n  want=%v",
+				c.lo, c.hi, forward, backward, c.want)
+		}
+	}
+}
+
+func TestReverseIterator_Peek(t *testing.T) {
+	r := New()
+	for _, k := range []string{"a", "b", "foo", "foo/bar", "zip"} {
+		r, _, _ = r.Insert([]byte(k), k)
+	}
+	peek := func(it *ReverseIterator) string {
+		k, v, ok := it.Peek()
+		if !ok {
+			return "<none>"
+		}
+		if v != string(k) {
+			t.Fatalf("bad: %q %v", k, v)
+		}
+		return string(k)
+	}
+	previous := func(it *ReverseIterator) string {
+		k, _, ok := it.Previous()
+		if !ok {
+			return "<none>"
+		}
+		return string(k)
+	}
+
+	// Peeking doesn't advance the iterator
+	it := r.Root().ReverseIterator()
+	for _, want := range []string{"zip", "foo/bar", "foo", "b", "a", "<none>"} {
+		if k := peek(it); k != want {
+			t.Fatalf("bad: %s", k)
+		}
+		if k := peek(it); k != want {
+			t.Fatalf("bad: %s", k)
+		}
+		if k := previous(it); k != want {
+			t.Fatalf("bad: %s", k)
+		}
+	}
+
+	// Seeking discards a peeked key
+	it = r.Root().ReverseIterator()
+	if k := peek(it); k != "zip" {
+		t.Fatalf("bad: %s", k)
+	}
+	it.SeekReverseLowerBound([]byte("foo/a"))
+	if k := peek(it); k != "foo" {
+		t.Fatalf("bad: %s", k)
+	}
+	it.SeekPrefix([]byte("foo"))
+	if k := peek(it); k != "foo/bar" {
+		t.Fatalf("bad: %s", k)
+	}
+	if k := previous(it); k != "foo/bar" {
+		t.Fatalf("bad: %s", k)
+	}
+	if k := previous(it); k != "foo" {
+		t.Fatalf("bad: %s", k)
+	}
+	if k := peek(it); k != "<none>" {
+		t.Fatalf("bad: %s", k)
+	}
+
+	// Peeking doesn't use up the limit
+	it = r.Root().ReverseIterator()
+	it.Limit(1)
+	if k := peek(it); k != "zip" {
+		t.Fatalf("bad: %s", k)
+	}
+	if k := previous(it); k != "zip" {
+		t.Fatalf("bad: %s", k)
+	}
+	if k := peek(it); k != "<none>" {
+		t.Fatalf("bad: %s", k)
+	}
+}
@@ -0,0 +1,41 @@
+package iradix
+
+// RoutingTable is an immutable table of routes keyed by prefixes, such as of
+// addresses or paths, which matches keys to the route with the longest
+// prefix of the key. Like a Tree, it's safe to use concurrently, and changes
+// return a new table.
+type RoutingTable struct {
+	tree *Tree
+}
+
+// NewRoutingTable returns an empty RoutingTable
+func NewRoutingTable() *RoutingTable {
+	return &RoutingTable{tree: New()}
+}
+
+// Add returns a new table with a route for the given prefix, replacing any
+// route the prefix already has
+func (r *RoutingTable) Add(prefix []byte, route interface{}) *RoutingTable {
+	tree, _, _ := r.tree.Insert(prefix, route)
+	return &RoutingTable{tree: tree}
+}
+
+// Remove returns a new table without the route for the given prefix
+func (r *RoutingTable) Remove(prefix []byte) *RoutingTable {
+	tree, _, ok := r.tree.Delete(prefix)
+	if !ok {
+		return r
+	}
+	return &RoutingTable{tree: tree}
+}
+
+// Match returns the route whose prefix is the longest prefix of key, along
+// with that prefix, or false if no route's prefix matches
+func (r *RoutingTable) Match(key []byte) ([]byte, interface{}, bool) {
+	return r.tree.root.LongestPrefix(key)
+}
+
+// Tree returns the tree holding the routes, keyed by their prefixes
+func (r *RoutingTable) Tree() *Tree {
+	return r.tree
+}
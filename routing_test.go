@@ -0,0 +1,69 @@
+package iradix
+
+import "testing"
+
+func TestRoutingTable(t *testing.T) {
+	r := NewRoutingTable()
+	if _, _, ok := r.Match([]byte("/anything")); ok {
+		t.Fatalf("unexpected match")
+	}
+
+	routes := map[string]string{
+		"/":             "root",
+		"/api/":         "api",
+		"/api/v1/":      "v1",
+		"/api/v1/users": "users",
+		"/static/":      "static",
+	}
+	for prefix, route := range routes {
+		r = r.Add([]byte(prefix), route)
+	}
+
+	cases := []struct {
+		key    string
+		prefix string
+		route  string
+		ok     bool
+	}{
+		{"/", "/", "root", true},
+		{"/index.html", "/", "root", true},
+		{"/api", "/", "root", true},
+		{"/api/", "/api/", "api", true},
+		{"/api/v2/users", "/api/", "api", true},
+		{"/api/v1/users", "/api/v1/users", "users", true},
+		{"/api/v1/users/1", "/api/v1/users", "users", true},
+		{"/api/v1/user", "/api/v1/", "v1", true},
+		{"/static/app.js", "/static/", "static", true},
+		{"api/", "", "", false},
+		{"", "", "", false},
+	}
+	check := func(r *RoutingTable) {
+		for _, c := range cases {
+			prefix, route, ok := r.Match([]byte(c.key))
+			if string(prefix) != c.prefix || ok != c.ok || ok && route != c.route {
+				t.Fatalf("bad: %q %q %v %v", c.key, prefix, route, ok)
+			}
+		}
+	}
+	check(r)
+
+	// Removing a route falls back to the next longest, without affecting
+	// the original table
+	r2 := r.Remove([]byte("/api/v1/"))
+	if prefix, route, _ := r2.Match([]byte("/api/v1/user")); string(prefix) != "/api/" || route != "api" {
+		t.Fatalf("bad: %q %v", prefix, route)
+	}
+	check(r)
+	if r2.Remove([]byte("/nope")) != r2 {
+		t.Fatalf("expected the same table")
+	}
+
+	// Adding a route replaces an existing one
+	r3 := r.Add([]byte("/api/"), "api2")
+	if _, route, _ := r3.Match([]byte("/api/x")); route != "api2" {
+		t.Fatalf("bad: %v", route)
+	}
+	if _, route, _ := r.Match([]byte("/api/x")); route != "api" {
+		t.Fatalf("bad: %v", route)
+	}
+}
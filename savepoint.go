@@ -0,0 +1,53 @@
+package iradix
+
+// SavepointID identifies a state of a transaction remembered by
+// Txn.Savepoint
+type SavepointID int
+
+// savepoint is a remembered state of a transaction
+type savepoint struct {
+	root    *Node
+	stats   CommitStats
+	size    int
+	changes int
+}
+
+// Savepoint remembers the current state of the transaction, so that later
+// changes can be undone with RollbackTo. This is cheap, since the nodes of
+// the current root are never modified, only copied.
+func (t *Txn) Savepoint() SavepointID {
+	t.checkActive()
+
+	// Seal the nodes created so far, so they're not recycled while the
+	// savepoint shares them
+	if t.gen != 0 {
+		t.gen = nextGen()
+	}
+	t.savepoints = append(t.savepoints, savepoint{
+		root:    t.root,
+		stats:   t.stats,
+		size:    t.size,
+		changes: len(t.changes),
+	})
+	return SavepointID(len(t.savepoints) - 1)
+}
+
+// RollbackTo restores the transaction to the state remembered by the given
+// savepoint, undoing all changes made since, including to the journal
+// returned by Changes and the counts returned by CommitStats and
+// PendingLen. The savepoint may be rolled back to again, but those taken
+// after it are discarded, and rolling back to one of them panics.
+func (t *Txn) RollbackTo(id SavepointID) {
+	t.checkActive()
+	if id < 0 || int(id) >= len(t.savepoints) {
+		panic("rollback to unknown savepoint")
+	}
+	sp := t.savepoints[id]
+	t.setRoot(sp.root)
+	t.stats = sp.stats
+	t.size = sp.size
+	if sp.changes < len(t.changes) {
+		t.changes = t.changes[:sp.changes]
+	}
+	t.savepoints = t.savepoints[:id+1]
+}
@@ -0,0 +1,57 @@
+package iradix
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestTxnSavepoint(t *testing.T) {
+	for _, r := range []*Tree{New(), NewWithNodePool()} {
+		txn := r.Txn()
+		txn.Insert([]byte("foo"), 1)
+		txn.Insert([]byte("foobar"), 2)
+		sp1 := txn.Savepoint()
+
+		txn.Insert([]byte("foo"), 3)
+		txn.Delete([]byte("foobar"))
+		sp2 := txn.Savepoint()
+		txn.Insert([]byte("zip"), 4)
+
+		txn.RollbackTo(sp2)
+		if _, ok := txn.Get([]byte("zip")); ok {
+			t.Fatalf("expected zip to be rolled back")
+		}
+		if v, _ := txn.Get([]byte("foo")); v != 3 {
+			t.Fatalf("bad: %v", v)
+		}
+
+		txn.RollbackTo(sp1)
+		txn.Insert([]byte("zap"), 5)
+		tree, stats := txn.CommitStats()
+
+		var keys []string
+		var vals []interface{}
+		tree.Root().Walk(func(k []byte, v interface{}) bool {
+			keys = append(keys, string(k))
+			vals = append(vals, v)
+			return false
+		})
+		if !reflect.DeepEqual(keys, []string{"foo", "foobar", "zap"}) ||
+			!reflect.DeepEqual(vals, []interface{}{1, 2, 5}) {
+			t.Fatalf("bad: %v %v", keys, vals)
+		}
+		if stats.LeavesInserted != 3 || stats.LeavesUpdated != 0 || stats.LeavesDeleted != 0 {
+			t.Fatalf("bad: %+v", stats)
+		}
+
+		// Later savepoints are discarded by the rollback
+		func() {
+			defer func() {
+				if recover() == nil {
+					t.Fatalf("expected panic")
+				}
+			}()
+			txn.RollbackTo(sp2)
+		}()
+	}
+}
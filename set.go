@@ -0,0 +1,224 @@
+package iradix
+
+// Set is an immutable set of keys, built on a Tree whose values are all nil.
+// Like a Tree, it's safe to use concurrently, and changes return a new set.
+type Set struct {
+	tree *Tree
+}
+
+// NewSet returns an empty Set
+func NewSet() *Set {
+	return &Set{tree: New()}
+}
+
+// Add returns a set that also holds the given key, which is this set if it
+// already did
+func (s *Set) Add(k []byte) *Set {
+	if s.Contains(k) {
+		return s
+	}
+	tree, _, _ := s.tree.Insert(k, nil)
+	return &Set{tree: tree}
+}
+
+// Remove returns a set without the given key, which is this set if it
+// didn't hold it
+func (s *Set) Remove(k []byte) *Set {
+	tree, _, ok := s.tree.Delete(k)
+	if !ok {
+		return s
+	}
+	return &Set{tree: tree}
+}
+
+// Contains returns whether the set holds the given key
+func (s *Set) Contains(k []byte) bool {
+	_, ok := s.tree.Get(k)
+	return ok
+}
+
+// Len returns the number of keys in the set
+func (s *Set) Len() int {
+	return s.tree.Len()
+}
+
+// Walk is used to walk the keys of the set in order, until fn returns true
+func (s *Set) Walk(fn func(k []byte) bool) {
+	s.tree.root.Walk(func(k []byte, _ interface{}) bool {
+		return fn(k)
+	})
+}
+
+// Tree returns the tree holding the keys, each with a nil value
+func (s *Set) Tree() *Tree {
+	return s.tree
+}
+
+// Union returns a set holding the keys of both sets. The trees are merged
+// node by node, so any subtree of either set with no counterpart in the
+// other is shared by the result as it is, rather than rebuilt key by key,
+// and only the nodes where both sets hold keys are copied. Subtrees the sets
+// share are skipped, and this set is returned if o adds nothing to it.
+func (s *Set) Union(o *Set) *Set {
+	if s.Len() == 0 {
+		return o
+	}
+	root := unionNodes(s.tree.root, o.tree.root)
+	if root == s.tree.root {
+		return s
+	}
+	return &Set{tree: &Tree{root: root, opts: s.tree.opts, size: root.size}}
+}
+
+// unionNodes returns a node holding the keys under both a and b, which hang
+// from the same parent by the same edge, or are both roots. Each is reused
+// wherever the other has nothing to add, and a is returned if b adds nothing
+// to it.
+func unionNodes(a, b *Node) *Node {
+	if a == b {
+		return a
+	}
+	common := longestPrefix(a.prefix, b.prefix)
+	switch {
+	case common == len(a.prefix) && common == len(b.prefix):
+		// The nodes are at the same path, so merge their leaves and edges
+		leaf := a.leaf
+		if leaf == nil {
+			leaf = b.leaf
+		}
+		return mergeEdges(a, leaf, b.edges)
+
+	case common == len(a.prefix):
+		// b is below a, so merge it into a's edges
+		return mergeEdges(a, a.leaf, edges{{label: b.prefix[common], node: withPrefix(b, b.prefix[common:])}})
+
+	case common == len(b.prefix):
+		// a is below b, so merge it into b's edges, keeping b's leaf
+		return mergeEdges(b, b.leaf, edges{{label: a.prefix[common], node: withPrefix(a, a.prefix[common:])}})
+
+	default:
+		// The nodes diverge part way along their prefixes, so they become
+		// the children of a new node holding the common part
+		ea := edge{label: a.prefix[common], node: withPrefix(a, a.prefix[common:])}
+		eb := edge{label: b.prefix[common], node: withPrefix(b, b.prefix[common:])}
+		if eb.label < ea.label {
+			ea, eb = eb, ea
+		}
+		return &Node{
+			prefix: a.prefix[:common],
+			edges:  edges{ea, eb},
+			size:   a.size + b.size,
+		}
+	}
+}
+
+// mergeEdges returns a node with n's prefix, the given leaf, and the union
+// of n's edges with es, merging the nodes of edges with the same label. n is
+// returned if nothing changed.
+func mergeEdges(n *Node, leaf *leafNode, es edges) *Node {
+	merged := make(edges, 0, len(n.edges)+len(es))
+	changed := leaf != n.leaf
+	i, j := 0, 0
+	for i < len(n.edges) || j < len(es) {
+		switch {
+		case j == len(es) || i < len(n.edges) && n.edges[i].label < es[j].label:
+			merged = append(merged, n.edges[i])
+			i++
+		case i == len(n.edges) || es[j].label < n.edges[i].label:
+			merged = append(merged, es[j])
+			changed = true
+			j++
+		default:
+			child := unionNodes(n.edges[i].node, es[j].node)
+			changed = changed || child != n.edges[i].node
+			merged = append(merged, edge{label: n.edges[i].label, node: child})
+			i++
+			j++
+		}
+	}
+	if !changed {
+		return n
+	}
+
+	nc := &Node{leaf: leaf, prefix: n.prefix, edges: merged}
+	if leaf != nil {
+		nc.size = 1
+	}
+	for _, e := range merged {
+		nc.size += e.node.size
+	}
+	return nc
+}
+
+// withPrefix returns n itself if it already has the given prefix, or else a
+// copy of it with that prefix, sharing its leaf and edges
+func withPrefix(n *Node, prefix []byte) *Node {
+	if len(prefix) == len(n.prefix) {
+		return n
+	}
+	return &Node{leaf: n.leaf, prefix: prefix, edges: n.edges, size: n.size}
+}
+
+// Intersect returns a set holding the keys in both sets. The keys only in
+// this set are removed from it, which are found with Diff, as for Union.
+func (s *Set) Intersect(o *Set) *Set {
+	txn := s.tree.Txn()
+	Diff(s.tree, o.tree, func(op DiffOp, k []byte, _, _ interface{}) bool {
+		if op == DiffRemoved {
+			txn.Delete(k)
+		}
+		return false
+	})
+	return s.commit(txn)
+}
+
+// Difference returns a set holding the keys of this set that aren't in o.
+// When those are fewer than the keys the sets have in common, as found with
+// Diff, the set is built from them, and otherwise the keys of o are removed
+// from this set.
+func (s *Set) Difference(o *Set) *Set {
+	var only [][]byte
+	Diff(s.tree, o.tree, func(op DiffOp, k []byte, _, _ interface{}) bool {
+		if op == DiffRemoved {
+			only = append(only, k)
+		}
+		return false
+	})
+	if len(only) == s.Len() {
+		return s
+	}
+
+	if len(only) < s.Len()-len(only) {
+		// The keys are found in order, so they can be built straight into
+		// a tree
+		tree, err := BuildSorted(func() ([]byte, interface{}, bool) {
+			if len(only) == 0 {
+				return nil, nil, false
+			}
+			k := only[0]
+			only = only[1:]
+			return k, nil, true
+		})
+		if err != nil {
+			panic(err)
+		}
+		return &Set{tree: tree}
+	}
+
+	txn := s.tree.Txn()
+	o.Walk(func(k []byte) bool {
+		txn.Delete(k)
+		return false
+	})
+	return s.commit(txn)
+}
+
+// commit returns the set made by the transaction, which is this set if the
+// transaction didn't change anything
+func (s *Set) commit(txn *Txn) *Set {
+	tree, changed := txn.Commit()
+	if !changed {
+		return s
+	}
+	return &Set{tree: tree}
+}
@@ -0,0 +1,226 @@
+package iradix
+
+import (
+	"fmt"
+	"math/rand"
+	"reflect"
+	"sort"
+	"testing"
+)
+
+func setKeys(s *Set) []string {
+	keys := []string{}
+	s.Walk(func(k []byte) bool {
+		keys = append(keys, string(k))
+		return false
+	})
+	return keys
+}
+
+func newTestSet(keys ...string) *Set {
+	s := NewSet()
+	for _, k := range keys {
+		s = s.Add([]byte(k))
+	}
+	return s
+}
+
+func TestSet(t *testing.T) {
+	s := newTestSet("foo", "foo/bar", "zip")
+	if s2 := s.Add([]byte("foo")); s2 != s {
+		t.Fatalf("expected the same set")
+	}
+	if s2 := s.Remove([]byte("nope")); s2 != s {
+		t.Fatalf("expected the same set")
+	}
+
+	s2 := s.Add([]byte("baz")).Remove([]byte("foo"))
+	if want := []string{"baz", "foo/bar", "zip"}; !reflect.DeepEqual(setKeys(s2), want) {
+		t.Fatalf("bad: %v", setKeys(s2))
+	}
+	if !s2.Contains([]byte("baz")) || s2.Contains([]byte("foo")) || s2.Len() != 3 {
+		t.Fatalf("bad set")
+	}
+
+	// The original is unchanged
+	if want := []string{"foo", "foo/bar", "zip"}; !reflect.DeepEqual(setKeys(s), want) {
+		t.Fatalf("bad: %v", setKeys(s))
+	}
+}
+
+func TestSetOperations(t *testing.T) {
+	a := newTestSet("a", "b", "foo", "foo/bar", "zip")
+	b := newTestSet("b", "foo/bar", "foo/baz", "zap")
+
+	cases := []struct {
+		name string
+		out  *Set
+		want []string
+	}{
+		{"union", a.Union(b), []string{"a", "b", "foo", "foo/bar", "foo/baz", "zap", "zip"}},
+		{"intersect", a.Intersect(b), []string{"b", "foo/bar"}},
+		{"difference", a.Difference(b), []string{"a", "foo", "zip"}},
+		{"reverse difference", b.Difference(a), []string{"foo/baz", "zap"}},
+		{"empty union", NewSet().Union(b), setKeys(b)},
+		{"empty intersect", a.Intersect(NewSet()), []string{}},
+		{"empty difference", a.Difference(NewSet()), setKeys(a)},
+		{"self difference", a.Difference(a), []string{}},
+	}
+	for _, c := range cases {
+		if !reflect.DeepEqual(setKeys(c.out), c.want) || c.out.Len() != len(c.want) {
+			t.Fatalf("bad %s: %v", c.name, setKeys(c.out))
+		}
+		if err := c.out.Tree().Verify(); err != nil {
+			t.Fatalf("err %s: %v", c.name, err)
+		}
+	}
+
+	// Unchanged sets are returned as they are
+	if a.Union(a) != a || a.Intersect(a) != a || a.Difference(NewSet()) != a {
+		t.Fatalf("expected the same set")
+	}
+}
+
+func TestSetOperationsRandom(t *testing.T) {
+	// Derive b from a, so they share most of their structure
+	var a *Set
+	inA := make(map[string]bool)
+	txn := New().Txn()
+	for i := 0; i < 1000; i++ {
+		k := fmt.Sprintf("%x", rand.Intn(1<<16))
+		txn.Insert([]byte(k), nil)
+		inA[k] = true
+	}
+	tree, _ := txn.Commit()
+	a = &Set{tree: tree}
+
+	b := a
+	inB := make(map[string]bool)
+	for k := range inA {
+		inB[k] = true
+	}
+	for i := 0; i < 50; i++ {
+		k := fmt.Sprintf("%x", rand.Intn(1<<16))
+		if rand.Intn(2) == 0 {
+			b = b.Add([]byte(k))
+			inB[k] = true
+		} else {
+			b = b.Remove([]byte(k))
+			delete(inB, k)
+		}
+	}
+
+	expect := func(pred func(k string) bool) []string {
+		keys := []string{}
+		for k := range inA {
+			if pred(k) {
+				keys = append(keys, k)
+			}
+		}
+		for k := range inB {
+			if !inA[k] && pred(k) {
+				keys = append(keys, k)
+			}
+		}
+		sort.Strings(keys)
+		return keys
+	}
+	union := expect(func(k string) bool { return inA[k] || inB[k] })
+	intersect := expect(func(k string) bool { return inA[k] && inB[k] })
+	aOnly := expect(func(k string) bool { return inA[k] && !inB[k] })
+	bOnly := expect(func(k string) bool { return inB[k] && !inA[k] })
+
+	if out := setKeys(a.Union(b)); !reflect.DeepEqual(out, union) {
+		t.Fatalf("bad union")
+	}
+	if out := setKeys(a.Intersect(b)); !reflect.DeepEqual(out, intersect) {
+		t.Fatalf("bad intersect")
+	}
+	if out := setKeys(a.Difference(b)); !reflect.DeepEqual(out, aOnly) {
+		t.Fatalf("bad difference")
+	}
+	if out := setKeys(b.Difference(a)); !reflect.DeepEqual(out, bOnly) {
+		t.Fatalf("bad difference")
+	}
+}
+
+func TestSetUnionStructural(t *testing.T) {
+	newSet := func(keys ...string) *Set {
+		s := NewSet()
+		for _, k := range keys {
+			s = s.Add([]byte(k))
+		}
+		return s
+	}
+	a := newSet("foo/a", "foo/b", "zap")
+	b := newSet("foo/c", "foo/ca", "zip/x", "zip/y", "bar", "", "zapper")
+
+	u := a.Union(b)
+	want := []string{"", "bar", "foo/a", "foo/b", "foo/c", "foo/ca", "zap", "zapper", "zip/x", "zip/y"}
+	if out := setKeys(u); !reflect.DeepEqual(out, want) {
+		t.Fatalf("bad: %v", out)
+	}
+	if err := u.Tree().Verify(); err != nil {
+		t.Fatalf("err: %v", err)
+	}
+
+	// Subtrees of b with no counterpart in a are grafted as they are
+	child := func(s *Set, prefix string) *Node {
+		n, _ := s.Tree().Root().seekPrefix([]byte(prefix))
+		return n
+	}
+	for _, prefix := range []string{"bar", "zip/"} {
+		if child(u, prefix) != child(b, prefix) {
+			t.Fatalf("expected %q to be shared", prefix)
+		}
+	}
+
+	// A node hanging lower in the result gets a shorter prefix, but its
+	// children are still shared
+	if child(u, "foo/c").edges[0].node != child(b, "foo/c").edges[0].node {
+		t.Fatalf("expected the children of foo/c to be shared")
+	}
+
+	// Unions that add nothing return the receiver
+	if u.Union(a) != u || u.Union(b) != u {
+		t.Fatalf("expected the same set")
+	}
+}
+
+func TestSetUnionRandom(t *testing.T) {
+	rnd := rand.New(rand.NewSource(1))
+	key := func() string {
+		b := make([]byte, rnd.Intn(5))
+		for i := range b {
+			b[i] = "abc"[rnd.Intn(3)]
+		}
+		return string(b)
+	}
+	for round := 0; round < 200; round++ {
+		a, b := NewSet(), NewSet()
+		want := make(map[string]bool)
+		for i := rnd.Intn(20); i > 0; i-- {
+			k := key()
+			a = a.Add([]byte(k))
+			want[k] = true
+		}
+		for i := rnd.Intn(20); i > 0; i-- {
+			k := key()
+			b = b.Add([]byte(k))
+			want[k] = true
+		}
+
+		u := a.Union(b)
+		if err := u.Tree().Verify(); err != nil {
+			t.Fatalf("err: %v", err)
+		}
+		keys := []string{}
+		for k := range want {
+			keys = append(keys, k)
+		}
+		sort.Strings(keys)
+		if out := setKeys(u); !reflect.DeepEqual(out, keys) {
+			t.Fatalf("bad: %v %v", out, keys)
+		}
+	}
+}
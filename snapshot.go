@@ -0,0 +1,48 @@
+package iradix
+
+// ReadSnapshot is a read-only handle on a single version of a tree, as
+// returned by Tree.ReadSnapshot. Every tree is already immutable, so this
+// adds nothing a *Tree can't do, but it makes it plain that the reader sees
+// a fixed version while writers go on committing new trees. Like a Tree,
+// it's safe to use concurrently without coordination.
+type ReadSnapshot struct {
+	tree *Tree
+}
+
+// ReadSnapshot returns a read-only handle on the current version of the
+// tree. Unlike Snapshot, it doesn't clone any values.
+func (t *Tree) ReadSnapshot() *ReadSnapshot {
+	return &ReadSnapshot{tree: t}
+}
+
+// Get is used to lookup a specific key, returning the value and if it was
+// found
+func (s *ReadSnapshot) Get(k []byte) (interface{}, bool) {
+	return s.tree.Get(k)
+}
+
+// Walk is used to walk the keys of the snapshot in order
+func (s *ReadSnapshot) Walk(fn WalkFn) {
+	s.tree.root.Walk(fn)
+}
+
+// WalkPrefix is used to walk the keys of the snapshot under a prefix, in
+// order
+func (s *ReadSnapshot) WalkPrefix(prefix []byte, fn WalkFn) {
+	s.tree.root.WalkPrefix(s.tree.opts.path(prefix), fn)
+}
+
+// Iterator returns an Iterator over the snapshot
+func (s *ReadSnapshot) Iterator() *Iterator {
+	return s.tree.Iterator()
+}
+
+// Len returns the number of keys in the snapshot
+func (s *ReadSnapshot) Len() int {
+	return s.tree.size
+}
+
+// Tree returns the version of the tree the snapshot reads
+func (s *ReadSnapshot) Tree() *Tree {
+	return s.tree
+}
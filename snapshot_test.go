@@ -0,0 +1,99 @@
+package iradix
+
+import (
+	"fmt"
+	"sync"
+	"sync/atomic"
+	"testing"
+)
+
+func TestReadSnapshot(t *testing.T) {
+	r := New()
+	for _, k := range []string{"foo", "foo/bar", "zip"} {
+		r, _, _ = r.Insert([]byte(k), k)
+	}
+	s := r.ReadSnapshot()
+
+	// Later versions don't affect the snapshot
+	r2, _, _ := r.Insert([]byte("foo/baz"), "foo/baz")
+	r2, _, _ = r2.Delete([]byte("zip"))
+	if v, ok := s.Get([]byte("zip")); !ok || v != "zip" {
+		t.Fatalf("bad: %v %v", v, ok)
+	}
+	if _, ok := s.Get([]byte("foo/baz")); ok {
+		t.Fatalf("unexpected key")
+	}
+	var out []string
+	s.WalkPrefix([]byte("foo"), func(k []byte, v interface{}) bool {
+		out = append(out, string(k))
+		return false
+	})
+	if fmt.Sprint(out) != "[foo foo/bar]" {
+		t.Fatalf("bad: %v", out)
+	}
+	if s.Tree() != r || r2.ReadSnapshot().Tree() != r2 {
+		t.Fatalf("bad tree")
+	}
+}
+
+func TestReadSnapshotConcurrent(t *testing.T) {
+	// A writer commits versions in which every key has the same value,
+	// while readers check that each snapshot they take stays consistent.
+	// Run with -race to check that no reads race with the writes.
+	const keys, versions, readers = 100, 200, 4
+	var current atomic.Value
+	txn := New().Txn()
+	for i := 0; i < keys; i++ {
+		txn.Insert([]byte(fmt.Sprintf("key/%03d", i)), 0)
+	}
+	r, _ := txn.Commit()
+	current.Store(r)
+
+	var wg sync.WaitGroup
+	done := make(chan struct{})
+	for i := 0; i < readers; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for {
+				select {
+				case <-done:
+					return
+				default:
+				}
+
+				s := current.Load().(*Tree).ReadSnapshot()
+				want, _ := s.Get([]byte("key/000"))
+				n := 0
+				s.Walk(func(k []byte, v interface{}) bool {
+					if v != want {
+						t.Errorf("inconsistent snapshot: %s=%v, want %v", k, v, want)
+					}
+					n++
+					return false
+				})
+				it := s.Iterator()
+				it.SeekPrefix([]byte("key/05"))
+				for _, v, ok := it.Next(); ok; _, v, ok = it.Next() {
+					if v != want {
+						t.Errorf("inconsistent snapshot: %v, want %v", v, want)
+					}
+				}
+				if n != keys {
+					t.Errorf("bad: %d", n)
+				}
+			}
+		}()
+	}
+
+	for version := 1; version <= versions; version++ {
+		txn := current.Load().(*Tree).Txn()
+		for i := 0; i < keys; i++ {
+			txn.Insert([]byte(fmt.Sprintf("key/%03d", i)), version)
+		}
+		r, _ := txn.Commit()
+		current.Store(r)
+	}
+	close(done)
+	wg.Wait()
+}
@@ -0,0 +1,102 @@
+package iradix
+
+import "unsafe"
+
+// ByteSizer can be implemented by values to report the size of their payload
+// in bytes, beyond the interface that holds them, for ApproxSize and Stats
+type ByteSizer interface {
+	ByteSize() int
+}
+
+// TreeStats describes the shape of a tree, as returned by Tree.Stats, such as
+// to see how well a key encoding compresses into edges
+type TreeStats struct {
+	// LeafCount is the number of nodes with a leaf, which is the number of
+	// keys, and InternalCount is the number of nodes without one, which
+	// only branch, including an empty root
+	LeafCount     int
+	InternalCount int
+
+	// MaxDepth is the largest number of edges followed from the root to
+	// reach a node
+	MaxDepth int
+
+	// AvgPrefixLen is the average length of the prefixes of the nodes below
+	// the root, which is the number of key bytes each edge consumes
+	AvgPrefixLen float64
+
+	// EdgesPerNode is a histogram of the number of edges each node has, so
+	// that EdgesPerNode[i] is the number of nodes with i edges
+	EdgesPerNode []int
+
+	// ApproxSize is the approximate memory used by the tree, as returned by
+	// ApproxSize
+	ApproxSize int
+}
+
+// Stats returns statistics on the shape of the tree, gathered in a single
+// walk over its nodes
+func (t *Tree) Stats() TreeStats {
+	var s TreeStats
+	var prefixLen int
+	var walk func(n *Node, depth int)
+	walk = func(n *Node, depth int) {
+		if n.leaf != nil {
+			s.LeafCount++
+		} else {
+			s.InternalCount++
+		}
+		if depth > s.MaxDepth {
+			s.MaxDepth = depth
+		}
+		prefixLen += len(n.prefix)
+		for len(s.EdgesPerNode) <= len(n.edges) {
+			s.EdgesPerNode = append(s.EdgesPerNode, 0)
+		}
+		s.EdgesPerNode[len(n.edges)]++
+		s.ApproxSize += nodeSize(n)
+		for _, e := range n.edges {
+			walk(e.node, depth+1)
+		}
+	}
+	walk(t.root, 0)
+
+	if nodes := s.LeafCount + s.InternalCount - 1; nodes > 0 {
+		s.AvgPrefixLen = float64(prefixLen) / float64(nodes)
+	}
+	return s
+}
+
+// ApproxSize returns an estimate of the memory used by the tree, in bytes.
+// It counts the nodes, edges and leaves, the capacity of their prefixes and
+// keys, and the payload of values that implement ByteSizer. Other values
+// count only for the interface that holds them. Memory shared between
+// nodes, such as a prefix and key that were compacted together, or with
+// other versions of the tree, is counted in full for each. So the estimate
+// overcounts shared memory and undercounts opaque payloads, and isn't a
+// bound either way on what releasing the tree would free.
+func (t *Tree) ApproxSize() int {
+	size := 0
+	var walk func(n *Node)
+	walk = func(n *Node) {
+		size += nodeSize(n)
+		for _, e := range n.edges {
+			walk(e.node)
+		}
+	}
+	walk(t.root)
+	return size
+}
+
+// nodeSize returns the approximate memory used by n, not counting its
+// children
+func nodeSize(n *Node) int {
+	size := int(unsafe.Sizeof(*n)) + cap(n.prefix) + cap(n.edges)*int(unsafe.Sizeof(edge{}))
+	if n.leaf != nil {
+		size += int(unsafe.Sizeof(*n.leaf)) + cap(n.leaf.key)
+		if sizer, ok := n.leaf.val.(ByteSizer); ok {
+			size += sizer.ByteSize()
+		}
+	}
+	return size
+}
@@ -0,0 +1,62 @@
+package iradix
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestTreeStats(t *testing.T) {
+	empty := New()
+	if s := empty.Stats(); !reflect.DeepEqual(s, TreeStats{InternalCount: 1, EdgesPerNode: []int{1}, ApproxSize: empty.ApproxSize()}) {
+		t.Fatalf("bad: %#v", s)
+	}
+
+	r := New()
+	for _, k := range []string{"foo", "foo/bar", "foo/baz", "foobar", "zip"} {
+		r, _, _ = r.Insert([]byte(k), nil)
+	}
+
+	// The tree looks like:
+	//
+	//   "" -> "foo" -> "/ba" -> "r"
+	//      |        |        -> "z"
+	//      |        -> "bar"
+	//      -> "zip"
+	want := TreeStats{
+		LeafCount:     5,
+		InternalCount: 2,
+		MaxDepth:      3,
+		AvgPrefixLen:  float64(3+3+1+1+3+3) / 6,
+		EdgesPerNode:  []int{4, 0, 3},
+		ApproxSize:    r.ApproxSize(),
+	}
+	if s := r.Stats(); !reflect.DeepEqual(s, want) {
+		t.Fatalf("bad: %#v", s)
+	}
+}
+
+type sizedValue int
+
+func (v sizedValue) ByteSize() int {
+	return int(v)
+}
+
+func TestTreeApproxSize(t *testing.T) {
+	keys := []string{"foo", "foo/bar", "foo/baz", "foobar", "zip"}
+	plain, sized := New(), New()
+	for i, k := range keys {
+		plain, _, _ = plain.Insert([]byte(k), i)
+		sized, _, _ = sized.Insert([]byte(k), sizedValue(100*i))
+	}
+
+	// The trees have the same shape, so differ by the payloads
+	if plain.ApproxSize() <= New().ApproxSize() {
+		t.Fatalf("bad: %d", plain.ApproxSize())
+	}
+	if diff := sized.ApproxSize() - plain.ApproxSize(); diff != 1000 {
+		t.Fatalf("bad: %d", diff)
+	}
+	if s := sized.Stats(); s.ApproxSize != sized.ApproxSize() {
+		t.Fatalf("bad: %d", s.ApproxSize)
+	}
+}
@@ -0,0 +1,59 @@
+package iradix
+
+// SubtreeIterator is used to iterate over the subtrees found at a given
+// depth below a prefix, in order
+type SubtreeIterator struct {
+	stack []subtreeFrame
+	depth int
+}
+
+// subtreeFrame is a node waiting to be visited, along with its full path
+type subtreeFrame struct {
+	node *Node
+	path []byte
+}
+
+// SubtreeIterator returns an iterator over the subtrees that hold the keys
+// under the given prefix, grouped by their first len(prefix)+depth bytes.
+// Each subtree is returned once, along with the prefix of that length that
+// its keys share. Keys under the prefix that are shorter than that aren't in
+// any of the subtrees. This can be used to aggregate over a tree level by
+// level without visiting every key, by further iterating, walking or
+// counting the returned nodes. Keys of the returned nodes are still full
+// keys, so their prefix must be included when seeking within them.
+func (n *Node) SubtreeIterator(prefix []byte, depth int) *SubtreeIterator {
+	it := &SubtreeIterator{depth: len(prefix)}
+	if depth > 0 {
+		it.depth += depth
+	}
+	if root, path := n.seekPrefix(prefix); root != nil {
+		it.stack = []subtreeFrame{{node: root, path: concat(nil, path)}}
+	}
+	return it
+}
+
+// Next returns the next subtree and the prefix it's grouped by, or false
+// when there are no more
+func (i *SubtreeIterator) Next() ([]byte, *Node, bool) {
+	for len(i.stack) > 0 {
+		// Pop the next node off the stack
+		n := len(i.stack)
+		frame := i.stack[n-1]
+		i.stack = i.stack[:n-1]
+
+		// The first node whose path reaches the depth roots the subtree
+		if len(frame.path) >= i.depth {
+			return frame.path[:i.depth:i.depth], frame.node, true
+		}
+
+		// Push the edges in reverse, so that the smallest is popped first
+		for idx := len(frame.node.edges) - 1; idx >= 0; idx-- {
+			child := frame.node.edges[idx].node
+			i.stack = append(i.stack, subtreeFrame{
+				node: child,
+				path: concat(frame.path, child.prefix),
+			})
+		}
+	}
+	return nil, nil, false
+}
@@ -0,0 +1,93 @@
+package iradix
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestSubtreeIterator(t *testing.T) {
+	r := New()
+	keys := []string{
+		"a",
+		"a/b/c",
+		"a/b/d",
+		"a/bc",
+		"a/x",
+		"a/xyz/1",
+		"b/c",
+	}
+	for _, k := range keys {
+		r, _, _ = r.Insert([]byte(k), nil)
+	}
+
+	cases := []struct {
+		prefix string
+		depth  int
+		want   map[string][]string
+		order  []string
+	}{
+		{
+			"", 1,
+			map[string][]string{
+				"a": {"a", "a/b/c", "a/b/d", "a/bc", "a/x", "a/xyz/1"},
+				"b": {"b/c"},
+			},
+			[]string{"a", "b"},
+		},
+		{
+			"a/", 1,
+			map[string][]string{
+				"a/b": {"a/b/c", "a/b/d", "a/bc"},
+				"a/x": {"a/x", "a/xyz/1"},
+			},
+			[]string{"a/b", "a/x"},
+		},
+		{
+			"a/", 2,
+			map[string][]string{
+				"a/b/": {"a/b/c", "a/b/d"},
+				"a/bc": {"a/bc"},
+				"a/xy": {"a/xyz/1"},
+			},
+			[]string{"a/b/", "a/bc", "a/xy"},
+		},
+		{
+			"a/x", 0,
+			map[string][]string{
+				"a/x": {"a/x", "a/xyz/1"},
+			},
+			[]string{"a/x"},
+		},
+		{
+			"a/xy", 1,
+			map[string][]string{
+				"a/xyz": {"a/xyz/1"},
+			},
+			[]string{"a/xyz"},
+		},
+		{
+			"c", 1,
+			map[string][]string{},
+			[]string{},
+		},
+	}
+
+	for _, c := range cases {
+		it := r.Root().SubtreeIterator([]byte(c.prefix), c.depth)
+		got := map[string][]string{}
+		order := []string{}
+		for p, n, ok := it.Next(); ok; p, n, ok = it.Next() {
+			order = append(order, string(p))
+			n.Walk(func(k []byte, _ interface{}) bool {
+				got[string(p)] = append(got[string(p)], string(k))
+				return false
+			})
+		}
+		if !reflect.DeepEqual(order, c.order) {
+			t.Fatalf("bad order: %q %d %v", c.prefix, c.depth, order)
+		}
+		if !reflect.DeepEqual(got, c.want) {
+			t.Fatalf("bad subtrees: %q %d %v", c.prefix, c.depth, got)
+		}
+	}
+}
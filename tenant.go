@@ -0,0 +1,97 @@
+package iradix
+
+// TenantStore is an immutable collection of trees, one per tenant, which is
+// itself held in a tree keyed by tenant id. Each version of the store
+// captures the state of every tenant at once, such as for a consistent
+// backup, while tenants left unchanged by an update share their trees with
+// the previous version. Like a Tree, it's safe to use concurrently.
+type TenantStore struct {
+	tenants *Tree
+}
+
+// NewTenantStore returns an empty TenantStore
+func NewTenantStore() *TenantStore {
+	return &TenantStore{tenants: New()}
+}
+
+// Tenant returns the tree of the given tenant, and whether it has one
+func (s *TenantStore) Tenant(id []byte) (*Tree, bool) {
+	tree, ok := s.tenants.Get(id)
+	if !ok {
+		return nil, false
+	}
+	return tree.(*Tree), true
+}
+
+// Update returns a new store in which fn has updated the tree of the given
+// tenant, which is created if it doesn't have one
+func (s *TenantStore) Update(id []byte, fn func(txn *Txn)) *TenantStore {
+	txn := s.Txn()
+	fn(txn.Tenant(id))
+	return txn.Commit()
+}
+
+// Len returns the number of tenants in the store
+func (s *TenantStore) Len() int {
+	return s.tenants.Len()
+}
+
+// Tree returns the tree holding the tenants, whose values are their *Tree
+func (s *TenantStore) Tree() *Tree {
+	return s.tenants
+}
+
+// Txn starts a new transaction that can update any number of tenants,
+// committing them all together
+func (s *TenantStore) Txn() *TenantTxn {
+	return &TenantTxn{txn: s.tenants.Txn(), open: make(map[string]*Txn)}
+}
+
+// TenantTxn is a transaction on a TenantStore
+type TenantTxn struct {
+	txn *Txn
+
+	// open holds the transactions started on tenants' trees, by id
+	open map[string]*Txn
+}
+
+// Tenant returns a transaction on the tree of the given tenant, which starts
+// out empty if the tenant doesn't have one yet. It's committed along with
+// the TenantTxn, so the same transaction is returned each time it's asked
+// for, and it mustn't be committed itself.
+func (t *TenantTxn) Tenant(id []byte) *Txn {
+	if txn, ok := t.open[string(id)]; ok {
+		return txn
+	}
+	tree := New()
+	if existing, ok := t.txn.Get(id); ok {
+		tree = existing.(*Tree)
+	}
+	txn := tree.Txn()
+	t.open[string(id)] = txn
+	return txn
+}
+
+// DeleteTenant removes the given tenant and its tree, discarding any changes
+// made to it in this transaction. Returns whether the tenant existed.
+func (t *TenantTxn) DeleteTenant(id []byte) bool {
+	delete(t.open, string(id))
+	_, ok := t.txn.Delete(id)
+	return ok
+}
+
+// Commit is used to finalize the transaction, committing the trees of the
+// tenants it changed, and returning the new store. Tenants whose trees
+// weren't changed keep them as they were, and tenants that were only looked
+// at aren't created.
+func (t *TenantTxn) Commit() *TenantStore {
+	for id, txn := range t.open {
+		tree, changed := txn.Commit()
+		if changed {
+			t.txn.Insert([]byte(id), tree)
+		}
+	}
+	t.open = make(map[string]*Txn)
+	tenants, _ := t.txn.Commit()
+	return &TenantStore{tenants: tenants}
+}
@@ -0,0 +1,76 @@
+package iradix
+
+import (
+	"fmt"
+	"testing"
+)
+
+func TestTenantStore(t *testing.T) {
+	s := NewTenantStore()
+	txn := s.Txn()
+	for _, id := range []string{"acme", "globex", "initech"} {
+		tenant := txn.Tenant([]byte(id))
+		for i := 0; i < 100; i++ {
+			tenant.Insert([]byte(fmt.Sprintf("%s/%03d", id, i)), i)
+		}
+	}
+
+	// Tenants that are only looked at aren't created
+	txn.Tenant([]byte("nobody")).Get([]byte("foo"))
+	s1 := txn.Commit()
+	if s1.Len() != 3 {
+		t.Fatalf("bad: %d", s1.Len())
+	}
+	if _, ok := s1.Tenant([]byte("nobody")); ok {
+		t.Fatalf("expected no tenant")
+	}
+
+	// Updating one tenant leaves the others' trees as they were
+	s2 := s1.Update([]byte("globex"), func(txn *Txn) {
+		txn.Insert([]byte("globex/new"), -1)
+		txn.Delete([]byte("globex/000"))
+	})
+	for _, id := range []string{"acme", "initech"} {
+		before, _ := s1.Tenant([]byte(id))
+		after, _ := s2.Tenant([]byte(id))
+		if before != after {
+			t.Fatalf("tenant %s was copied", id)
+		}
+	}
+	before, _ := s1.Tenant([]byte("globex"))
+	after, _ := s2.Tenant([]byte("globex"))
+	if before == after || before.Len() != 100 || after.Len() != 100 {
+		t.Fatalf("bad: %d %d", before.Len(), after.Len())
+	}
+	if _, ok := after.Get([]byte("globex/new")); !ok {
+		t.Fatalf("missing key")
+	}
+	if _, ok := before.Get([]byte("globex/new")); ok {
+		t.Fatalf("snapshot was modified")
+	}
+
+	// Within the tenant's tree, untouched subtrees are shared too
+	beforeSub, _ := before.Root().seekPrefix([]byte("globex/09"))
+	afterSub, _ := after.Root().seekPrefix([]byte("globex/09"))
+	if beforeSub == nil || beforeSub != afterSub {
+		t.Fatalf("subtree was copied")
+	}
+
+	// The same tenant transaction is returned within a transaction, and
+	// deleting a tenant discards its changes
+	txn = s2.Txn()
+	if txn.Tenant([]byte("acme")) != txn.Tenant([]byte("acme")) {
+		t.Fatalf("expected the same transaction")
+	}
+	txn.Tenant([]byte("acme")).Insert([]byte("acme/new"), nil)
+	if !txn.DeleteTenant([]byte("acme")) || txn.DeleteTenant([]byte("nobody")) {
+		t.Fatalf("bad delete")
+	}
+	s3 := txn.Commit()
+	if _, ok := s3.Tenant([]byte("acme")); ok || s3.Len() != 2 {
+		t.Fatalf("expected acme to be deleted")
+	}
+	if _, ok := s2.Tenant([]byte("acme")); !ok {
+		t.Fatalf("snapshot was modified")
+	}
+}
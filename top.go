@@ -0,0 +1,80 @@
+package iradix
+
+import (
+	"container/heap"
+	"sort"
+)
+
+// Entry is a key and its value
+type Entry struct {
+	Key []byte
+	Val interface{}
+}
+
+// scored is an entry with its score, and its position in key order, which
+// breaks ties in favour of earlier keys
+type scored struct {
+	Entry
+	score float64
+	seq   int
+}
+
+// worse returns whether a ranks below b
+func (a *scored) worse(b *scored) bool {
+	if a.score != b.score {
+		return a.score < b.score
+	}
+	return a.seq > b.seq
+}
+
+// topHeap is a min-heap of entries, with the lowest ranked at the top
+type topHeap []*scored
+
+func (h topHeap) Len() int            { return len(h) }
+func (h topHeap) Less(i, j int) bool  { return h[i].worse(h[j]) }
+func (h topHeap) Swap(i, j int)       { h[i], h[j] = h[j], h[i] }
+func (h *topHeap) Push(x interface{}) { *h = append(*h, x.(*scored)) }
+func (h *topHeap) Pop() interface{} {
+	old := *h
+	x := old[len(old)-1]
+	*h = old[:len(old)-1]
+	return x
+}
+
+// TopPrefix returns the n entries under the given prefix with the highest
+// scores, as given by score for each value, in descending order of score.
+// Entries with equal scores are ranked in key order. Only the best n
+// entries found so far are held during the walk, rather than all of them.
+func (n *Node) TopPrefix(prefix []byte, num int, score func(v interface{}) float64) []Entry {
+	if num <= 0 {
+		return nil
+	}
+	// The heap never holds more than the entries under the prefix, however
+	// large num is
+	size := num
+	if count := n.CountPrefix(prefix); count < size {
+		size = count
+	}
+	h := make(topHeap, 0, size)
+	seq := 0
+	n.WalkPrefix(prefix, func(k []byte, v interface{}) bool {
+		s := &scored{Entry: Entry{Key: k, Val: v}, score: score(v), seq: seq}
+		seq++
+		if len(h) < num {
+			heap.Push(&h, s)
+		} else if h[0].worse(s) {
+			h[0] = s
+			heap.Fix(&h, 0)
+		}
+		return false
+	})
+
+	sort.Slice(h, func(i, j int) bool {
+		return h[j].worse(h[i])
+	})
+	top := make([]Entry, len(h))
+	for i, s := range h {
+		top[i] = s.Entry
+	}
+	return top
+}
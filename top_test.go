@@ -0,0 +1,75 @@
+package iradix
+
+import (
+	"fmt"
+	"math"
+	"math/rand"
+	"reflect"
+	"sort"
+	"testing"
+)
+
+func TestNodeTopPrefix(t *testing.T) {
+	r := New()
+	hits := map[string]int{
+		"car": 5, "card": 9, "care": 2, "cart": 9, "cat": 7, "dog": 100,
+	}
+	for k, v := range hits {
+		r, _, _ = r.Insert([]byte(k), v)
+	}
+	score := func(v interface{}) float64 { return float64(v.(int)) }
+
+	cases := []struct {
+		prefix string
+		n      int
+		want   []string
+	}{
+		{"ca", 3, []string{"card", "cart", "cat"}},
+		{"car", 2, []string{"card", "cart"}},
+		{"car", 10, []string{"card", "cart", "car", "care"}},
+		{"", 1, []string{"dog"}},
+		{"x", 3, []string{}},
+		{"ca", 0, []string{}},
+		{"car", math.MaxInt, []string{"card", "cart", "car", "care"}},
+		{"x", math.MaxInt, []string{}},
+	}
+	for _, c := range cases {
+		out := []string{}
+		for _, e := range r.Root().TopPrefix([]byte(c.prefix), c.n, score) {
+			if e.Val != hits[string(e.Key)] {
+				t.Fatalf("bad: %q %v", e.Key, e.Val)
+			}
+			out = append(out, string(e.Key))
+		}
+		if !reflect.DeepEqual(out, c.want) {
+			t.Fatalf("bad: %q %d %v", c.prefix, c.n, out)
+		}
+	}
+}
+
+func TestNodeTopPrefixRandom(t *testing.T) {
+	r := New()
+	for i := 0; i < 1000; i++ {
+		r, _, _ = r.Insert([]byte(fmt.Sprintf("%04d", i)), rand.Intn(50))
+	}
+	score := func(v interface{}) float64 { return float64(v.(int)) }
+
+	// Compare with sorting every entry under the prefix
+	var all []Entry
+	r.Root().WalkPrefix([]byte("0"), func(k []byte, v interface{}) bool {
+		all = append(all, Entry{Key: k, Val: v})
+		return false
+	})
+	sort.SliceStable(all, func(i, j int) bool {
+		return all[i].Val.(int) > all[j].Val.(int)
+	})
+	for _, n := range []int{1, 10, 100, 2000} {
+		want := all
+		if n < len(want) {
+			want = want[:n]
+		}
+		if out := r.Root().TopPrefix([]byte("0"), n, score); !reflect.DeepEqual(out, want) {
+			t.Fatalf("mis-match: %d", n)
+		}
+	}
+}
@@ -0,0 +1,65 @@
+package iradix
+
+import (
+	"bytes"
+	"fmt"
+)
+
+// Verify checks that the structure of the tree is valid, returning an error
+// describing the first problem found, if any. This is meant for tests and
+// fuzzing, to catch bugs that leave the tree malformed, such as nodes that
+// should have been merged but weren't.
+func (t *Tree) Verify() error {
+	if len(t.root.prefix) != 0 {
+		return fmt.Errorf("root has prefix %q", t.root.prefix)
+	}
+	if err := t.verifyNode(t.root, nil, true); err != nil {
+		return err
+	}
+	if t.root.size != t.size {
+		return fmt.Errorf("tree has %d keys but a length of %d", t.root.size, t.size)
+	}
+	return nil
+}
+
+// verifyNode checks the subtree at n, whose full path is given
+func (t *Tree) verifyNode(n *Node, path []byte, isRoot bool) error {
+	if n.leaf != nil {
+		if p := t.opts.path(n.leaf.key); !bytes.Equal(p, path) {
+			return fmt.Errorf("leaf with key %q is stored at path %q", n.leaf.key, path)
+		}
+	}
+	if !isRoot {
+		if n.leaf == nil && len(n.edges) == 0 {
+			return fmt.Errorf("node at path %q has no leaf or edges", path)
+		}
+		if n.leaf == nil && len(n.edges) == 1 {
+			return fmt.Errorf("node at path %q should be merged with its only child", path)
+		}
+	}
+
+	size := 0
+	if n.leaf != nil {
+		size++
+	}
+	for idx, e := range n.edges {
+		if e.node == nil {
+			return fmt.Errorf("edge %q at path %q has no node", e.label, path)
+		}
+		// This also ensures that only the root has an empty prefix
+		if len(e.node.prefix) == 0 || e.node.prefix[0] != e.label {
+			return fmt.Errorf("edge %q at path %q leads to prefix %q", e.label, path, e.node.prefix)
+		}
+		if idx > 0 && n.edges[idx-1].label >= e.label {
+			return fmt.Errorf("edges at path %q are out of order", path)
+		}
+		if err := t.verifyNode(e.node, concat(path, e.node.prefix), false); err != nil {
+			return err
+		}
+		size += e.node.size
+	}
+	if n.size != size {
+		return fmt.Errorf("node at path %q has a size of %d but holds %d keys", path, n.size, size)
+	}
+	return nil
+}
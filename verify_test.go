@@ -0,0 +1,93 @@
+package iradix
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestVerify(t *testing.T) {
+	r := New()
+	if err := r.Verify(); err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	for _, k := range []string{"", "foo", "foobar", "foobaz", "zip"} {
+		r, _, _ = r.Insert([]byte(k), nil)
+	}
+	r, _, _ = r.Delete([]byte("foobar"))
+	if err := r.Verify(); err != nil {
+		t.Fatalf("err: %v", err)
+	}
+
+	cases := []struct {
+		name    string
+		corrupt func(root *Node)
+		want    string
+	}{
+		{
+			"unmerged",
+			func(root *Node) {
+				root.edges[0].node.leaf = nil
+			},
+			"should be merged",
+		},
+		{
+			"empty",
+			func(root *Node) {
+				n := root.edges[1].node
+				n.leaf, n.edges = nil, nil
+			},
+			"no leaf or edges",
+		},
+		{
+			"unordered",
+			func(root *Node) {
+				root.edges[0], root.edges[1] = root.edges[1], root.edges[0]
+			},
+			"out of order",
+		},
+		{
+			"mislabelled",
+			func(root *Node) {
+				root.edges[1].label = 'y'
+			},
+			"leads to prefix",
+		},
+		{
+			"misplaced",
+			func(root *Node) {
+				root.edges[1].node.leaf.key = []byte("zap")
+			},
+			"is stored at path",
+		},
+		{
+			"empty prefix",
+			func(root *Node) {
+				root.edges[1].node.prefix = nil
+			},
+			"leads to prefix",
+		},
+		{
+			"miscounted",
+			func(root *Node) {
+				root.leaf = nil
+				root.size--
+			},
+			"length of 4",
+		},
+		{
+			"missized",
+			func(root *Node) {
+				root.edges[0].node.size++
+			},
+			"has a size of 3 but holds 2 keys",
+		},
+	}
+	for _, c := range cases {
+		broken := CopyTree(r)
+		c.corrupt(broken.root)
+		err := broken.Verify()
+		if err == nil || !strings.Contains(err.Error(), c.want) {
+			t.Fatalf("%s: bad error: %v", c.name, err)
+		}
+	}
+}